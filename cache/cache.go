@@ -5,6 +5,7 @@
 package cache
 
 import (
+	"strings"
 	"sync"
 	"time"
 )
@@ -33,6 +34,21 @@ func GetAll() map[string]Item {
 	return m.resources
 }
 
+// Items returns a copy of the unexpired items stored in the namespace
+// keyed by their key within the namespace.
+func Items(namespace string) map[string]interface{} {
+	prefix := formKey(namespace, "")
+	items := map[string]interface{}{}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for key, i := range m.resources {
+		if strings.HasPrefix(key, prefix) && !i.isExpired() {
+			items[strings.TrimPrefix(key, prefix)] = i.Src
+		}
+	}
+	return items
+}
+
 // Delete deletes an item from the cache from the namespace
 // for the given key.  Delete may be called even if an item
 // was never set for the namespace and key combination.
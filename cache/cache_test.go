@@ -34,3 +34,16 @@ func TestExpire(t *testing.T) {
 		t.Error("cache should expire item")
 	}
 }
+
+func TestItems(t *testing.T) {
+	cache.Set("items", "a:1", cache.Item{Src: 1})
+	cache.Set("items", "b:2", cache.Item{Src: 2})
+	cache.Set("other", "c:3", cache.Item{Src: 3})
+	items := cache.Items("items")
+	if len(items) != 2 {
+		t.Errorf("cache should of returned 2 items, got %d", len(items))
+	}
+	if items["a:1"] != 1 || items["b:2"] != 2 {
+		t.Error("cache items should be keyed without the namespace")
+	}
+}
@@ -0,0 +1,240 @@
+// Package conf loads the server settings from conf.toml.
+// Settings missing from the file fall back to the defaults below so
+// packages can rely on sane values in tests and local runs.
+package conf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/BurntSushi/toml.v0"
+
+	"github.com/rahul2393/small-assignment-server/logger"
+)
+
+const (
+	path = "./conf.toml"
+)
+
+// Config holds the server settings shared across packages.
+type Config struct {
+	// BaseURL is the public address used in links sent to users.
+	BaseURL  string   `toml:"base_url"`
+	Auth     Auth     `toml:"auth"`
+	Mail     Mail     `toml:"mail"`
+	Password Password `toml:"password"`
+
+	// loadErr is the error of a file that exists but can't be read, see
+	// Check.
+	loadErr error
+}
+
+// Auth holds the settings used to authenticate requests.
+type Auth struct {
+	// AllowQueryToken enables the legacy auth-email and auth-token
+	// query string parameters for clients that can't send headers.
+	AllowQueryToken bool `toml:"allow_query_token"`
+	// TokenStrategy is either "database" or "signed".  Database tokens
+	// are checked against their bcrypt hash, signed tokens carry HMAC
+	// signed claims and are checked against a revocation list.
+	TokenStrategy string `toml:"token_strategy"`
+	// AccessTokenMinutes is the lifetime of an access token.
+	AccessTokenMinutes int64 `toml:"access_token_minutes"`
+	// RefreshTokenDays is the lifetime of a refresh token.
+	RefreshTokenDays int64 `toml:"refresh_token_days"`
+	// SigningKey is the secret signed links and tokens are signed with.
+	// A random key is used when it is empty, so signatures don't survive
+	// a restart; see Check.
+	SigningKey string `toml:"signing_key"`
+	// EmailVerificationHours is the lifetime of an email verification link.
+	EmailVerificationHours int64 `toml:"email_verification_hours"`
+	// EmailIntervalSeconds is the minimum time between two emails of
+	// the same kind, such as verification links, sent to a user.
+	EmailIntervalSeconds int64 `toml:"email_interval_seconds"`
+	// PasswordResetMinutes is the lifetime of a password reset token.
+	PasswordResetMinutes int64 `toml:"password_reset_minutes"`
+	// MaxFailedLogins is the number of consecutive failed logins after
+	// which an account is locked for LockoutMinutes.
+	MaxFailedLogins int64 `toml:"max_failed_logins"`
+	// MaxIPFailedLogins is the number of failed logins from a client IP
+	// within LockoutMinutes after which the IP is slowed down.
+	MaxIPFailedLogins int64 `toml:"max_ip_failed_logins"`
+	// LockoutMinutes is the duration of an account lockout and the window
+	// failed logins are counted in.
+	LockoutMinutes int64 `toml:"lockout_minutes"`
+	// ImpersonationMinutes is the lifetime of an access token an admin
+	// obtains to act as another user.  Impersonation tokens can't be
+	// refreshed.
+	ImpersonationMinutes int64 `toml:"impersonation_minutes"`
+	// GroupSweepSeconds is how often users whose temporary group expired
+	// are moved back to their previous group.
+	GroupSweepSeconds int64 `toml:"group_sweep_seconds"`
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in
+	// front of the server.  The X-Forwarded-For header is only believed
+	// as far as it was written by them.
+	TrustedProxies []string `toml:"trusted_proxies"`
+
+	// generatedKey is set when SigningKey is random.
+	generatedKey bool
+}
+
+// Mail holds the settings used to send emails.
+type Mail struct {
+	// Driver is either "smtp" or "log".  The log driver writes emails
+	// to LogPath, or the server log when empty, instead of sending them.
+	Driver       string `toml:"driver"`
+	From         string `toml:"from"`
+	LogPath      string `toml:"log_path"`
+	SMTPAddr     string `toml:"smtp_addr"`
+	SMTPUsername string `toml:"smtp_username"`
+	SMTPPassword string `toml:"smtp_password"`
+}
+
+// Password holds the password policy.
+type Password struct {
+	MinLength int `toml:"min_length"`
+	// MaxLength is in bytes and can't exceed bcrypt's limit of 72.
+	MaxLength int `toml:"max_length"`
+	// MinCharacterClasses is the number of lower case letters, upper
+	// case letters, digits and symbols a password must mix.
+	MinCharacterClasses int `toml:"min_character_classes"`
+	// BreachedListPath is a file of passwords, one per line, that are
+	// rejected.  No passwords are rejected when it is empty.
+	BreachedListPath string `toml:"breached_list_path"`
+	// History is the number of previous passwords that can't be reused.
+	History int `toml:"history"`
+}
+
+// EmailVerificationLifetime returns the duration a verification link is valid.
+func (a Auth) EmailVerificationLifetime() time.Duration {
+	return time.Duration(a.EmailVerificationHours) * time.Hour
+}
+
+// EmailInterval returns the minimum time between two emails of the
+// same kind sent to a user.
+func (a Auth) EmailInterval() time.Duration {
+	return time.Duration(a.EmailIntervalSeconds) * time.Second
+}
+
+// PasswordResetLifetime returns the duration a password reset token is valid.
+func (a Auth) PasswordResetLifetime() time.Duration {
+	return time.Duration(a.PasswordResetMinutes) * time.Minute
+}
+
+// LockoutDuration returns the duration of an account lockout.
+func (a Auth) LockoutDuration() time.Duration {
+	return time.Duration(a.LockoutMinutes) * time.Minute
+}
+
+// AccessTokenLifetime returns the duration an access token is valid.
+func (a Auth) AccessTokenLifetime() time.Duration {
+	return time.Duration(a.AccessTokenMinutes) * time.Minute
+}
+
+// ImpersonationLifetime returns the duration an impersonation token is valid.
+func (a Auth) ImpersonationLifetime() time.Duration {
+	return time.Duration(a.ImpersonationMinutes) * time.Minute
+}
+
+// GroupSweepInterval returns how often temporary groups are expired.
+func (a Auth) GroupSweepInterval() time.Duration {
+	return time.Duration(a.GroupSweepSeconds) * time.Second
+}
+
+// RefreshTokenLifetime returns the duration a refresh token is valid.
+func (a Auth) RefreshTokenLifetime() time.Duration {
+	return time.Duration(a.RefreshTokenDays) * 24 * time.Hour
+}
+
+var (
+	global *Config
+	once   sync.Once
+)
+
+// Get returns the server configuration. The file is read lazily
+// upon the first call and the result is shared afterwards.
+func Get() *Config {
+	once.Do(func() {
+		global = load(path)
+	})
+	return global
+}
+
+// load reads the configuration from the file.  A missing file leaves the
+// defaults, a malformed one is reported by Check.
+func load(file string) *Config {
+	cfg := defaults()
+	if _, err := toml.DecodeFile(file, cfg); err != nil {
+		logger.ErrorWithMsg("conf: error in reading "+file, err)
+		if !os.IsNotExist(err) {
+			cfg.loadErr = err
+		}
+	}
+	if cfg.Auth.SigningKey == "" {
+		cfg.Auth.SigningKey = randomKey()
+		cfg.Auth.generatedKey = true
+	}
+	return cfg
+}
+
+// Check returns an error if the server can't run with the configuration.
+// Signed tokens and mailed links must outlive a restart, so a random
+// signing key is only good enough for local runs.
+func (c *Config) Check() error {
+	if c.loadErr != nil {
+		return fmt.Errorf("conf: error in reading %s: %v", path, c.loadErr)
+	}
+	if !c.Auth.generatedKey {
+		return nil
+	}
+	if c.Auth.TokenStrategy == "signed" {
+		return errors.New("conf: auth.signing_key is required by the signed token strategy")
+	}
+	if c.Mail.Driver == "smtp" {
+		return errors.New("conf: auth.signing_key is required to mail signed links")
+	}
+	return nil
+}
+
+func defaults() *Config {
+	return &Config{
+		BaseURL: "http://localhost:8000",
+		Auth: Auth{
+			AllowQueryToken:        false,
+			TokenStrategy:          "database",
+			AccessTokenMinutes:     60,
+			RefreshTokenDays:       30,
+			EmailVerificationHours: 48,
+			EmailIntervalSeconds:   60,
+			PasswordResetMinutes:   60,
+			MaxFailedLogins:        5,
+			MaxIPFailedLogins:      20,
+			LockoutMinutes:         15,
+			ImpersonationMinutes:   30,
+			GroupSweepSeconds:      60,
+		},
+		Mail: Mail{
+			Driver: "log",
+			From:   "no-reply@localhost",
+		},
+		Password: Password{
+			MinLength:           8,
+			MaxLength:           72,
+			MinCharacterClasses: 2,
+			History:             5,
+		},
+	}
+}
+
+func randomKey() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,50 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	for _, c := range []struct {
+		strategy, driver string
+		generated, ok    bool
+	}{
+		{"database", "log", true, true},
+		{"signed", "log", true, false},
+		{"database", "smtp", true, false},
+		{"signed", "smtp", false, true},
+	} {
+		cfg := defaults()
+		cfg.Auth.TokenStrategy = c.strategy
+		cfg.Mail.Driver = c.driver
+		cfg.Auth.generatedKey = c.generated
+		assert.Equal(t, c.ok, cfg.Check() == nil, "%+v", c)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// case 1: a missing file leaves the defaults
+	cfg := load(filepath.Join(dir, "missing.toml"))
+	assert.Equal(t, defaults().Auth.TokenStrategy, cfg.Auth.TokenStrategy)
+	assert.NoError(t, cfg.Check())
+
+	// case 2: the settings of the file replace the defaults
+	file := filepath.Join(dir, "conf.toml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("[auth]\nlockout_minutes = 30\n"), 0600))
+	cfg = load(file)
+	assert.Equal(t, int64(30), cfg.Auth.LockoutMinutes)
+	assert.NoError(t, cfg.Check())
+
+	// case 3: a malformed file fails the check
+	assert.NoError(t, ioutil.WriteFile(file, []byte("[auth\n"), 0600))
+	assert.Error(t, load(file).Check())
+}
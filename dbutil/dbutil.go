@@ -62,8 +62,26 @@ func DbForURL(url string) (*gorp.DbMap, error) {
 	dbMap := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Engine: "InnoDB", Encoding: "UTF8"}}
 	dbMap.AddTableWithName(acct.User{}, acct.TableNameUser).SetKeys(true, "ID")
 	dbMap.AddTableWithName(acct.Token{}, acct.TableNameToken).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.RefreshToken{}, acct.TableNameRefreshToken).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.PasswordReset{}, acct.TableNamePasswordReset).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.PasswordHistory{}, acct.TableNamePasswordHistory).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.LoginAttempt{}, acct.TableNameLoginAttempt).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.RevokedToken{}, acct.TableNameRevokedToken).SetKeys(false, "TokenID")
+	dbMap.AddTableWithName(acct.APIKey{}, acct.TableNameAPIKey).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.RecoveryCode{}, acct.TableNameRecoveryCode).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.GroupSetting{}, acct.TableNameGroupSetting).SetKeys(false, "GroupID")
+	dbMap.AddTableWithName(acct.Group{}, acct.TableNameGroup).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.Share{}, acct.TableNameShare).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.Organization{}, acct.TableNameOrganization).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.ImpersonationLog{}, acct.TableNameImpersonationLog).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.Team{}, acct.TableNameTeam).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.AuditEntry{}, acct.TableNameAuditEntry).SetKeys(true, "ID")
+	dbMap.AddTableWithName(acct.GroupMembership{}, acct.TableNameGroupMembership).SetKeys(true, "ID")
 	dbMap.AddTableWithName(model.Meal{}, acct.TableNameMeal).SetKeys(true, "ID")
 
+	// read the groups and their permissions from the database
+	acct.UseGroupStore(dbMap)
+
 	// ping the db
 	if err := dbMap.Db.Ping(); err != nil {
 		glog.Printf("the db could not be pinged %#v\n", err)
@@ -91,4 +109,6 @@ func CleanAndSeedTestDB(db *gorp.DbMap) {
 			panic(fmt.Errorf("failed to exec: %s query: %s", err, s))
 		}
 	}
+	// the fixtures reset the groups
+	acct.InvalidateGroups()
 }
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+)
+
+const (
+	testAPIKeysUrl = `http://localhost:8000/api/apikeys`
+	testAPIKeyUrl  = `http://localhost:8000/api/apikeys/%d`
+	testMealsUrl   = `http://localhost:8000/api/meals`
+	testUsersUrl   = `http://localhost:8000/api/users`
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/apikeys", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ListAPIKeys())}).Methods("GET")
+	r.Handle("/apikeys", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(CreateAPIKey())}).Methods("POST")
+	r.Handle("/apikeys/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(RevokeAPIKey())}).Methods("DELETE")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&model.Meal{})}).Methods("GET")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.Create(&model.Meal{})}).Methods("POST")
+	r.Handle("/users", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.User{})}).Methods("GET")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	createKey := func(user *acct.User, body map[string]interface{}) *httptest.ResponseRecorder {
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", testAPIKeysUrl, bytes.NewReader(payload))
+		assert.NoError(t, err)
+		setAuth(req, user)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec
+	}
+	withKey := func(method, url, key, ip string) int {
+		req, err := http.NewRequest(method, url, bytes.NewReader([]byte(`{"description":"lunch","calories":500}`)))
+		assert.NoError(t, err)
+		req.Header.Set(acct.HeaderAuthorization, "ApiKey "+key)
+		req.RemoteAddr = ip + ":4321"
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regularUser, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: scopes can't exceed the owner's group
+	rec := createKey(regularUser, map[string]interface{}{"Name": "reports", "Scopes": []string{acct.ReadAllMeals.Name}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = createKey(admin, map[string]interface{}{"Name": "reports", "Scopes": []string{"read:everything"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// case 2: the key is only returned on creation
+	rec = createKey(admin, map[string]interface{}{
+		"Name":       "reports",
+		"Scopes":     []string{acct.ReadAllMeals.Name},
+		"AllowedIPs": []string{"10.0.0.0/8"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	key := &acct.APIKey{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(key))
+	assert.NotEmpty(t, key.Key)
+	req, err := http.NewRequest("GET", testAPIKeysUrl, nil)
+	assert.NoError(t, err)
+	setAuth(req, admin)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var keys []*acct.APIKey
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&keys))
+	assert.Len(t, keys, 1)
+	assert.Empty(t, keys[0].Key)
+	assert.Equal(t, []string{acct.ReadAllMeals.Name}, keys[0].Scopes)
+
+	// case 3: the key is limited to its scopes and allowed ips
+	assert.Equal(t, http.StatusOK, withKey("GET", testMealsUrl, key.Key, "10.1.2.3"))
+	assert.Equal(t, http.StatusForbidden, withKey("POST", testMealsUrl, key.Key, "10.1.2.3"))
+	assert.Equal(t, http.StatusForbidden, withKey("GET", testUsersUrl, key.Key, "10.1.2.3"))
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", testMealsUrl, key.Key, "192.168.1.1"))
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", testMealsUrl, key.Key+"x", "10.1.2.3"))
+
+	// case 4: keys can't manage keys
+	assert.Equal(t, http.StatusForbidden, withKey("GET", testAPIKeysUrl, key.Key, "10.1.2.3"))
+
+	// case 5: only the owner or an admin can revoke the key
+	req, err = http.NewRequest("DELETE", fmt.Sprintf(testAPIKeyUrl, key.ID), nil)
+	assert.NoError(t, err)
+	setAuth(req, regularUser)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	req, err = http.NewRequest("DELETE", fmt.Sprintf(testAPIKeyUrl, key.ID), nil)
+	assert.NoError(t, err)
+	setAuth(req, admin)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", testMealsUrl, key.Key, "10.1.2.3"))
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// ListAPIKeys returns the api keys of the current user.
+func ListAPIKeys() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		keys, err := acct.APIKeys(db, currentUser.ID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// CreateAPIKey creates an api key for the current user.  The key is only
+// part of this response and can't be retrieved again.
+func CreateAPIKey() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Name       string
+			Scopes     []string
+			Expiration int64
+			AllowedIPs []string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		key := &acct.APIKey{
+			UserID:     currentUser.ID,
+			Name:       form.Name,
+			Scopes:     form.Scopes,
+			Expiration: form.Expiration,
+			AllowedIPs: form.AllowedIPs,
+		}
+		if err := key.Validate(currentUser); err != nil {
+			return err
+		}
+		if err := db.Insert(key); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(key)
+	}
+}
+
+// RevokeAPIKey revokes one of the current user's api keys.  Admins can
+// revoke the keys of any user.
+func RevokeAPIKey() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		params := mux.Vars(r)
+		id, err := strconv.ParseInt(params["id"], 10, 64)
+		if err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid API key", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		key, err := acct.RevokeAPIKey(db, currentUser, id)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(key)
+	}
+}
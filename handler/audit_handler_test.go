@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testAuditUrl = `http://localhost:8000/api/audit`
+)
+
+func TestAudit(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/audit", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.AuditEntry{})}).Methods("GET")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.Create(&model.Meal{})}).Methods("POST")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.UpdateByID(&model.Meal{})}).Methods("PUT")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.DeleteByID(&model.Meal{})}).Methods("DELETE")
+	r.Handle("/user/{id}/updateGroup/{groupId}", &testhelpers.TestHandler{T: t, Db: db, Handler: UpdateUserGroup()}).Methods("GET")
+	r.Handle("/user/{id}/resetPassword", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ResetPassword())}).Methods("POST")
+	r.Handle("/createUser", &testhelpers.TestHandler{T: t, Db: db, Handler: CreateUser()}).Methods("POST")
+	do := apiClient(t, db, r)
+	entries := func(user *acct.User, query string) []*acct.AuditEntry {
+		rec := do(user, "GET", testAuditUrl+query, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var entries []*acct.AuditEntry
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+		return entries
+	}
+	changes := func(entry *acct.AuditEntry) map[string]acct.AuditChange {
+		changes, err := entry.Diff.Changes()
+		assert.NoError(t, err)
+		return changes
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: crud changes are recorded with their diff
+	rec := do(regular, "POST", testMealsUrl, map[string]interface{}{"userID": regular.ID, "description": "lunch", "calories": 500})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	meal := &model.Meal{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(meal))
+	assert.Equal(t, http.StatusOK, do(regular, "PUT", fmt.Sprintf(testMealUrl, meal.ID),
+		map[string]interface{}{"userID": regular.ID, "description": "lunch", "calories": 600}).Code)
+	assert.Equal(t, http.StatusOK, do(regular, "DELETE", fmt.Sprintf(testMealUrl, meal.ID), nil).Code)
+
+	logged := entries(admin, "?model="+acct.TableNameMeal)
+	if assert.Len(t, logged, 3) {
+		for i, action := range []string{acct.AuditDelete, acct.AuditUpdate, acct.AuditCreate} {
+			assert.Equal(t, action, logged[i].Action)
+			assert.Equal(t, regular.ID, logged[i].ActorID)
+			assert.Equal(t, meal.ID, logged[i].RecordID)
+			assert.NotEmpty(t, logged[i].RequestID)
+		}
+		assert.Equal(t, map[string]acct.AuditChange{"deleted": {From: false, To: true}}, changes(logged[0]))
+		assert.Equal(t, map[string]acct.AuditChange{"calories": {From: float64(500), To: float64(600)}}, changes(logged[1]))
+		assert.Equal(t, "lunch", changes(logged[2])["description"].To)
+		assert.Nil(t, changes(logged[2])["description"].From)
+	}
+
+	// case 2: account changes are recorded and secrets redacted
+	assert.Equal(t, http.StatusOK, do(regular, "POST", fmt.Sprintf(testResetPasswordUrl, regular.ID),
+		map[string]string{"OldPassword": "i am rahul", "Password": "another password"}).Code)
+	assert.Equal(t, http.StatusOK, do(admin, "GET", fmt.Sprintf(testUpdateUserGroupUrl, regular.ID, acct.UserManager.ID), nil).Code)
+	rec = do(admin, "POST", testCreateUserUrl, map[string]interface{}{
+		"Email": "audited@gmail.com", "Name": "Audited", "GroupID": acct.Regular.ID, "Password": "test password",
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	created := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(created))
+
+	logged = entries(admin, "?model="+acct.TableNameUser)
+	if assert.Len(t, logged, 3) {
+		assert.Equal(t, acct.AuditCreate, logged[0].Action)
+		assert.Equal(t, created.ID, logged[0].RecordID)
+		assert.Equal(t, "audited@gmail.com", changes(logged[0])["email"].To)
+		assert.Equal(t, "[redacted]", changes(logged[0])["password"].To)
+		assert.Equal(t, string(acct.ActionChangeGroup), logged[1].Action)
+		assert.Equal(t, acct.AuditChange{From: float64(acct.Regular.ID), To: float64(acct.UserManager.ID)}, changes(logged[1])["groupID"])
+		assert.Equal(t, string(acct.ActionResetPassword), logged[2].Action)
+		assert.Equal(t, acct.AuditChange{From: "[redacted]", To: "[redacted]"}, changes(logged[2])["passwordHash"])
+	}
+	assert.NotContains(t, do(admin, "GET", testAuditUrl, nil).Body.String(), "another password")
+
+	// case 3: entries are filtered and only admins read them
+	assert.Len(t, entries(admin, fmt.Sprintf("?actorID=%d", admin.ID)), 2)
+	assert.Len(t, entries(admin, "?action="+acct.AuditUpdate), 1)
+	assert.Equal(t, http.StatusForbidden, do(regular, "GET", testAuditUrl, nil).Code)
+
+	// case 4: other groups read them once granted the permission
+	group := acct.GroupForID(acct.Regular.ID)
+	group.Permissions = append(group.Permissions, acct.ReadAuditLog)
+	assert.NoError(t, acct.SaveGroup(db, group))
+	acct.InvalidateGroups()
+	assert.Equal(t, http.StatusOK, do(regular, "GET", testAuditUrl, nil).Code)
+}
@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"strings"
+	"net/url"
 	"testing"
+	"time"
 
 	"gopkg.in/gorp.v1"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/urfave/negroni"
 	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/milli"
 	"github.com/rahul2393/small-assignment-server/models/acct"
 	"github.com/rahul2393/small-assignment-server/mware"
 	"github.com/rahul2393/small-assignment-server/testhelpers"
@@ -24,10 +26,12 @@ import (
 const (
 	testLoginUrl           = `http://localhost:8000/login`
 	testSignUpUrl          = `http://localhost:8000/signup`
-	testSignOutUrl         = `http://localhost:8000/api/signout?auth-email=%s&auth-token=%s`
-	testCreateUserUrl      = `http://localhost:8000/api/createUser?auth-email=%s&auth-token=%s`
-	testResetPasswordUrl   = `http://localhost:8000/api/user/%d/resetPassword?auth-email=%s&auth-token=%s`
-	testUpdateUserGroupUrl = `http://localhost:8000/api/user/%d/updateGroup/%d?auth-email=%s&auth-token=%s`
+	testRefreshTokenUrl    = `http://localhost:8000/token/refresh`
+	testSignOutUrl         = `http://localhost:8000/api/signout`
+	testCreateUserUrl      = `http://localhost:8000/api/createUser`
+	testResetPasswordUrl   = `http://localhost:8000/api/user/%d/resetPassword`
+	testUpdateUserGroupUrl = `http://localhost:8000/api/user/%d/updateGroup/%d`
+	testGroupHistoryUrl    = `http://localhost:8000/api/user/%d/groups`
 )
 
 func TestLogin(t *testing.T) {
@@ -61,8 +65,9 @@ func TestSignOut(t *testing.T) {
 	assert.Equal(t, respCode, http.StatusOK)
 	assert.True(t, user.ID > 0)
 
-	req, err := http.NewRequest("GET", fmt.Sprintf(testSignOutUrl, user.Email, user.Token), nil)
+	req, err := http.NewRequest("GET", testSignOutUrl, nil)
 	assert.NoError(t, err)
+	setAuth(req, user)
 	rec := httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
@@ -105,10 +110,10 @@ func TestResetPassword(t *testing.T) {
 	assert.Equal(t, respCode, http.StatusOK)
 	assert.True(t, regularUser.ID > 0)
 
-	req, err := http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl,
-		regularUser.ID, regularUser.Email, regularUser.Token),
+	req, err := http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl, regularUser.ID),
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, regularUser)
 	rec := httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
@@ -116,10 +121,10 @@ func TestResetPassword(t *testing.T) {
 	postBody.OldPassword = "i am rahul"
 	payload, err = json.Marshal(postBody)
 	assert.NoError(t, err)
-	req, err = http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl,
-		regularUser.ID, regularUser.Email, regularUser.Token),
+	req, err = http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl, regularUser.ID),
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, regularUser)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
@@ -133,8 +138,8 @@ func TestResetPassword(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, len(userActiveTokens) == 0)
 	// all the cache session of the user will be deleted
-	for key := range cache.GetAll() {
-		assert.Equal(t, false, strings.HasPrefix(key, regularUser.Email))
+	for _, src := range cache.Items(acct.CacheKeySession) {
+		assert.NotEqual(t, regularUser.ID, src.(*acct.User).ID)
 	}
 	// login with old password will throw 401
 	regularUser, respCode = loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
@@ -144,10 +149,10 @@ func TestResetPassword(t *testing.T) {
 	regularUser, respCode = loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "updated password")
 	assert.Equal(t, respCode, http.StatusOK)
 	assert.True(t, regularUser.ID > 0)
-	req, err = http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl,
-		2, regularUser.Email, regularUser.Token),
+	req, err = http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl, 2),
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, regularUser)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
@@ -167,7 +172,7 @@ func TestSignUp(t *testing.T) {
 		Name     string
 		Password string
 	}
-	postBody := &form{Email: "test@gmail.com", Name: "test", Password: "test"}
+	postBody := &form{Email: "test@gmail.com", Name: "test", Password: "test password"}
 	payload, err := json.Marshal(postBody)
 	assert.NoError(t, err)
 	req, err := http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
@@ -200,6 +205,7 @@ func TestCreateUser(t *testing.T) {
 		Email   string
 		Name    string
 		GroupId int64
+		TeamID  int64
 	}
 	postBody := &form{Email: "test@gmail.com", Name: "test", GroupId: acct.Regular.ID}
 	payload, err := json.Marshal(postBody)
@@ -208,9 +214,10 @@ func TestCreateUser(t *testing.T) {
 	// case 1: regular user cannot create user
 	regularUser, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
 	assert.Equal(t, respCode, http.StatusOK)
-	req, err := http.NewRequest("POST", fmt.Sprintf(testCreateUserUrl, regularUser.Email, regularUser.Token),
+	req, err := http.NewRequest("POST", testCreateUserUrl,
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, regularUser)
 	rec := httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
@@ -221,9 +228,10 @@ func TestCreateUser(t *testing.T) {
 	assert.NoError(t, err)
 	userManagerUser, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
 	assert.Equal(t, respCode, http.StatusOK)
-	req, err = http.NewRequest("POST", fmt.Sprintf(testCreateUserUrl, userManagerUser.Email, userManagerUser.Token),
+	req, err = http.NewRequest("POST", testCreateUserUrl,
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, userManagerUser)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
@@ -232,20 +240,33 @@ func TestCreateUser(t *testing.T) {
 	postBody.GroupId = 0
 	payload, err = json.Marshal(postBody)
 	assert.NoError(t, err)
-	req, err = http.NewRequest("POST", fmt.Sprintf(testCreateUserUrl, userManagerUser.Email, userManagerUser.Token),
+	req, err = http.NewRequest("POST", testCreateUserUrl,
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, userManagerUser)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
 
-	// case 4: UserManger can create another user manager
+	// case 4: UserManger can create another user manager in their team
 	postBody.GroupId = acct.UserManager.ID
 	payload, err = json.Marshal(postBody)
 	assert.NoError(t, err)
-	req, err = http.NewRequest("POST", fmt.Sprintf(testCreateUserUrl, userManagerUser.Email, userManagerUser.Token),
+	req, err = http.NewRequest("POST", testCreateUserUrl,
 		bytes.NewReader(payload))
 	assert.NoError(t, err)
+	setAuth(req, userManagerUser)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	postBody.TeamID = 1
+	payload, err = json.Marshal(postBody)
+	assert.NoError(t, err)
+	req, err = http.NewRequest("POST", testCreateUserUrl,
+		bytes.NewReader(payload))
+	assert.NoError(t, err)
+	setAuth(req, userManagerUser)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
@@ -275,10 +296,10 @@ func TestUpdateUserGroup(t *testing.T) {
 	// case 1: regular user cannot update group of regular user
 	regularUser, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
 	assert.Equal(t, respCode, http.StatusOK)
-	req, err := http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, regularUser.ID, acct.UserManager.ID,
-		regularUser.Email, regularUser.Token),
+	req, err := http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, regularUser.ID, acct.UserManager.ID),
 		nil)
 	assert.NoError(t, err)
+	setAuth(req, regularUser)
 	rec := httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
@@ -286,10 +307,10 @@ func TestUpdateUserGroup(t *testing.T) {
 	// case 2: uaer manager can not update and user to admin user
 	userManager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
 	assert.Equal(t, respCode, http.StatusOK)
-	req, err = http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, regularUser.ID, acct.Admin.ID,
-		userManager.Email, userManager.Token),
+	req, err = http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, regularUser.ID, acct.Admin.ID),
 		nil)
 	assert.NoError(t, err)
+	setAuth(req, userManager)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
@@ -297,10 +318,10 @@ func TestUpdateUserGroup(t *testing.T) {
 	// case 3: uaer manager can update user to user manager
 	userManager, respCode = loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
 	assert.Equal(t, respCode, http.StatusOK)
-	req, err = http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, userManager.ID, acct.UserManager.ID,
-		userManager.Email, userManager.Token),
+	req, err = http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, userManager.ID, acct.UserManager.ID),
 		nil)
 	assert.NoError(t, err)
+	setAuth(req, userManager)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
@@ -308,10 +329,10 @@ func TestUpdateUserGroup(t *testing.T) {
 	// case 4: Admin can update user to any group
 	adminUser, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
 	assert.Equal(t, respCode, http.StatusOK)
-	req, err = http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, regularUser.ID, acct.Admin.ID,
-		adminUser.Email, adminUser.Token),
+	req, err = http.NewRequest("GET", fmt.Sprintf(testUpdateUserGroupUrl, regularUser.ID, acct.Admin.ID),
 		nil)
 	assert.NoError(t, err)
+	setAuth(req, adminUser)
 	rec = httptest.NewRecorder()
 	middleware.ServeHTTP(rec, req)
 	assert.Equal(t, http.StatusOK, rec.Code)
@@ -321,6 +342,104 @@ func TestUpdateUserGroup(t *testing.T) {
 	assert.Equal(t, acct.Admin.ID, regularUser.Group.ID)
 }
 
+func TestTemporaryUserGroup(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/user/{id}/updateGroup/{groupId}", &testhelpers.TestHandler{T: t, Db: db, Handler: UpdateUserGroup()}).Methods("GET")
+	r.Handle("/user/{id}/groups", &testhelpers.TestHandler{T: t, Db: db, Handler: GroupHistory()}).Methods("GET")
+	do := apiClient(t, db, r)
+	elevate := func(user *acct.User, id, groupID int64, expiresAt time.Time, reason string) int {
+		query := url.Values{"expiresAt": {fmt.Sprint(milli.Timestamp(expiresAt))}, "reason": {reason}}
+		return do(user, "GET", fmt.Sprintf(testUpdateUserGroupUrl, id, groupID)+"?"+query.Encode(), nil).Code
+	}
+	history := func(user *acct.User, id int64) []*acct.GroupMembership {
+		rec := do(user, "GET", fmt.Sprintf(testGroupHistoryUrl, id), nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var memberships []*acct.GroupMembership
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&memberships))
+		return memberships
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	manager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: expiries must be in the future
+	assert.Equal(t, http.StatusBadRequest, elevate(admin, regular.ID, acct.UserManager.ID, time.Now().Add(-time.Hour), "cover"))
+	assert.Equal(t, http.StatusBadRequest, do(admin, "GET", fmt.Sprintf(testUpdateUserGroupUrl, regular.ID, acct.UserManager.ID)+"?expiresAt=soon", nil).Code)
+
+	// case 2: extending a temporary group keeps the group to fall back to
+	assert.Equal(t, http.StatusOK, elevate(admin, regular.ID, acct.UserManager.ID, time.Now().Add(time.Hour), "cover"))
+	assert.Equal(t, http.StatusBadRequest, elevate(admin, regular.ID, acct.Regular.ID, time.Now().Add(time.Hour), "cover"))
+	assert.Equal(t, http.StatusOK, elevate(admin, regular.ID, acct.UserManager.ID, time.Now().Add(2*time.Hour), "holidays"))
+	memberships := history(admin, regular.ID)
+	if assert.Len(t, memberships, 2) {
+		assert.Equal(t, acct.UserManager.ID, memberships[0].GroupID)
+		assert.Equal(t, acct.Regular.ID, memberships[0].PreviousGroupID)
+		assert.Equal(t, admin.ID, memberships[0].AssignedByID)
+		assert.Equal(t, "holidays", memberships[0].Reason)
+		assert.Zero(t, memberships[0].Ended)
+		assert.NotZero(t, memberships[1].Ended)
+	}
+
+	// case 3: users keep their temporary group until it expires
+	expired, err := acct.ExpireGroupMemberships(db, time.Now())
+	assert.NoError(t, err)
+	assert.Zero(t, expired)
+	regular, respCode = loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	assert.Equal(t, acct.UserManager.ID, regular.Group.ID)
+
+	// case 4: expired users fall back to their previous group and sign in again
+	expired, err = acct.ExpireGroupMemberships(db, time.Now().Add(3*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, http.StatusUnauthorized, do(regular, "GET", fmt.Sprintf(testGroupHistoryUrl, regular.ID), nil).Code)
+	regular, respCode = loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	assert.Equal(t, acct.Regular.ID, regular.Group.ID)
+	memberships = history(regular, regular.ID)
+	if assert.Len(t, memberships, 3) {
+		assert.Equal(t, acct.Regular.ID, memberships[0].GroupID)
+		assert.Equal(t, acct.UserManager.ID, memberships[0].PreviousGroupID)
+		assert.Equal(t, acct.ReasonExpired, memberships[0].Reason)
+		assert.Zero(t, memberships[0].AssignedByID)
+		assert.NotZero(t, memberships[1].Ended)
+	}
+
+	// case 5: managers see the history of the users they manage only
+	assert.Len(t, history(manager, regular.ID), 3)
+	assert.Equal(t, http.StatusNotFound, do(manager, "GET", fmt.Sprintf(testGroupHistoryUrl, admin.ID), nil).Code)
+}
+
+func setAuth(req *http.Request, user *acct.User) {
+	req.Header.Set(acct.HeaderAuthorization, "Bearer "+user.Token)
+	req.Header.Set(acct.HeaderAuthEmail, user.Email)
+}
+
+// apiClient returns a function making requests to the api router as the
+// user, with the body encoded as json.
+func apiClient(t *testing.T, db *gorp.DbMap, r *mux.Router) func(user *acct.User, method, url string, body interface{}) *httptest.ResponseRecorder {
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	return func(user *acct.User, method, url string, body interface{}) *httptest.ResponseRecorder {
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+		assert.NoError(t, err)
+		setAuth(req, user)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec
+	}
+}
+
 func loginRequest(t *testing.T, mainRouter *mux.Router, db *gorp.DbMap, email, password string) (*acct.User, int) {
 	mainRouter.Handle("/login", &testhelpers.TestHandler{
 		T:       t,
@@ -348,3 +467,49 @@ func loginRequest(t *testing.T, mainRouter *mux.Router, db *gorp.DbMap, email, p
 	assert.NoError(t, err)
 	return user, rec.Code
 }
+
+func TestRefreshToken(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/token/refresh", &testhelpers.TestHandler{
+		T:       t,
+		Db:      db,
+		Handler: RefreshToken(),
+	}).Methods("POST")
+
+	user, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	assert.NotEmpty(t, user.RefreshToken)
+
+	// case 1: refresh token rotates both tokens
+	refreshed, respCode := refreshRequest(t, mainRouter, user.RefreshToken)
+	assert.Equal(t, http.StatusOK, respCode)
+	assert.Equal(t, user.ID, refreshed.ID)
+	assert.NotEqual(t, user.Token, refreshed.Token)
+	assert.NotEqual(t, user.RefreshToken, refreshed.RefreshToken)
+
+	// case 2: replaying the old refresh token is rejected
+	_, respCode = refreshRequest(t, mainRouter, user.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, respCode)
+
+	// case 3: the replay revoked the whole family
+	_, respCode = refreshRequest(t, mainRouter, refreshed.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, respCode)
+	_, err := acct.Authenticate(db, "", refreshed.Token)
+	assert.Error(t, err)
+}
+
+func refreshRequest(t *testing.T, mainRouter *mux.Router, refreshToken string) (*acct.User, int) {
+	payload, err := json.Marshal(map[string]string{"RefreshToken": refreshToken})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", testRefreshTokenUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return nil, rec.Code
+	}
+	user := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(user))
+	return user, rec.Code
+}
@@ -7,17 +7,19 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/gorp.v1"
 
 	"github.com/Masterminds/squirrel"
-	"github.com/dchest/uniuri"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/rahul2393/small-assignment-server/httperr"
 	"github.com/rahul2393/small-assignment-server/logger"
+	"github.com/rahul2393/small-assignment-server/milli"
 	"github.com/rahul2393/small-assignment-server/models/acct"
 	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/policy"
 )
 
 const (
@@ -26,23 +28,13 @@ const (
 
 func SignOut() mware.Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
-		v := r.URL.Query()
-		token := v.Get("auth-token")
 		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
-		_, id, err := acct.SplitToken(token)
+		id, err := acct.TokenID(acct.CredentialsFromRequest(r).Token)
 		if err != nil {
 			return httperr.New(http.StatusBadRequest, "invalid token", err)
 		}
-		// retrieve and validate token
-		t := &acct.Token{}
-		query, args, _ := squirrel.Select("*").
-			From(acct.TableNameToken).
-			Where(squirrel.Eq{"ID": id, "UserID": currentUser.ID}).ToSql()
-		if err := db.SelectOne(t, query, args...); err != nil {
-			return httperr.New(http.StatusBadRequest, "invalid token", err)
-		}
-		t.Deleted = true
-		if _, err := db.Update(t); err != nil {
+		// revoke the token along with its refresh tokens
+		if err := acct.RevokeSession(db, currentUser.ID, id); err != nil {
 			return httperr.New(http.StatusBadRequest, "signout failed", err)
 		}
 		return nil
@@ -56,11 +48,13 @@ func SignUp() mware.Handler {
 		if err != nil {
 			return httperr.NewInternal(err)
 		}
+		// the verification email is sent once the user is committed
+		newUser := &acct.User{}
 		defer func() {
 			if err != nil {
 				trans.Rollback()
-			} else {
-				trans.Commit()
+			} else if err = trans.Commit(); err == nil {
+				newUser.PostCommit()
 			}
 		}()
 		// parse form
@@ -69,6 +63,10 @@ func SignUp() mware.Handler {
 			Name                   string
 			Password               string
 			ExpectedCaloriesPerDay int64
+			// Organization is the slug of the organization to join,
+			// the default organization if omitted.  Only organizations
+			// with open signup can be joined.
+			Organization string
 		}
 		form := &Form{}
 		if err = json.NewDecoder(r.Body).Decode(form); err != nil {
@@ -76,27 +74,41 @@ func SignUp() mware.Handler {
 			return httperr.New(http.StatusUnauthorized, "invalid request", errors.Wrap(err, "cannot decode"))
 		}
 
-		newUser := acct.User{Email: form.Email,
+		*newUser = acct.User{Email: form.Email,
 			Name: form.Name,
-			ExpectedCaloriesPerDay: form.ExpectedCaloriesPerDay}
-		newUser.SetPassword(form.Password)
+			ExpectedCaloriesPerDay: form.ExpectedCaloriesPerDay,
+			OrgID: acct.DefaultOrgID}
+		if form.Organization != "" {
+			var org *acct.Organization
+			if org, err = acct.OrganizationForSlug(trans, form.Organization); err != nil {
+				return err
+			}
+			// closed organizations look the same as unknown ones
+			if !org.OpenSignup {
+				err = fmt.Errorf("handler: organization %d is closed to signup", org.ID)
+				return httperr.NewNotFound(err, "organization")
+			}
+			newUser.OrgID = org.ID
+		}
+		if err = newUser.SetPassword(form.Password); err != nil {
+			return err
+		}
 
 		// set the account of newly created user to be regular account
 		newUser.GroupID = acct.Regular.ID
-		if err = trans.Insert(&newUser); err != nil {
+		if err = trans.Insert(newUser); err != nil {
 			logger.Error(err)
 			return httperr.New(http.StatusUnauthorized, "invalid request", err)
 		}
-		token := &acct.Token{
-			UserID: newUser.ID,
-		}
-		if err = trans.Insert(token); err != nil {
+		token, refresh, err := acct.NewSession(trans, newUser.ID, "", acct.ClientFromRequest(r))
+		if err != nil {
 			logger.Debug("problem inserting token")
 			return httperr.New(http.StatusUnauthorized, "error in inserting token", err)
 		}
 		// return user w/ token
-		newUser.Token = token.String()
-		newUser.TokenExpiration = token.Expiration
+		if err = newUser.SetSession(token, refresh); err != nil {
+			return httperr.NewInternal(err)
+		}
 		if err = newUser.Expand(trans, ""); err != nil {
 			logger.Debugf("problem expanding user")
 			return httperr.New(http.StatusUnauthorized, "error in expanding user", err)
@@ -141,40 +153,42 @@ func ResetPassword() mware.Handler {
 			return httperr.New(http.StatusBadRequest, "user not active in system", err)
 		}
 
-		if !userToUpdate.HasPassword(form.OldPassword) {
-			return httperr.New(http.StatusBadRequest, "invalid old password", err)
+		userToUpdate.Expand(trans, "")
+		if err = authorizeResetPassword(trans, currentUser, userToUpdate); err != nil {
+			return err
 		}
 
-		userToUpdate.Expand(trans, "")
-		if currentUser.ID != userToUpdate.ID &&
-			!canResetPassword(currentUser.Group.ID, userToUpdate.GroupID) {
-			err = fmt.Errorf("handler: invite user invalid request")
-			return httperr.New(
-				http.StatusBadRequest,
-				"user do not have permission to perform request",
-				err)
+		// only users changing their own password have to know the old one
+		if currentUser.ID == userToUpdate.ID && !userToUpdate.HasPassword(form.OldPassword) {
+			return httperr.New(http.StatusBadRequest, "invalid old password", err)
 		}
 
+		previousHash := userToUpdate.PasswordHash
 		if err = userToUpdate.SetPassword(form.Password); err != nil {
-			return httperr.New(
-				http.StatusBadRequest,
-				"problem in resetting user password",
-				err)
+			return err
 		}
 
 		if _, err = trans.Update(userToUpdate); err != nil {
+			// a reused password is reported with the policy details
+			if e, ok := err.(httperr.Error); ok {
+				return e
+			}
 			return httperr.New(
 				http.StatusBadRequest,
 				"problem in resetting user password",
 				err)
 		}
+		if err = acct.Audit(trans, currentUser, r.Header.Get("X-Request-Id"), string(acct.ActionResetPassword),
+			acct.TableNameUser, userToUpdate.ID, map[string]string{"passwordHash": previousHash},
+			map[string]string{"passwordHash": userToUpdate.PasswordHash}); err != nil {
+			return httperr.NewInternal(err)
+		}
 
 		// delete session from cache
 		userToUpdate.DeleteCacheSession()
 
 		// delete all the tokens once password is reset
-		if _, err = trans.Exec(fmt.Sprintf("Delete from %s where userID = %d",
-			acct.TableNameToken, userToUpdate.ID)); err != nil {
+		if err = acct.DeleteSessions(trans, userToUpdate.ID); err != nil {
 			return httperr.New(
 				http.StatusBadRequest,
 				"problem in resetting user password",
@@ -184,6 +198,22 @@ func ResetPassword() mware.Handler {
 	}
 }
 
+// authorizeResetPassword returns an error unless the current user may
+// reset the password of the user.
+func authorizeResetPassword(s gorp.SqlExecutor, currentUser, user *acct.User) error {
+	allowed, err := policy.Allowed(s, currentUser, acct.ActionResetPassword, acct.TableNameUser, user)
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	if !allowed {
+		return httperr.New(
+			http.StatusBadRequest,
+			"user do not have permission to perform request",
+			fmt.Errorf("handler: invite user invalid request"))
+	}
+	return nil
+}
+
 func Login() mware.Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
 		// parse form
@@ -197,36 +227,110 @@ func Login() mware.Handler {
 			return httperr.New(http.StatusUnauthorized, "invalid request", err)
 		}
 		form.Email = strings.ToLower(form.Email)
+		client := acct.ClientFromRequest(r)
+		attempt := &acct.LoginAttempt{Email: form.Email, IP: client.IP, UserAgent: client.UserAgent}
 		// find user
 		loginUser := &acct.User{}
 		query, args, _ := squirrel.Select("*").
 			From(acct.TableNameUser).
 			Where(squirrel.Eq{"Email": form.Email, "Deleted": false}).ToSql()
-		if err := db.SelectOne(loginUser, query, args...); err != nil {
-			logger.Debugf("couldn't find user w/ email %s %s", form.Email, err)
-			return httperr.New(http.StatusUnauthorized, "email doesn't exist", err)
+		found := db.SelectOne(loginUser, query, args...) == nil
+		attempt.UserID = loginUser.ID
+		// slow down password guessing before looking at the password
+		wait, err := acct.LoginDelay(db, form.Email, client.IP)
+		if err != nil {
+			return err
+		}
+		if wait > 0 {
+			attempt.Result = acct.LoginThrottled
+			if err := acct.RecordLogin(db, attempt); err != nil {
+				return err
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(wait/time.Second)+1, 10))
+			return acct.ErrTooManyLogins
+		}
+		// check password, unknown emails fail the same way as wrong passwords
+		if !found {
+			logger.Debugf("couldn't find user w/ email %s", form.Email)
+			acct.SimulatePasswordCheck(form.Password)
 		}
-		// check password
-		if !loginUser.HasPassword(form.Password) {
+		if !found || !loginUser.HasPassword(form.Password) {
 			logger.Debugf("problem checking password")
+			attempt.Result = acct.LoginFailed
+			if err := acct.RecordLogin(db, attempt); err != nil {
+				return err
+			}
 			return httperr.New(http.StatusUnauthorized, loginErrorMessage, errors.New(loginErrorMessage))
 		}
-		//// create token
-		token := &acct.Token{
-			UserID: loginUser.ID,
+		// users with two-factor authentication get a challenge instead of a
+		// token, their failures are only cleared by the second factor
+		required, err := loginUser.RequiresTwoFactor(db)
+		if err != nil {
+			return err
 		}
-		if err := db.Insert(token); err != nil {
-			logger.Debugf("problem inserting token")
-			return errors.Wrap(err, "error in inserting token")
+		if required {
+			attempt.Result = acct.LoginChallenged
+			if err := acct.RecordLogin(db, attempt); err != nil {
+				return err
+			}
+			return json.NewEncoder(w).Encode(acct.NewLoginChallenge(loginUser))
 		}
-		// return user w/ token
-		loginUser.Token = token.String()
-		loginUser.TokenExpiration = token.Expiration
-		if err := loginUser.Expand(db, ""); err != nil {
-			logger.Debugf("problem expanding user")
-			return errors.Wrap(err, "error in expanding user")
+		attempt.Result = acct.LoginSucceeded
+		if err := acct.RecordLogin(db, attempt); err != nil {
+			return err
+		}
+		return writeLogin(w, r, db, loginUser)
+	}
+}
+
+// writeLogin creates a session for the user and writes out the user
+// with its tokens.
+func writeLogin(w http.ResponseWriter, r *http.Request, s gorp.SqlExecutor, loginUser *acct.User) error {
+	//// create token
+	token, refresh, err := acct.NewSession(s, loginUser.ID, "", acct.ClientFromRequest(r))
+	if err != nil {
+		logger.Debugf("problem inserting token")
+		return errors.Wrap(err, "error in inserting token")
+	}
+	// return user w/ token
+	if err := loginUser.SetSession(token, refresh); err != nil {
+		return err
+	}
+	if err := loginUser.Expand(s, ""); err != nil {
+		logger.Debugf("problem expanding user")
+		return errors.Wrap(err, "error in expanding user")
+	}
+	return json.NewEncoder(w).Encode(loginUser)
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token.
+func RefreshToken() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			RefreshToken string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		user, err := acct.Refresh(trans, form.RefreshToken, acct.ClientFromRequest(r))
+		// a replayed token revokes its family, which is kept although the
+		// refresh fails
+		if err != nil && err != acct.ErrRefreshTokenReused {
+			trans.Rollback()
+			return err
 		}
-		return json.NewEncoder(w).Encode(loginUser)
+		if commitErr := trans.Commit(); commitErr != nil {
+			return httperr.NewInternal(commitErr)
+		}
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(user)
 	}
 }
 
@@ -237,6 +341,14 @@ func CreateUser() mware.Handler {
 			Name                   string
 			ExpectedCaloriesPerDay int64
 			GroupID                int64
+			// Password is optional, a random one is generated if omitted
+			Password string
+			// OrgID is optional, users are created in the organization of
+			// the current user if omitted
+			OrgID int64
+			// TeamID is the team the user joins, user managers may only
+			// create users in the teams they manage
+			TeamID int64
 		}
 		form := &Form{}
 		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
@@ -251,7 +363,29 @@ func CreateUser() mware.Handler {
 			return httperr.New(http.StatusBadRequest, "invalid request", err)
 		}
 		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
-		if !canModifyGroup(currentUser.Group.ID, form.GroupID, form.GroupID) {
+		if form.OrgID == 0 {
+			form.OrgID = currentUser.OrgID
+		}
+		if _, err := acct.OrganizationForID(db, form.OrgID); err != nil {
+			return err
+		}
+		var team *acct.Team
+		if form.TeamID != 0 {
+			var err error
+			if team, err = acct.TeamForID(db, form.TeamID); err != nil {
+				return err
+			}
+			if team.OrgID != form.OrgID {
+				err = fmt.Errorf("handler: team %d is in another organization", team.ID)
+				return httperr.New(http.StatusBadRequest, "invalid team", err)
+			}
+		}
+		change := &acct.GroupChange{From: form.GroupID, To: form.GroupID, OrgID: form.OrgID, TeamID: form.TeamID}
+		allowed, err := policy.Allowed(db, currentUser, acct.ActionChangeGroup, acct.TableNameUser, change)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if !allowed {
 			return httperr.New(
 				http.StatusBadRequest,
 				"user do not have permission to perform request",
@@ -261,34 +395,49 @@ func CreateUser() mware.Handler {
 		if err != nil {
 			return httperr.NewInternal(err)
 		}
+		// the verification email is sent once the user is committed
+		userToCreate := &acct.User{}
 		defer func() {
 			if err != nil {
 				trans.Rollback()
-			} else {
-				trans.Commit()
+			} else if err = trans.Commit(); err == nil {
+				userToCreate.PostCommit()
 			}
 		}()
-		userToCreate := acct.User{Email: form.Email,
+		*userToCreate = acct.User{Email: form.Email,
 			Name: form.Name,
 			ExpectedCaloriesPerDay: form.ExpectedCaloriesPerDay}
-		p := uniuri.NewLen(8)
-		userToCreate.SetPassword(p)
+		if form.Password == "" {
+			form.Password = acct.GeneratePassword()
+		}
+		if err = userToCreate.SetPassword(form.Password); err != nil {
+			return err
+		}
 		// set the account of newly created user to be regular account
 		userToCreate.GroupID = form.GroupID
-		if err = trans.Insert(&userToCreate); err != nil {
+		userToCreate.OrgID = form.OrgID
+		if err = trans.Insert(userToCreate); err != nil {
 			logger.Error(err)
 			return httperr.New(http.StatusUnauthorized, "invalid request", err)
 		}
-		token := &acct.Token{
-			UserID: userToCreate.ID,
+		if team != nil {
+			if _, err = team.SetMember(trans, userToCreate, false); err != nil {
+				return httperr.NewInternal(err)
+			}
 		}
-		if err = trans.Insert(token); err != nil {
+		if err = acct.Audit(trans, currentUser, r.Header.Get("X-Request-Id"), acct.AuditCreate,
+			acct.TableNameUser, userToCreate.ID, nil, userToCreate); err != nil {
+			return httperr.NewInternal(err)
+		}
+		token, refresh, err := acct.NewSession(trans, userToCreate.ID, "", acct.ClientFromRequest(r))
+		if err != nil {
 			logger.Debug("problem inserting token")
 			return httperr.New(http.StatusUnauthorized, "error in inserting token", err)
 		}
 		// return user w/ token
-		userToCreate.Token = token.String()
-		userToCreate.TokenExpiration = token.Expiration
+		if err = userToCreate.SetSession(token, refresh); err != nil {
+			return httperr.NewInternal(err)
+		}
 		if err = userToCreate.Expand(trans, ""); err != nil {
 			logger.Debugf("problem expanding user")
 			return httperr.New(http.StatusUnauthorized, "error in expanding user", err)
@@ -297,6 +446,10 @@ func CreateUser() mware.Handler {
 	}
 }
 
+// UpdateUserGroup moves the user to the group.  The expiresAt query
+// parameter, a millisecond timestamp, makes the move temporary: the user
+// falls back to their previous group once it passes.  The reason query
+// parameter is kept in the user's group history.
 func UpdateUserGroup() mware.Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
 		params := mux.Vars(r)
@@ -308,24 +461,23 @@ func UpdateUserGroup() mware.Handler {
 			logger.Debugf("couldn't find user w/ ID %d %s", params["id"], err)
 			return httperr.New(http.StatusBadRequest, "user doesn't exist", err)
 		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
 
 		groupId, err := strconv.ParseInt(params["groupId"], 10, 64)
 		if err != nil {
 			logger.Debugf("invalid group id %d", groupId, err)
 			return httperr.New(http.StatusBadRequest, "invalid group", err)
 		}
-
-		group := acct.GroupForID(groupId)
-		if group == nil {
-			err := fmt.Errorf("handler: must specify group")
-			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		if err := authorizeGroupChange(db, currentUser, userToUpdate, groupId); err != nil {
+			return err
 		}
-		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
-		if !canModifyGroup(currentUser.Group.ID, userToUpdate.GroupID, groupId) {
-			return httperr.New(
-				http.StatusBadRequest,
-				"user do not have permission to perform request",
-				fmt.Errorf("handler: invite user invalid request"))
+		var expiresAt time.Time
+		if v := r.URL.Query().Get("expiresAt"); v != "" {
+			timestamp, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return httperr.New(http.StatusBadRequest, "invalid expiry", err)
+			}
+			expiresAt = milli.Time(timestamp)
 		}
 		trans, err := db.Begin()
 		if err != nil {
@@ -338,10 +490,19 @@ func UpdateUserGroup() mware.Handler {
 				trans.Commit()
 			}
 		}()
-		userToUpdate.GroupID = groupId
-		if _, err = trans.Update(userToUpdate); err != nil {
+		from := userToUpdate.GroupID
+		membership, err := acct.ChangeGroup(trans, userToUpdate, currentUser, groupId, r.URL.Query().Get("reason"), expiresAt)
+		if err != nil {
 			logger.Debug("problem updating user group")
-			return httperr.New(http.StatusUnauthorized, "error in updating user group", err)
+			return err
+		}
+		to := map[string]int64{"groupID": groupId}
+		if membership.ExpiresAt != 0 {
+			to["expiresAt"] = membership.ExpiresAt
+		}
+		if err = acct.Audit(trans, currentUser, r.Header.Get("X-Request-Id"), string(acct.ActionChangeGroup),
+			acct.TableNameUser, userToUpdate.ID, map[string]int64{"groupID": from}, to); err != nil {
+			return httperr.NewInternal(err)
 		}
 		if err = userToUpdate.Expand(trans, ""); err != nil {
 			logger.Debugf("problem expanding user")
@@ -352,8 +513,7 @@ func UpdateUserGroup() mware.Handler {
 		userToUpdate.DeleteCacheSession()
 
 		// delete tokens from cache
-		if _, err = trans.Exec(fmt.Sprintf("Delete from %s where userID = %d",
-			acct.TableNameToken, userToUpdate.ID)); err != nil {
+		if err = acct.DeleteSessions(trans, userToUpdate.ID); err != nil {
 			return httperr.New(
 				http.StatusBadRequest,
 				"problem in resetting user password",
@@ -363,35 +523,43 @@ func UpdateUserGroup() mware.Handler {
 	}
 }
 
-func canModifyGroup(currentUserGroupID, otherUserGroupID int64, finalGroup int64) bool {
-	switch currentUserGroupID {
-	case acct.Admin.ID:
-		return true
-	case acct.UserManager.ID:
-		if otherUserGroupID == acct.Admin.ID {
-			return false
-		}
-		if finalGroup > 0 && finalGroup == acct.Admin.ID {
-			return false
-		}
-		return true
-	default:
-		return false
+// GroupHistory returns the group changes of the user, latest first.
+func GroupHistory() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		user := &acct.User{}
+		if err := mware.GetID(db, currentUser, user, mux.Vars(r)["id"], policy.Read); err != nil {
+			return err
+		}
+		memberships, err := acct.GroupMemberships(db, user.ID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(memberships)
 	}
-	return false
 }
 
-func canResetPassword(currentUserGroupID, otherUserGroupID int64) bool {
-	switch currentUserGroupID {
-	case acct.Admin.ID:
-		return true
-	case acct.UserManager.ID:
-		if otherUserGroupID != acct.Regular.ID {
-			return false
-		}
-		return true
-	default:
-		return false
+// authorizeGroupChange returns an error unless the current user may move
+// the user to the group with the id.
+func authorizeGroupChange(s gorp.SqlExecutor, currentUser, user *acct.User, groupID int64) error {
+	if !currentUser.InOrganization(user.OrgID) {
+		err := fmt.Errorf("handler: user %d is in another organization", user.ID)
+		return httperr.New(http.StatusBadRequest, "user doesn't exist", err)
+	}
+	if acct.GroupForID(groupID) == nil {
+		err := fmt.Errorf("handler: must specify group")
+		return httperr.New(http.StatusBadRequest, "invalid request", err)
+	}
+	change := &acct.GroupChange{From: user.GroupID, To: groupID, OrgID: user.OrgID, UserID: user.ID}
+	allowed, err := policy.Allowed(s, currentUser, acct.ActionChangeGroup, acct.TableNameUser, change)
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	if !allowed {
+		return httperr.New(
+			http.StatusBadRequest,
+			"user do not have permission to perform request",
+			fmt.Errorf("handler: invite user invalid request"))
 	}
-	return false
+	return nil
 }
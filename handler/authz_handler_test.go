@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/policy"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testMeUrl         = `http://localhost:8000/api/me`
+	testAuthzCheckUrl = `http://localhost:8000/api/authz/check`
+)
+
+func TestAuthz(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/me", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(Me())}).Methods("GET")
+	r.Handle("/authz/check", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(CheckAuthz())}).Methods("POST")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetByID(&model.Meal{})}).Methods("GET")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.DeleteByID(&model.Meal{})}).Methods("DELETE")
+	r.Handle("/user/{id}/resetPassword", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ResetPassword())}).Methods("POST")
+	do := apiClient(t, db, r)
+	check := func(user *acct.User, checks []AuthzCheck) []bool {
+		rec := do(user, "POST", testAuthzCheckUrl, checks)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var answers []AuthzCheck
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&answers))
+		allowed := []bool{}
+		for _, a := range answers {
+			allowed = append(allowed, a.Allowed)
+		}
+		return allowed
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	manager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	adminMeal := &model.Meal{UserID: admin.ID, Description: "lunch", Calories: 500}
+	assert.NoError(t, db.Insert(adminMeal))
+	regularMeal := &model.Meal{UserID: regular.ID, Description: "lunch", Calories: 500}
+	assert.NoError(t, db.Insert(regularMeal))
+
+	// case 1: me lists the permissions and the actions granted by the policies
+	rec := do(manager, "GET", testMeUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var me struct {
+		User        *acct.User                 `json:"user"`
+		Permissions []string                   `json:"permissions"`
+		Actions     map[string][]policy.Action `json:"actions"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Equal(t, manager.ID, me.User.ID)
+	assert.Contains(t, me.Permissions, acct.WriteTeamUsers.Name)
+	assert.Contains(t, me.Actions[acct.TableNameUser], acct.ActionChangeGroup)
+	assert.NotContains(t, me.Actions[acct.TableNameUser], acct.ActionImpersonate)
+
+	// case 2: the answers agree with the endpoints
+	checks := []AuthzCheck{
+		{Action: policy.Read, Resource: acct.TableNameMeal, ID: adminMeal.ID},
+		{Action: policy.Write, Resource: acct.TableNameMeal, ID: regularMeal.ID},
+		{Action: policy.Write, Resource: acct.TableNameMeal},
+		{Action: acct.ActionResetPassword, Resource: acct.TableNameUser, ID: manager.ID},
+		{Action: acct.ActionChangeGroup, Resource: acct.TableNameUser, ID: regular.ID, GroupID: acct.UserManager.ID},
+		{Action: acct.ActionImpersonate, Resource: acct.TableNameUser, ID: regular.ID},
+		{Action: acct.ActionUnlock, Resource: acct.TableNameUser, ID: regular.ID},
+		{Action: acct.ActionDisableTwoFactor, Resource: acct.TableNameUser, ID: regular.ID},
+		{Action: policy.Read, Resource: acct.TableNameLoginAttempt},
+		{Action: policy.Read, Resource: "unknown"},
+		{Action: "unknown", Resource: acct.TableNameUser, ID: regular.ID},
+	}
+	assert.Equal(t, []bool{false, true, true, false, false, false, false, true, false, false, false}, check(regular, checks))
+	assert.Equal(t, []bool{true, true, true, true, true, true, true, true, true, false, false}, check(admin, checks))
+	assert.Equal(t, []bool{false, false, true, true, true, false, false, false, false, false, false}, check(manager, checks))
+	assert.Equal(t, http.StatusNotFound, do(regular, "GET", fmt.Sprintf(testMealUrl, adminMeal.ID), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(regular, "POST", fmt.Sprintf(testResetPasswordUrl, manager.ID),
+		map[string]string{"Password": "another password"}).Code)
+	assert.Equal(t, http.StatusOK, do(regular, "DELETE", fmt.Sprintf(testMealUrl, regularMeal.ID), nil).Code)
+
+	// case 3: batches are limited
+	assert.Equal(t, http.StatusBadRequest, do(admin, "POST", testAuthzCheckUrl, make([]AuthzCheck, maxAuthzChecks+1)).Code)
+
+	// case 4: me lists the permissions the group has now, not when the
+	// session started
+	group := acct.GroupForID(acct.UserManager.ID)
+	group.Permissions = []acct.Permission{acct.ReadMeals}
+	assert.NoError(t, acct.SaveGroup(db, group))
+	acct.InvalidateGroups()
+	rec = do(manager, "GET", testMeUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&me))
+	assert.Equal(t, []string{acct.ReadMeals.Name}, me.Permissions)
+}
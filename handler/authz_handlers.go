@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// maxAuthzChecks is the most questions a single check request may ask.
+	maxAuthzChecks = 100
+)
+
+// authzModels are the models served by the crud handlers, by resource.
+var authzModels = map[string]basemodel.Model{
+	acct.TableNameUser:         &acct.User{},
+	acct.TableNameMeal:         &model.Meal{},
+	acct.TableNameOrganization: &acct.Organization{},
+	acct.TableNameTeam:         &acct.Team{},
+	acct.TableNameAuditEntry:   &acct.AuditEntry{},
+}
+
+// AuthzCheck asks whether or not the current user may perform the action
+// on the resource.  ID names a single record; without it reading is
+// listing and writing is creating records.  GroupID is the group a
+// change_group check moves the user to.
+type AuthzCheck struct {
+	Action   policy.Action `json:"action"`
+	Resource string        `json:"resource"`
+	ID       int64         `json:"id,omitempty"`
+	GroupID  int64         `json:"groupID,omitempty"`
+	Allowed  bool          `json:"allowed"`
+}
+
+// Me returns the current user along with the permissions of their group
+// and the actions the policies grant them on each resource.  The
+// permissions are those the group has now, not when the session started.
+func Me() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		type Response struct {
+			User        *acct.User                 `json:"user"`
+			Permissions []string                   `json:"permissions"`
+			Actions     map[string][]policy.Action `json:"actions"`
+		}
+		user := *currentUser
+		if user.Group != nil && user.Group.ID != 0 {
+			user.Group = acct.GroupForID(user.Group.ID)
+		}
+		resp := Response{User: &user, Permissions: []string{}, Actions: policy.Grants(currentUser)}
+		if user.Group != nil {
+			for _, p := range user.Group.Permissions {
+				resp.Permissions = append(resp.Permissions, p.Name)
+			}
+		}
+		return json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// CheckAuthz answers a batch of authorization questions with the checks
+// the crud handlers and the account endpoints make, so clients only offer
+// what the server will allow.
+func CheckAuthz() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		var checks []*AuthzCheck
+		if err := json.NewDecoder(r.Body).Decode(&checks); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		if len(checks) > maxAuthzChecks {
+			err := fmt.Errorf("handler: %d authorization checks", len(checks))
+			return httperr.New(http.StatusBadRequest,
+				fmt.Sprintf("At most %d checks may be made at once.", maxAuthzChecks), err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		for _, c := range checks {
+			err := authorize(db, currentUser, c)
+			if e, ok := err.(httperr.Error); err != nil && (!ok || e.StatusCode >= http.StatusInternalServerError) {
+				return httperr.NewInternal(err)
+			}
+			c.Allowed = err == nil
+		}
+		return json.NewEncoder(w).Encode(checks)
+	}
+}
+
+// authorize returns the error the endpoint serving the check responds
+// with before doing anything.
+func authorize(db *gorp.DbMap, currentUser *acct.User, c *AuthzCheck) error {
+	switch c.Action {
+	case policy.Read, policy.Write:
+		switch {
+		case c.Resource == acct.TableNameAPIKey && c.Action == policy.Write && c.ID != 0:
+			_, err := acct.APIKeyForID(db, currentUser, c.ID)
+			return err
+		case (c.Resource == acct.TableNameLoginAttempt || c.Resource == acct.TableNameGroup) && c.Action == policy.Read:
+			return authorizeAction(db, currentUser, c.Action, c.Resource, nil)
+		}
+		m, ok := authzModels[c.Resource]
+		if !ok {
+			return httperr.NewNotFound(fmt.Errorf("handler: unknown resource %q", c.Resource), "resource")
+		}
+		id := ""
+		if c.ID != 0 {
+			id = strconv.FormatInt(c.ID, 10)
+		}
+		return mware.Authorize(db, currentUser, m, c.Action, id)
+	case acct.ActionResetPassword, acct.ActionChangeGroup, acct.ActionImpersonate,
+		acct.ActionUnlock, acct.ActionDisableTwoFactor:
+		if c.Resource != acct.TableNameUser {
+			return httperr.NewNotFound(fmt.Errorf("handler: unknown resource %q", c.Resource), "resource")
+		}
+		user, err := findActiveUser(db, c.ID)
+		if err != nil {
+			return err
+		}
+		if err := user.Expand(db, ""); err != nil {
+			return httperr.NewInternal(err)
+		}
+		switch c.Action {
+		case acct.ActionResetPassword:
+			return authorizeResetPassword(db, currentUser, user)
+		case acct.ActionChangeGroup:
+			return authorizeGroupChange(db, currentUser, user, c.GroupID)
+		case acct.ActionUnlock, acct.ActionDisableTwoFactor:
+			return authorizeAction(db, currentUser, c.Action, acct.TableNameUser, user)
+		}
+		return acct.CheckImpersonation(db, currentUser, user)
+	case acct.ActionManageMembers, acct.ActionAssignManagers:
+		if c.Resource != acct.TableNameTeam {
+			return httperr.NewNotFound(fmt.Errorf("handler: unknown resource %q", c.Resource), "resource")
+		}
+		team, err := findManagedTeam(db, currentUser, strconv.FormatInt(c.ID, 10))
+		if err != nil || c.Action == acct.ActionManageMembers {
+			return err
+		}
+		return authorizeManagers(db, currentUser, team)
+	}
+	return httperr.NewBadRequest(fmt.Errorf("handler: unknown action %q", c.Action), "unknown action")
+}
+
+// authorizeAction returns an error unless the policies let the current
+// user perform the action on the record of the resource.
+func authorizeAction(s gorp.SqlExecutor, currentUser *acct.User, action policy.Action, resource string, record interface{}) error {
+	allowed, err := policy.Allowed(s, currentUser, action, resource, record)
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	if !allowed {
+		err := fmt.Errorf("handler: %s of %s invalid request", action, resource)
+		return httperr.New(http.StatusForbidden, "user do not have permission to perform request", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testGroupsUrl = `http://localhost:8000/api/groups`
+	testGroupUrl  = `http://localhost:8000/api/groups/%d`
+)
+
+func TestGroups(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/groups", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ListGroups())}).Methods("GET")
+	r.Handle("/groups", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(CreateGroup())}).Methods("POST")
+	r.Handle("/groups/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(GetGroup())}).Methods("GET")
+	r.Handle("/groups/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(UpdateGroup())}).Methods("PUT")
+	r.Handle("/groups/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(DeleteGroup())}).Methods("DELETE")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.Create(&model.Meal{})}).Methods("POST")
+	do := apiClient(t, db, r)
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regularUser, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: the built-in groups are seeded with their former permissions
+	rec := do(admin, "GET", testGroupsUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	groups := []acct.Group{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&groups))
+	builtins := []acct.Group{acct.Admin, acct.UserManager, acct.Regular}
+	if assert.Len(t, groups, len(builtins)) {
+		for i, builtin := range builtins {
+			assert.Equal(t, builtin.ID, groups[i].ID)
+			assert.Equal(t, builtin.Name, groups[i].Name)
+			assert.Equal(t, permissionNames(builtin.Permissions), permissionNames(groups[i].Permissions))
+		}
+	}
+
+	// case 2: only admins manage groups
+	assert.Equal(t, http.StatusForbidden, do(regularUser, "GET", testGroupsUrl, nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(regularUser, "POST", testGroupsUrl,
+		map[string]interface{}{"Name": "Auditor", "Permissions": []string{acct.ReadAllMeals.Name}}).Code)
+
+	// case 3: permissions are given by name
+	rec = do(admin, "POST", testGroupsUrl, map[string]interface{}{"Name": "Auditor", "Permissions": []string{"read:everything"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(admin, "POST", testGroupsUrl, map[string]interface{}{"Name": "Auditor", "Permissions": []string{acct.ReadAllMeals.Name}})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	auditor := &acct.Group{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(auditor))
+	assert.True(t, auditor.ID > acct.Regular.ID)
+	assert.Equal(t, http.StatusBadRequest, do(admin, "POST", testGroupsUrl,
+		map[string]interface{}{"Name": "Auditor"}).Code)
+
+	rec = do(admin, "GET", fmt.Sprintf(testGroupUrl, auditor.ID), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(auditor))
+	assert.Equal(t, []string{acct.ReadAllMeals.Name}, permissionNames(auditor.Permissions))
+
+	// case 4: changes apply to the members' sessions right away
+	meal := map[string]interface{}{"description": "lunch", "calories": 500}
+	assert.Equal(t, http.StatusOK, do(regularUser, "POST", testMealsUrl, meal).Code)
+	rec = do(admin, "PUT", fmt.Sprintf(testGroupUrl, acct.Regular.ID), map[string]interface{}{
+		"Name":        acct.Regular.Name,
+		"Permissions": []string{acct.ReadUsers.Name, acct.ReadMeals.Name},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusForbidden, do(regularUser, "POST", testMealsUrl, meal).Code)
+	assert.Equal(t, http.StatusNotFound, do(admin, "PUT", fmt.Sprintf(testGroupUrl, 404), map[string]interface{}{"Name": "Missing"}).Code)
+
+	// case 5: built-in groups and groups with members can't be deleted
+	assert.Equal(t, http.StatusBadRequest, do(admin, "DELETE", fmt.Sprintf(testGroupUrl, acct.Regular.ID), nil).Code)
+	_, err := db.Exec("update users set GroupID = ? where ID = ?", auditor.ID, regularUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, do(admin, "DELETE", fmt.Sprintf(testGroupUrl, auditor.ID), nil).Code)
+	_, err = db.Exec("update users set GroupID = ? where ID = ?", acct.Regular.ID, regularUser.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, do(admin, "DELETE", fmt.Sprintf(testGroupUrl, auditor.ID), nil).Code)
+	assert.Nil(t, acct.GroupForID(auditor.ID))
+	assert.Equal(t, http.StatusNotFound, do(admin, "GET", fmt.Sprintf(testGroupUrl, auditor.ID), nil).Code)
+}
+
+func permissionNames(permissions []acct.Permission) []string {
+	names := []string{}
+	for _, p := range permissions {
+		names = append(names, p.Name)
+	}
+	return names
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+// ListGroups lets admins list the groups and their permissions.
+func ListGroups() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		if err := authorizeAction(db, currentUser, policy.Read, acct.TableNameGroup, nil); err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(acct.Groups())
+	}
+}
+
+// GetGroup lets admins look at a group and its permissions.
+func GetGroup() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		if err := authorizeAction(db, currentUser, policy.Read, acct.TableNameGroup, nil); err != nil {
+			return err
+		}
+		id, err := groupID(r)
+		if err != nil {
+			return err
+		}
+		group := acct.GroupForID(id)
+		if group == nil {
+			return httperr.NewNotFound(nil, "group")
+		}
+		return json.NewEncoder(w).Encode(group)
+	}
+}
+
+// CreateGroup lets super admins create a group with the permissions of the
+// permission names.
+func CreateGroup() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		if err := requireSuperAdmin(r, "create group"); err != nil {
+			return err
+		}
+		group, err := groupFromForm(r)
+		if err != nil {
+			return err
+		}
+		if err := saveGroup(db, group); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(group)
+	}
+}
+
+// UpdateGroup lets super admins rename a group and replace its permissions.  The
+// members of the group get the new permissions right away.
+func UpdateGroup() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		if err := requireSuperAdmin(r, "update group"); err != nil {
+			return err
+		}
+		id, err := groupID(r)
+		if err != nil {
+			return err
+		}
+		group, err := groupFromForm(r)
+		if err != nil {
+			return err
+		}
+		group.ID = id
+		if err := saveGroup(db, group); err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(group)
+	}
+}
+
+// DeleteGroup lets super admins delete a group without members.  The built-in
+// groups can't be deleted.
+func DeleteGroup() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		if err := requireSuperAdmin(r, "delete group"); err != nil {
+			return err
+		}
+		id, err := groupID(r)
+		if err != nil {
+			return err
+		}
+		group := acct.GroupForID(id)
+		if group == nil {
+			return httperr.NewNotFound(nil, "group")
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if err := acct.DeleteGroup(trans, id); err != nil {
+			trans.Rollback()
+			return groupError(err)
+		}
+		if err := trans.Commit(); err != nil {
+			return httperr.NewInternal(err)
+		}
+		acct.InvalidateGroups()
+		return json.NewEncoder(w).Encode(group)
+	}
+}
+
+func groupID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, httperr.New(http.StatusBadRequest, "invalid group", err)
+	}
+	return id, nil
+}
+
+func groupFromForm(r *http.Request) (*acct.Group, error) {
+	type Form struct {
+		Name        string
+		Permissions []string
+	}
+	form := &Form{}
+	if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+		return nil, httperr.New(http.StatusBadRequest, "invalid request", err)
+	}
+	group := &acct.Group{Name: form.Name, Permissions: []acct.Permission{}}
+	for _, name := range form.Permissions {
+		group.Permissions = append(group.Permissions, acct.Permission{Name: name})
+	}
+	return group, nil
+}
+
+// saveGroup saves the group in a transaction and reloads the groups once
+// it is committed.
+func saveGroup(db *gorp.DbMap, group *acct.Group) error {
+	trans, err := db.Begin()
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	if err := acct.SaveGroup(trans, group); err != nil {
+		trans.Rollback()
+		return groupError(err)
+	}
+	if err := trans.Commit(); err != nil {
+		return httperr.NewInternal(err)
+	}
+	acct.InvalidateGroups()
+	return nil
+}
+
+func groupError(err error) error {
+	if e, ok := err.(httperr.Error); ok {
+		return e
+	}
+	return httperr.NewInternal(err)
+}
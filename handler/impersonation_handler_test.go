@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testImpersonateUrl = `http://localhost:8000/api/user/%d/impersonate`
+)
+
+func TestImpersonation(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/user/{id}/impersonate", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(Impersonate())}).Methods("POST")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&model.Meal{})}).Methods("GET")
+	r.Handle("/sessions", &testhelpers.TestHandler{T: t, Db: db, Handler: ListSessions()}).Methods("GET")
+	r.Handle("/apikeys", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(mware.NotImpersonated(CreateAPIKey()))}).Methods("POST")
+	r.Handle("/user/{id}/resetPassword", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ResetPassword())}).Methods("POST")
+	r.Handle("/users/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.UpdateByID(&acct.User{})}).Methods("PUT")
+	do := apiClient(t, db, r)
+	logs := func() []*acct.ImpersonationLog {
+		var logs []*acct.ImpersonationLog
+		query, args, _ := squirrel.Select("*").
+			From(acct.TableNameImpersonationLog).
+			OrderBy("ID").ToSql()
+		_, err := db.Select(&logs, query, args...)
+		assert.NoError(t, err)
+		return logs
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	manager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	for _, u := range []*acct.User{admin, regular} {
+		assert.NoError(t, db.Insert(&model.Meal{UserID: u.ID, Description: "lunch", Calories: 500}))
+	}
+
+	// case 1: only admins may impersonate, and never themselves or other admins
+	assert.Equal(t, http.StatusForbidden, do(manager, "POST", fmt.Sprintf(testImpersonateUrl, regular.ID), nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(regular, "POST", fmt.Sprintf(testImpersonateUrl, manager.ID), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(admin, "POST", fmt.Sprintf(testImpersonateUrl, admin.ID), nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(admin, "POST", fmt.Sprintf(testImpersonateUrl, 1000), nil).Code)
+	_, err := db.Exec("update users set groupID = ? where ID = ?", acct.Admin.ID, manager.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, do(admin, "POST", fmt.Sprintf(testImpersonateUrl, manager.ID), nil).Code)
+	assert.Len(t, logs(), 0)
+
+	// case 2: the session is flagged with the admin and time-limited
+	rec := do(admin, "POST", fmt.Sprintf(testImpersonateUrl, regular.ID), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	impersonated := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(impersonated))
+	assert.Equal(t, regular.ID, impersonated.ID)
+	assert.Equal(t, "", impersonated.RefreshToken)
+	if assert.NotNil(t, impersonated.Impersonator) {
+		assert.Equal(t, admin.ID, impersonated.Impersonator.ID)
+	}
+	assert.True(t, impersonated.TokenExpiration < admin.TokenExpiration)
+	authenticated, err := acct.Authenticate(db, impersonated.Email, impersonated.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, regular.ID, authenticated.ID)
+	if assert.NotNil(t, authenticated.Impersonator) {
+		assert.Equal(t, admin.ID, authenticated.Impersonator.ID)
+	}
+
+	// case 3: the admin sees what the user sees and every request is recorded
+	rec = do(impersonated, "GET", testMealsUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var meals []*model.Meal
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&meals))
+	if assert.Len(t, meals, 1) {
+		assert.Equal(t, regular.ID, meals[0].UserID)
+	}
+	assert.Equal(t, http.StatusForbidden, do(impersonated, "POST", fmt.Sprintf(testImpersonateUrl, manager.ID), nil).Code)
+	tokenID, err := acct.TokenID(impersonated.Token)
+	assert.NoError(t, err)
+	entries := logs()
+	if assert.Len(t, entries, 3) {
+		for _, entry := range entries {
+			assert.Equal(t, admin.ID, entry.ImpersonatorID)
+			assert.Equal(t, regular.ID, entry.UserID)
+			assert.Equal(t, tokenID, entry.TokenID)
+		}
+		assert.Equal(t, http.StatusOK, entries[0].Status)
+		assert.Equal(t, "/api/meals", entries[1].Path)
+		assert.Equal(t, http.StatusOK, entries[1].Status)
+		assert.Equal(t, http.StatusForbidden, entries[2].Status)
+	}
+
+	// case 4: the user can see the impersonation among their sessions
+	rec = do(regular, "GET", testSessionsUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var sessions []acct.Session
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+	impersonations := 0
+	for _, s := range sessions {
+		if s.ID == tokenID {
+			assert.Equal(t, admin.ID, s.ImpersonatorID)
+			impersonations++
+		}
+	}
+	assert.Equal(t, 1, impersonations)
+
+	// case 5: credentials and security settings can't be changed while
+	// impersonating, other fields can
+	assert.Equal(t, http.StatusForbidden, do(impersonated, "POST", testAPIKeysUrl,
+		map[string]interface{}{"Name": "backdoor", "Scopes": []string{acct.ReadMeals.Name}}).Code)
+	assert.Equal(t, http.StatusForbidden, do(impersonated, "POST", fmt.Sprintf(testResetPasswordUrl, regular.ID),
+		map[string]string{"OldPassword": "i am rahul", "Password": "admin's password"}).Code)
+	assert.Equal(t, http.StatusForbidden, do(impersonated, "PUT", fmt.Sprintf(testUserUrl, regular.ID),
+		map[string]interface{}{"name": regular.Name, "email": "admin@gmail.com", "expectedCaloriesPerDay": 1800}).Code)
+	assert.Equal(t, http.StatusOK, do(impersonated, "PUT", fmt.Sprintf(testUserUrl, regular.ID),
+		map[string]interface{}{"name": regular.Name, "email": regular.Email, "expectedCaloriesPerDay": 1800}).Code)
+	assert.Equal(t, http.StatusOK, do(regular, "POST", testAPIKeysUrl,
+		map[string]interface{}{"Name": "mine", "Scopes": []string{acct.ReadMeals.Name}}).Code)
+
+	// case 6: deleting the admin's sessions ends the impersonation
+	assert.NoError(t, acct.DeleteSessions(db, admin.ID))
+	assert.Equal(t, http.StatusUnauthorized, do(impersonated, "GET", testMealsUrl, nil).Code)
+}
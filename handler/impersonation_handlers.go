@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// Impersonate lets admins act as another user to reproduce what the user
+// sees.  The returned token authenticates as the user until it expires or
+// is signed out, and every request made with it is recorded along with
+// the admin.
+func Impersonate() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid user", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		user, err := acct.Impersonate(trans, currentUser, id, acct.ClientFromRequest(r))
+		if err != nil {
+			return err
+		}
+		tokenID, err := acct.TokenID(user.Token)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		entry, err := acct.LogImpersonation(trans, user, tokenID, r)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if err = entry.SetStatus(trans, http.StatusOK); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(user)
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testLoginAttemptsUrl = `http://localhost:8000/api/login-attempts?email=%s`
+	testUnlockUserUrl    = `http://localhost:8000/api/user/%d/unlock`
+)
+
+func TestLoginBackoff(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/login", &testhelpers.TestHandler{T: t, Db: db, Handler: Login()}).Methods("POST")
+
+	// case 1: known and unknown emails are slowed down alike
+	for _, email := range []string{"ritik.rishu@hotcocoasoftware.com", "nobody@gmail.com"} {
+		for i := 0; i < 3; i++ {
+			rec := loginFrom(t, mainRouter, email, "wrong password", "")
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Body.String(), loginErrorMessage)
+		}
+		rec := loginFrom(t, mainRouter, email, "i am rahul", "")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	}
+
+	// case 2: the wait passes and a successful login clears the failures
+	ageLoginAttempts(t, db)
+	rec := loginFrom(t, mainRouter, "ritik.rishu@hotcocoasoftware.com", "i am rahul", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = loginFrom(t, mainRouter, "ritik.rishu@hotcocoasoftware.com", "wrong password", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = loginFrom(t, mainRouter, "ritik.rishu@hotcocoasoftware.com", "i am rahul", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// case 3: a client ip guessing passwords of many emails is slowed down
+	for i := 0; i < 20; i++ {
+		rec = loginFrom(t, mainRouter, fmt.Sprintf("guess%d@gmail.com", i), "wrong password", "10.0.0.1")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	rec = loginFrom(t, mainRouter, "rahul.yadav@hotcocoasoftware.com", "i am rahul", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	rec = loginFrom(t, mainRouter, "rahul.yadav@hotcocoasoftware.com", "i am rahul", "10.0.0.2")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// case 4: a client can't pick another ip with X-Forwarded-For unless it
+	// is a trusted proxy
+	payload, err := json.Marshal(map[string]string{"Email": "rahul.yadav@hotcocoasoftware.com", "Password": "i am rahul"})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", testLoginUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	req.RemoteAddr = "10.0.0.1:40000"
+	req.Header.Set("X-Forwarded-For", "10.0.0.3")
+	rec = httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestLoginLockout(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/login-attempts", &testhelpers.TestHandler{T: t, Db: db, Handler: LoginAttempts()}).Methods("GET")
+	r.Handle("/user/{id}/unlock", &testhelpers.TestHandler{T: t, Db: db, Handler: UnlockUser()}).Methods("POST")
+	do := apiClient(t, db, r)
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regularUser, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: the account is locked after too many failures
+	for i := 0; i < 5; i++ {
+		ageLoginAttempts(t, db)
+		rec := loginFrom(t, mainRouter, regularUser.Email, "wrong password", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	ageLoginAttempts(t, db)
+	rec := loginFrom(t, mainRouter, regularUser.Email, "i am rahul", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// case 2: only admins can see the attempts
+	rec = do(regularUser, "GET", fmt.Sprintf(testLoginAttemptsUrl, regularUser.Email), nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do(admin, "GET", fmt.Sprintf(testLoginAttemptsUrl, regularUser.Email), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var attempts []*acct.LoginAttempt
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&attempts))
+	results := map[string]int{}
+	for _, a := range attempts {
+		assert.Equal(t, regularUser.Email, a.Email)
+		assert.Equal(t, regularUser.ID, a.UserID)
+		results[a.Result]++
+	}
+	assert.Equal(t, map[string]int{
+		acct.LoginSucceeded: 1,
+		acct.LoginFailed:    5,
+		acct.LoginLockedOut: 1,
+		acct.LoginThrottled: 1,
+	}, results)
+
+	// case 3: only admins can unlock the account
+	rec = do(regularUser, "POST", fmt.Sprintf(testUnlockUserUrl, regularUser.ID), nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do(admin, "POST", fmt.Sprintf(testUnlockUserUrl, regularUser.ID), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = loginFrom(t, mainRouter, regularUser.Email, "i am rahul", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// loginFrom posts a login from the ip, the login route must be registered.
+func loginFrom(t *testing.T, mainRouter *mux.Router, email, password, ip string) *httptest.ResponseRecorder {
+	payload, err := json.Marshal(map[string]string{"Email": email, "Password": password})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", testLoginUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	if ip != "" {
+		req.RemoteAddr = ip + ":40000"
+	}
+	rec := httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	return rec
+}
+
+// ageLoginAttempts moves the recorded attempts a minute into the past so
+// the backoff after them is over.
+func ageLoginAttempts(t *testing.T, db *gorp.DbMap) {
+	_, err := db.Exec("update " + acct.TableNameLoginAttempt + " set Created = Created - 60000")
+	assert.NoError(t, err)
+}
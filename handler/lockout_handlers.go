@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+// LoginAttempts lets admins review the most recent logins of their
+// organization, including failures and lockouts.  The email and ip query
+// parameters narrow down the attempts.
+func LoginAttempts() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		if err := authorizeAction(db, currentUser, policy.Read, acct.TableNameLoginAttempt, nil); err != nil {
+			return err
+		}
+		q := r.URL.Query()
+		attempts, err := acct.LoginAttempts(db, currentUser.SubjectOrgID(), strings.ToLower(q.Get("email")), q.Get("ip"))
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(attempts)
+	}
+}
+
+// UnlockUser lets admins lift the lockout of a user after too many
+// failed logins.
+func UnlockUser() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		user, err := findOrgUser(r, db, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		if err := authorizeAction(db, currentUser, acct.ActionUnlock, acct.TableNameUser, user); err != nil {
+			return err
+		}
+		if err := acct.UnlockLogin(db, user.Email); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(user)
+	}
+}
+
+// requireSuperAdmin restricts the request to the users managing the
+// platform, such as changes affecting every organization.
+func requireSuperAdmin(r *http.Request, action string) error {
+	currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+	if !currentUser.SuperAdmin {
+		err := fmt.Errorf("handler: %s invalid request", action)
+		return httperr.New(http.StatusForbidden, "user do not have permission to perform request", err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+)
+
+var pageLink = regexp.MustCompile(`<([^>]+)>; rel="(prev|next)"`)
+
+func TestMealPagination(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&model.Meal{})}).Methods("GET")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	for _, calories := range []int64{300, 500, 500, 700, 500} {
+		assert.NoError(t, db.Insert(&model.Meal{UserID: regular.ID, Description: "lunch", Calories: calories}))
+	}
+	assert.NoError(t, db.Insert(&model.Meal{UserID: admin.ID, Description: "lunch", Calories: 400}))
+
+	// page returns the calories of the meals on the page and its links
+	page := func(url string) ([]int64, map[string]string, *httptest.ResponseRecorder) {
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		setAuth(req, regular)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var meals []*model.Meal
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&meals))
+		calories := []int64{}
+		for _, m := range meals {
+			calories = append(calories, m.Calories)
+		}
+		links := map[string]string{}
+		for _, match := range pageLink.FindAllStringSubmatch(rec.Header().Get("Link"), -1) {
+			links[match[2]] = "http://localhost:8000" + match[1]
+		}
+		return calories, links, rec
+	}
+
+	// case 1: the first page counts the meals of the user and links to the next
+	calories, links, rec := page(testMealsUrl + "?q-order=asc-calories&q-limit=2&q-count=true")
+	assert.Equal(t, []int64{300, 500}, calories)
+	assert.Equal(t, "5", rec.Header().Get("X-Total-Count"))
+	assert.NotContains(t, links, "prev")
+
+	// case 2: pages follow each other without skipping ties
+	calories, links, rec = page(links["next"])
+	assert.Equal(t, []int64{500, 500}, calories)
+	assert.Equal(t, "5", rec.Header().Get("X-Total-Count"))
+	calories, links, _ = page(links["next"])
+	assert.Equal(t, []int64{700}, calories)
+	assert.NotContains(t, links, "next")
+
+	// case 3: and lead back
+	calories, links, _ = page(links["prev"])
+	assert.Equal(t, []int64{500, 500}, calories)
+	calories, links, _ = page(links["prev"])
+	assert.Equal(t, []int64{300, 500}, calories)
+	assert.NotContains(t, links, "prev")
+
+	// case 4: cursors made for another order are rejected
+	req, err := http.NewRequest("GET", strings.Replace(links["next"], "asc-calories", "desc-id", 1), nil)
+	assert.NoError(t, err)
+	setAuth(req, regular)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// case 5: records sort by several keys
+	calories, links, _ = page(testMealsUrl + "?q-order=desc-calories,desc-mealDate&q-limit=2")
+	assert.Equal(t, []int64{700, 500}, calories)
+	calories, _, _ = page(links["next"])
+	assert.Equal(t, []int64{500, 500}, calories)
+
+	// case 6: unknown keys list the sortable fields
+	req, err = http.NewRequest("GET", testMealsUrl+"?q-order=desc-colour", nil)
+	assert.NoError(t, err)
+	setAuth(req, regular)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "mealDate")
+}
+
+func TestMealFilter(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&model.Meal{})}).Methods("GET")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	for _, m := range []*model.Meal{
+		{UserID: regular.ID, Description: "toast", Calories: 150},
+		{UserID: regular.ID, Description: "chicken salad", Calories: 450},
+		{UserID: regular.ID, Description: "pizza", Calories: 900},
+	} {
+		assert.NoError(t, db.Insert(m))
+	}
+	get := func(filter string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", testMealsUrl+"?"+url.Values{"q-filter": {filter}}.Encode(), nil)
+		assert.NoError(t, err)
+		setAuth(req, regular)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// case 1: comparisons combine with or
+	rec := get("calories lt 200 or description like '%salad%'")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var meals []*model.Meal
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&meals))
+	descriptions := []string{}
+	for _, m := range meals {
+		descriptions = append(descriptions, m.Description)
+	}
+	assert.ElementsMatch(t, []string{"toast", "chicken salad"}, descriptions)
+
+	// case 2: problems are reported with their position
+	rec = get("calories lt 200 or")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	resp := &struct{ Details struct{ Position int } }{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(t, 18, resp.Details.Position)
+
+	// case 3: parameters are checked against the types of their fields
+	req, err := http.NewRequest("GET", testMealsUrl+"?lt-calories=lots&colour=red", nil)
+	assert.NoError(t, err)
+	setAuth(req, regular)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	problems := &struct{ Details []struct{ Param string } }{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(problems))
+	if assert.Len(t, problems.Details, 2) {
+		assert.Equal(t, "colour", problems.Details[0].Param)
+		assert.Equal(t, "lt-calories", problems.Details[1].Param)
+	}
+}
+
+func TestMealFields(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&model.Meal{})}).Methods("GET")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetByID(&model.Meal{})}).Methods("GET")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	meal := &model.Meal{UserID: regular.ID, Description: "salad", Calories: 300}
+	assert.NoError(t, db.Insert(meal))
+	get := func(url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		setAuth(req, regular)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// case 1: lists only have the fields asked for
+	rec := get(testMealsUrl + "?q-fields=id,calories")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var meals []map[string]interface{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&meals))
+	if assert.Len(t, meals, 1) {
+		assert.Equal(t, map[string]interface{}{"id": float64(meal.ID), "calories": float64(300)}, meals[0])
+	}
+
+	// case 2: expanded records are narrowed too
+	rec = get(testMealsUrl + "?q-fields=description,user.name&q-expand=true")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, fmt.Sprintf(`[{"description": "salad", "user": {"name": %q}}]`, regular.Name), rec.Body.String())
+	rec = get(fmt.Sprintf(testMealUrl, meal.ID) + "?q-fields=description,user.name")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"description": "salad", "user": {"name": %q}}`, regular.Name), rec.Body.String())
+
+	// case 3: unknown fields and nested fields of records that aren't expanded are rejected
+	assert.Equal(t, http.StatusBadRequest, get(testMealsUrl+"?q-fields=colour").Code)
+	assert.Equal(t, http.StatusBadRequest, get(testMealsUrl+"?q-fields=user.name").Code)
+	assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf(testMealUrl, meal.ID)+"?q-fields=colour").Code)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testOrganizationsUrl = `http://localhost:8000/api/organizations`
+	testOrganizationUrl  = `http://localhost:8000/api/organizations/%d`
+)
+
+func TestOrganizations(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/signup", &testhelpers.TestHandler{T: t, Db: db, Handler: SignUp()}).Methods("POST")
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/organizations", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.Organization{})}).Methods("GET")
+	r.Handle("/organizations", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.Create(&acct.Organization{})}).Methods("POST")
+	r.Handle("/organizations/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.DeleteByID(&acct.Organization{})}).Methods("DELETE")
+	r.Handle("/users", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.User{})}).Methods("GET")
+	r.Handle("/createUser", &testhelpers.TestHandler{T: t, Db: db, Handler: CreateUser()}).Methods("POST")
+	do := apiClient(t, db, r)
+	users := func(user *acct.User) []*acct.User {
+		rec := do(user, "GET", testUsersUrl, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var users []*acct.User
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&users))
+		return users
+	}
+	signUp := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		mainRouter.ServeHTTP(rec, req)
+		return rec
+	}
+
+	superAdmin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	manager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: only super admins manage organizations
+	clinic := map[string]interface{}{"name": "Clinic", "slug": "Clinic", "openSignup": true}
+	assert.Equal(t, http.StatusForbidden, do(manager, "POST", testOrganizationsUrl, clinic).Code)
+	rec := do(superAdmin, "POST", testOrganizationsUrl, clinic)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	org := &acct.Organization{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(org))
+	assert.Equal(t, "clinic", org.Slug)
+	assert.Equal(t, http.StatusBadRequest, do(superAdmin, "POST", testOrganizationsUrl, map[string]interface{}{"name": "Nameless"}).Code)
+
+	// case 2: users sign up to the organization of the slug, if it is open
+	assert.Equal(t, http.StatusNotFound, signUp(map[string]interface{}{
+		"Email": "patient@clinic.com", "Password": "test password", "Organization": "unknown",
+	}).Code)
+	rec = do(superAdmin, "POST", testOrganizationsUrl, map[string]interface{}{"name": "Ward", "slug": "ward"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ward := &acct.Organization{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(ward))
+	assert.False(t, ward.OpenSignup)
+	assert.Equal(t, http.StatusNotFound, signUp(map[string]interface{}{
+		"Email": "patient@clinic.com", "Password": "test password", "Organization": "ward",
+	}).Code)
+	rec = signUp(map[string]interface{}{
+		"Email": "patient@clinic.com", "Name": "Patient", "Password": "test password", "Organization": "clinic",
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	patient := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(patient))
+	assert.Equal(t, org.ID, patient.OrgID)
+
+	// case 3: users only see their own organization
+	assert.Len(t, users(manager), 2)
+	assert.Len(t, users(superAdmin), 4)
+	rec = do(patient, "GET", testOrganizationsUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var orgs []*acct.Organization
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&orgs))
+	if assert.Len(t, orgs, 1) {
+		assert.Equal(t, org.ID, orgs[0].ID)
+	}
+
+	// case 4: users are created in the organization of the creator
+	rec = do(superAdmin, "POST", testCreateUserUrl, map[string]interface{}{
+		"Email": "admin@clinic.com", "Name": "Clinic Admin", "GroupID": acct.Admin.ID, "OrgID": org.ID,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	admin := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(admin))
+	assert.Equal(t, org.ID, admin.OrgID)
+	assert.Len(t, users(admin), 2)
+	assert.Equal(t, http.StatusBadRequest, do(admin, "POST", testCreateUserUrl, map[string]interface{}{
+		"Email": "intruder@clinic.com", "GroupID": acct.Regular.ID, "OrgID": acct.DefaultOrgID,
+	}).Code)
+
+	// case 5: organizations with users and the default one can't be deleted
+	assert.Equal(t, http.StatusOK, do(superAdmin, "DELETE", fmt.Sprintf(testOrganizationUrl, ward.ID), nil).Code)
+	assert.Equal(t, http.StatusConflict, do(superAdmin, "DELETE", fmt.Sprintf(testOrganizationUrl, org.ID), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(superAdmin, "DELETE", fmt.Sprintf(testOrganizationUrl, acct.DefaultOrgID), nil).Code)
+}
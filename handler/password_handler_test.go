@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/mail"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/pwpolicy"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+)
+
+const (
+	testForgotPasswordUrl = `http://localhost:8000/password/forgot`
+	testResetForgottenUrl = `http://localhost:8000/password/reset`
+)
+
+var passwordResetLink = regexp.MustCompile(`/password/reset\?token=(\S+)`)
+
+func TestForgotPassword(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	recorder := &mail.Recorder{}
+	mail.SetDefault(recorder)
+	defer mail.SetDefault(nil)
+
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/password/forgot", &testhelpers.TestHandler{T: t, Db: db, Handler: ForgotPassword()}).Methods("POST")
+	mainRouter.Handle("/password/reset", &testhelpers.TestHandler{T: t, Db: db, Handler: ResetForgottenPassword()}).Methods("POST")
+	post := func(url string, body interface{}) int {
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		mainRouter.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	user, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: unknown emails look the same as known ones
+	assert.Equal(t, http.StatusAccepted, post(testForgotPasswordUrl, map[string]string{"Email": "nobody@gmail.com"}))
+	assert.Empty(t, recorder.Messages())
+
+	// case 2: a reset link is emailed and requests are rate limited
+	assert.Equal(t, http.StatusAccepted, post(testForgotPasswordUrl, map[string]string{"Email": user.Email}))
+	assert.Equal(t, http.StatusAccepted, post(testForgotPasswordUrl, map[string]string{"Email": user.Email}))
+	assert.Len(t, recorder.Messages(), 1)
+	message, ok := recorder.Last(user.Email)
+	assert.True(t, ok)
+	match := passwordResetLink.FindStringSubmatch(message.Body)
+	assert.Len(t, match, 2)
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+
+	// case 3: a forged token is rejected
+	assert.Equal(t, http.StatusBadRequest, post(testResetForgottenUrl, map[string]string{"Token": "forged" + token, "Password": "new password"}))
+
+	// case 4: the token sets the password and revokes every session
+	assert.Equal(t, http.StatusOK, post(testResetForgottenUrl, map[string]string{"Token": token, "Password": "new password"}))
+	_, err = acct.Authenticate(db, user.Email, user.Token)
+	assert.Error(t, err)
+	_, respCode = loginRequest(t, mainRouter, db, user.Email, "i am rahul")
+	assert.Equal(t, http.StatusUnauthorized, respCode)
+	_, respCode = loginRequest(t, mainRouter, db, user.Email, "new password")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 5: the token can only be used once
+	assert.Equal(t, http.StatusBadRequest, post(testResetForgottenUrl, map[string]string{"Token": token, "Password": "another password"}))
+}
+
+func TestResetPasswordByAdmin(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/user/{id}/resetPassword", &testhelpers.TestHandler{T: t, Db: db, Handler: ResetPassword()}).Methods("POST")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// admins don't need to know the old password of other users
+	payload, err := json.Marshal(map[string]string{"Password": "set by admin"})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl, 3), bytes.NewReader(payload))
+	assert.NoError(t, err)
+	setAuth(req, admin)
+	rec := httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, respCode = loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "set by admin")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// but they do for their own password
+	req, err = http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl, admin.ID), bytes.NewReader(payload))
+	assert.NoError(t, err)
+	setAuth(req, admin)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/signup", &testhelpers.TestHandler{T: t, Db: db, Handler: SignUp()}).Methods("POST")
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/user/{id}/resetPassword", &testhelpers.TestHandler{T: t, Db: db, Handler: ResetPassword()}).Methods("POST")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	violations := func(rec *httptest.ResponseRecorder) []string {
+		resp := &struct{ Details []pwpolicy.Violation }{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+		rules := []string{}
+		for _, v := range resp.Details {
+			rules = append(rules, v.Rule)
+		}
+		return rules
+	}
+
+	// every violated rule is listed
+	payload, err := json.Marshal(map[string]string{"Email": "weak@gmail.com", "Name": "weak", "Password": "abc"})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []string{pwpolicy.RuleMinLength, pwpolicy.RuleCharacterClasses}, violations(rec))
+
+	payload, err = json.Marshal(map[string]string{"Email": "weak@gmail.com", "Name": "weak", "Password": "password1"})
+	assert.NoError(t, err)
+	req, err = http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []string{pwpolicy.RuleBreached}, violations(rec))
+
+	// recent passwords can't be reused
+	resetOwn := func(old, password string) *httptest.ResponseRecorder {
+		user, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", old)
+		assert.Equal(t, http.StatusOK, respCode)
+		payload, err := json.Marshal(map[string]string{"OldPassword": old, "Password": password})
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", fmt.Sprintf(testResetPasswordUrl, user.ID), bytes.NewReader(payload))
+		assert.NoError(t, err)
+		setAuth(req, user)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec
+	}
+	rec = resetOwn("i am rahul", "i am rahul")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []string{pwpolicy.RuleHistory}, violations(rec))
+
+	assert.Equal(t, http.StatusOK, resetOwn("i am rahul", "first new password").Code)
+	assert.Equal(t, http.StatusOK, resetOwn("first new password", "second new password").Code)
+	rec = resetOwn("second new password", "first new password")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, []string{pwpolicy.RuleHistory}, violations(rec))
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// ForgotPassword emails a password reset token to the user.  The response
+// is the same whether or not the email belongs to an account.
+func ForgotPassword() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Email string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		if err := acct.RequestPasswordReset(db, form.Email); err != nil {
+			return httperr.NewInternal(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+}
+
+// ResetForgottenPassword sets a new password with a password reset token.
+func ResetForgottenPassword() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Token    string
+			Password string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		user, err := acct.ResetPasswordWithToken(trans, form.Token, form.Password)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(user)
+	}
+}
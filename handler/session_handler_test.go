@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+)
+
+const (
+	testSessionsUrl = `http://localhost:8000/api/sessions`
+	testSessionUrl  = `http://localhost:8000/api/sessions/%d`
+)
+
+func TestSessions(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/sessions", &testhelpers.TestHandler{T: t, Db: db, Handler: ListSessions()}).Methods("GET")
+	r.Handle("/sessions", &testhelpers.TestHandler{T: t, Db: db, Handler: RevokeOtherSessions()}).Methods("DELETE")
+	r.Handle("/sessions/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: RevokeSession()}).Methods("DELETE")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	listSessions := func(user *acct.User) []acct.Session {
+		req, err := http.NewRequest("GET", testSessionsUrl, nil)
+		assert.NoError(t, err)
+		setAuth(req, user)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var sessions []acct.Session
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&sessions))
+		return sessions
+	}
+
+	laptop, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	phone, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	tablet, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: every login is listed and the current one is flagged
+	sessions := listSessions(laptop)
+	assert.Len(t, sessions, 3)
+	laptopID, err := acct.TokenID(laptop.Token)
+	assert.NoError(t, err)
+	for _, s := range sessions {
+		assert.Equal(t, s.ID == laptopID, s.Current)
+	}
+
+	// case 2: revoking a session stops it from authenticating
+	phoneID, err := acct.TokenID(phone.Token)
+	assert.NoError(t, err)
+	req, err := http.NewRequest("DELETE", fmt.Sprintf(testSessionUrl, phoneID), nil)
+	assert.NoError(t, err)
+	setAuth(req, laptop)
+	rec := httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, err = acct.Authenticate(db, phone.Email, phone.Token)
+	assert.Error(t, err)
+
+	// case 3: revoking all other sessions keeps the current one
+	req, err = http.NewRequest("DELETE", testSessionsUrl, nil)
+	assert.NoError(t, err)
+	setAuth(req, laptop)
+	rec = httptest.NewRecorder()
+	middleware.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, err = acct.Authenticate(db, tablet.Email, tablet.Token)
+	assert.Error(t, err)
+	sessions = listSessions(laptop)
+	assert.Len(t, sessions, 1)
+}
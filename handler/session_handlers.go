@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// ListSessions returns the active sessions of the current user.
+func ListSessions() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		currentID, _ := acct.TokenID(acct.CredentialsFromRequest(r).Token)
+		sessions, err := acct.Sessions(db, currentUser.ID, currentID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(sessions)
+	}
+}
+
+// RevokeSession revokes one of the current user's sessions.
+func RevokeSession() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		params := mux.Vars(r)
+		id, err := strconv.ParseInt(params["id"], 10, 64)
+		if err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid session", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		if err = acct.RevokeSession(trans, currentUser.ID, id); err != nil {
+			return httperr.NewNotFound(err, "session")
+		}
+		return nil
+	}
+}
+
+// RevokeOtherSessions revokes every session of the current user except
+// the one making the request.
+func RevokeOtherSessions() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		currentID, err := acct.TokenID(acct.CredentialsFromRequest(r).Token)
+		if err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid token", err)
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		if err = acct.RevokeOtherSessions(trans, currentUser.ID, currentID); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSharesUrl = `http://localhost:8000/api/shares`
+	testShareUrl  = `http://localhost:8000/api/shares/%d`
+	testMealUrl   = `http://localhost:8000/api/meals/%d`
+)
+
+func TestShareMeals(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/shares", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ListShares())}).Methods("GET")
+	r.Handle("/shares", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(CreateShare())}).Methods("POST")
+	r.Handle("/shares/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(RevokeShare())}).Methods("DELETE")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&model.Meal{})}).Methods("GET")
+	r.Handle("/meals", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.Create(&model.Meal{})}).Methods("POST")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetByID(&model.Meal{})}).Methods("GET")
+	r.Handle("/meals/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.UpdateByID(&model.Meal{})}).Methods("PUT")
+	do := apiClient(t, db, r)
+	meals := func(user *acct.User, url string) []*model.Meal {
+		rec := do(user, "GET", url, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var meals []*model.Meal
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&meals))
+		return meals
+	}
+
+	coach, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	owner, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	lunch := map[string]interface{}{"userID": owner.ID, "description": "lunch", "calories": 500}
+	rec := do(owner, "POST", testMealsUrl, lunch)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	meal := &model.Meal{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(meal))
+
+	// case 1: meals are private until shared
+	assert.Len(t, meals(coach, testMealsUrl), 0)
+	assert.Equal(t, http.StatusNotFound, do(coach, "GET", fmt.Sprintf(testMealUrl, meal.ID), nil).Code)
+
+	// case 2: shares are validated
+	assert.Equal(t, http.StatusBadRequest, do(owner, "POST", testSharesUrl,
+		map[string]interface{}{"Email": coach.Email, "Access": "admin"}).Code)
+	assert.Equal(t, http.StatusBadRequest, do(owner, "POST", testSharesUrl,
+		map[string]interface{}{"Email": owner.Email, "Access": acct.ShareRead}).Code)
+	assert.Equal(t, http.StatusNotFound, do(owner, "POST", testSharesUrl,
+		map[string]interface{}{"Email": "nobody@gmail.com", "Access": acct.ShareRead}).Code)
+	assert.Equal(t, http.StatusBadRequest, do(owner, "POST", testSharesUrl, map[string]interface{}{
+		"Email": coach.Email, "Access": acct.ShareRead, "Expiration": milli.Timestamp(time.Now().Add(-time.Hour)),
+	}).Code)
+
+	// case 3: read access lets the grantee read but not write
+	rec = do(owner, "POST", testSharesUrl, map[string]interface{}{"Email": coach.Email, "Access": acct.ShareRead})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, meals(coach, testMealsUrl), 1)
+	assert.Len(t, meals(coach, fmt.Sprintf("%s?userID=%d", testMealsUrl, owner.ID)), 1)
+	assert.Len(t, meals(coach, fmt.Sprintf("%s?userID=%d", testMealsUrl, coach.ID)), 0)
+	assert.Equal(t, http.StatusOK, do(coach, "GET", fmt.Sprintf(testMealUrl, meal.ID), nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(coach, "PUT", fmt.Sprintf(testMealUrl, meal.ID), lunch).Code)
+	assert.Equal(t, http.StatusForbidden, do(coach, "POST", testMealsUrl, lunch).Code)
+
+	// case 4: write access replaces read access
+	rec = do(owner, "POST", testSharesUrl, map[string]interface{}{"Email": coach.Email, "Access": acct.ShareWrite})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	share := &acct.Share{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(share))
+	assert.Equal(t, http.StatusOK, do(coach, "PUT", fmt.Sprintf(testMealUrl, meal.ID), lunch).Code)
+	assert.Equal(t, http.StatusOK, do(coach, "POST", testMealsUrl, lunch).Code)
+	assert.Len(t, meals(coach, testMealsUrl), 2)
+
+	rec = do(coach, "GET", testSharesUrl, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var shares []*acct.Share
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&shares))
+	if assert.Len(t, shares, 1) {
+		assert.Equal(t, acct.ShareWrite, shares[0].Access)
+		assert.Equal(t, owner.Email, shares[0].Owner.Email)
+	}
+
+	// case 5: expired shares no longer grant access
+	_, err := db.Exec("update shares set Expiration = ? where ID = ?", milli.Timestamp(time.Now().Add(-time.Minute)), share.ID)
+	assert.NoError(t, err)
+	assert.Len(t, meals(coach, testMealsUrl), 0)
+	_, err = db.Exec("update shares set Expiration = 0 where ID = ?", share.ID)
+	assert.NoError(t, err)
+
+	// case 6: the grantee can give up the share
+	assert.Equal(t, http.StatusOK, do(coach, "DELETE", fmt.Sprintf(testShareUrl, share.ID), nil).Code)
+	assert.Len(t, meals(coach, testMealsUrl), 0)
+	assert.Equal(t, http.StatusNotFound, do(owner, "DELETE", fmt.Sprintf(testShareUrl, share.ID), nil).Code)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// ListShares returns the meal shares the current user gave and received.
+func ListShares() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		shares, err := acct.Shares(db, currentUser.ID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		return mware.EncodeReadable(w, db, currentUser, acct.TableNameShare, shares)
+	}
+}
+
+// CreateShare shares the current user's meals with the user of the email
+// for reading or for reading and writing, optionally until the expiration.
+func CreateShare() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Email      string
+			Access     string
+			Expiration int64
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		share, err := acct.ShareMeals(trans, currentUser, form.Email, form.Access, form.Expiration)
+		if err != nil {
+			if e, ok := err.(httperr.Error); ok {
+				return e
+			}
+			return httperr.NewInternal(err)
+		}
+		return mware.EncodeReadable(w, trans, currentUser, acct.TableNameShare, share)
+	}
+}
+
+// RevokeShare stops sharing meals.  Owners revoke the access they gave,
+// grantees give up the access they received.
+func RevokeShare() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid share", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		share, err := acct.RevokeShare(db, currentUser, id)
+		if err != nil {
+			return err
+		}
+		return mware.EncodeReadable(w, db, currentUser, acct.TableNameShare, share)
+	}
+}
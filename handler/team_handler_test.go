@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testTeamsUrl       = `http://localhost:8000/api/teams`
+	testTeamMembersUrl = `http://localhost:8000/api/teams/%d/members`
+	testTeamMemberUrl  = `http://localhost:8000/api/teams/%d/members/%d`
+	testUserUrl        = `http://localhost:8000/api/users/%d`
+)
+
+func TestTeams(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/teams", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.Team{})}).Methods("GET")
+	r.Handle("/teams", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.Create(&acct.Team{})}).Methods("POST")
+	r.Handle("/teams/{id}/members", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ListTeamMembers())}).Methods("GET")
+	r.Handle("/teams/{id}/members/{userId}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(SetTeamMember())}).Methods("PUT")
+	r.Handle("/teams/{id}/members/{userId}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(RemoveTeamMember())}).Methods("DELETE")
+	r.Handle("/users", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.User{})}).Methods("GET")
+	r.Handle("/users/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetByID(&acct.User{})}).Methods("GET")
+	r.Handle("/user/{id}/resetPassword", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ResetPassword())}).Methods("POST")
+	r.Handle("/createUser", &testhelpers.TestHandler{T: t, Db: db, Handler: CreateUser()}).Methods("POST")
+	do := apiClient(t, db, r)
+	users := func(user *acct.User) []int64 {
+		rec := do(user, "GET", testUsersUrl, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var users []*acct.User
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&users))
+		ids := []int64{}
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	manager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: managers only see themselves and the members of their teams
+	assert.ElementsMatch(t, []int64{manager.ID, regular.ID}, users(manager))
+	assert.Equal(t, http.StatusNotFound, do(manager, "GET", fmt.Sprintf(testUserUrl, admin.ID), nil).Code)
+	assert.Equal(t, http.StatusOK, do(manager, "GET", fmt.Sprintf(testUserUrl, regular.ID), nil).Code)
+
+	// case 2: users outside the teams of the manager are out of reach
+	rec := do(admin, "POST", testCreateUserUrl, map[string]interface{}{
+		"Email": "outsider@gmail.com", "Name": "Outsider", "GroupID": acct.Regular.ID, "Password": "test password",
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	outsider := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(outsider))
+	assert.NotContains(t, users(manager), outsider.ID)
+	assert.Equal(t, http.StatusBadRequest, do(manager, "POST", fmt.Sprintf(testResetPasswordUrl, outsider.ID),
+		map[string]string{"Password": "another password"}).Code)
+	assert.Equal(t, http.StatusForbidden, do(manager, "PUT", fmt.Sprintf(testTeamMemberUrl, 1, outsider.ID), nil).Code)
+
+	// case 3: only admins create teams and appoint managers
+	assert.Equal(t, http.StatusForbidden, do(manager, "POST", testTeamsUrl, map[string]interface{}{"name": "Night shift"}).Code)
+	rec = do(admin, "POST", testTeamsUrl, map[string]interface{}{"name": "Night shift"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	team := &acct.Team{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(team))
+	assert.Equal(t, acct.DefaultOrgID, team.OrgID)
+	assert.Equal(t, http.StatusNotFound, do(manager, "GET", fmt.Sprintf(testTeamMembersUrl, team.ID), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(admin, "PUT", fmt.Sprintf(testTeamMemberUrl, team.ID, regular.ID),
+		map[string]bool{"Manager": true}).Code)
+	assert.Equal(t, http.StatusForbidden, do(manager, "PUT", fmt.Sprintf(testTeamMemberUrl, 1, regular.ID),
+		map[string]bool{"Manager": true}).Code)
+	assert.Equal(t, http.StatusOK, do(admin, "PUT", fmt.Sprintf(testTeamMemberUrl, team.ID, manager.ID),
+		map[string]bool{"Manager": true}).Code)
+
+	// case 4: managers move the users they manage between their teams
+	assert.Equal(t, http.StatusOK, do(manager, "PUT", fmt.Sprintf(testTeamMemberUrl, team.ID, regular.ID), nil).Code)
+	assert.Equal(t, http.StatusOK, do(manager, "DELETE", fmt.Sprintf(testTeamMemberUrl, 1, regular.ID), nil).Code)
+	removals, err := db.SelectInt("select count(*) from "+acct.TableNameAuditEntry+" where Action = ? and Model = ? and RecordID = ?",
+		string(acct.ActionManageMembers), acct.TableNameTeam, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), removals)
+	rec = do(manager, "GET", fmt.Sprintf(testTeamMembersUrl, team.ID), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var members []*acct.TeamMember
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&members))
+	if assert.Len(t, members, 2) {
+		assert.Equal(t, manager.ID, members[0].UserID)
+		assert.True(t, members[0].Manager)
+		assert.Equal(t, regular.ID, members[1].UserID)
+		assert.Equal(t, regular.Email, members[1].User.Email)
+	}
+	assert.Equal(t, http.StatusForbidden, do(manager, "DELETE", fmt.Sprintf(testTeamMemberUrl, team.ID, manager.ID), nil).Code)
+
+	// case 5: users leaving the teams of the manager are out of reach
+	assert.Equal(t, http.StatusOK, do(admin, "DELETE", fmt.Sprintf(testTeamMemberUrl, team.ID, regular.ID), nil).Code)
+	assert.ElementsMatch(t, []int64{manager.ID}, users(manager))
+	assert.Equal(t, http.StatusNotFound, do(manager, "DELETE", fmt.Sprintf(testTeamMemberUrl, team.ID, regular.ID), nil).Code)
+
+	// case 6: admins in the teams of the manager are still out of reach
+	assert.Equal(t, http.StatusOK, do(admin, "PUT", fmt.Sprintf(testTeamMemberUrl, team.ID, admin.ID), nil).Code)
+	assert.ElementsMatch(t, []int64{manager.ID}, users(manager))
+	assert.Equal(t, http.StatusNotFound, do(manager, "GET", fmt.Sprintf(testUserUrl, admin.ID), nil).Code)
+	assert.Equal(t, http.StatusForbidden, do(manager, "PUT", fmt.Sprintf(testTeamMemberUrl, team.ID, admin.ID), nil).Code)
+}
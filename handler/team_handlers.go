@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+// ListTeamMembers returns the members of the team, managers first.
+func ListTeamMembers() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		team := &acct.Team{}
+		if err := mware.GetID(db, currentUser, team, mux.Vars(r)["id"], policy.Read); err != nil {
+			return err
+		}
+		members, err := acct.TeamMembers(db, team.ID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		return mware.EncodeReadable(w, db, currentUser, acct.TableNameTeamMember, members)
+	}
+}
+
+// SetTeamMember adds the user to the team or changes whether or not the
+// member is a manager.  Managers may add the users they already manage to
+// their other teams; only those managing every user appoint managers.
+func SetTeamMember() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Manager bool
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		team, err := findManagedTeam(trans, currentUser, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		user, err := findOrgUser(r, trans, mux.Vars(r)["userId"])
+		if err != nil {
+			return err
+		}
+		if err = user.Expand(trans, ""); err != nil {
+			return httperr.NewInternal(err)
+		}
+		allowed, err := policy.Allowed(trans, currentUser, policy.Write, acct.TableNameUser, user)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if !allowed {
+			err = fmt.Errorf("handler: user %d may not add user %d to a team", currentUser.ID, user.ID)
+			return httperr.New(http.StatusForbidden, "user do not have permission to perform request", err)
+		}
+		member, err := team.Member(trans, user.ID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if form.Manager || member != nil && member.Manager {
+			if err = authorizeManagers(trans, currentUser, team); err != nil {
+				return err
+			}
+		}
+		var before interface{}
+		if member != nil {
+			before = memberAttributes(member)
+		}
+		if member, err = team.SetMember(trans, user, form.Manager); err != nil {
+			return err
+		}
+		if err = acct.Audit(trans, currentUser, r.Header.Get("X-Request-Id"), string(acct.ActionManageMembers),
+			acct.TableNameTeam, team.ID, before, memberAttributes(member)); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return mware.EncodeReadable(w, trans, currentUser, acct.TableNameTeamMember, member)
+	}
+}
+
+// RemoveTeamMember removes the user from the team.
+func RemoveTeamMember() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		team, err := findManagedTeam(trans, currentUser, mux.Vars(r)["id"])
+		if err != nil {
+			return err
+		}
+		user, err := findOrgUser(r, trans, mux.Vars(r)["userId"])
+		if err != nil {
+			return err
+		}
+		member, err := team.Member(trans, user.ID)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if member == nil {
+			err = fmt.Errorf("handler: user %d isn't a member of team %d", user.ID, team.ID)
+			return httperr.NewNotFound(err, "team member")
+		}
+		if member.Manager {
+			if err = authorizeManagers(trans, currentUser, team); err != nil {
+				return err
+			}
+		}
+		if err = team.RemoveMember(trans, user.ID); err != nil {
+			return httperr.NewInternal(err)
+		}
+		if err = acct.Audit(trans, currentUser, r.Header.Get("X-Request-Id"), string(acct.ActionManageMembers),
+			acct.TableNameTeam, team.ID, memberAttributes(member), nil); err != nil {
+			return httperr.NewInternal(err)
+		}
+		member.User = user
+		return mware.EncodeReadable(w, trans, currentUser, acct.TableNameTeamMember, member)
+	}
+}
+
+// findManagedTeam returns the team with the id if the current user may
+// change its members.
+func findManagedTeam(s gorp.SqlExecutor, currentUser *acct.User, id string) (*acct.Team, error) {
+	team := &acct.Team{}
+	if err := mware.GetID(s, currentUser, team, id, policy.Read); err != nil {
+		return nil, err
+	}
+	allowed, err := policy.Allowed(s, currentUser, acct.ActionManageMembers, acct.TableNameTeam, team)
+	if err != nil {
+		return nil, httperr.NewInternal(err)
+	}
+	if !allowed {
+		err = fmt.Errorf("handler: user %d may not manage team %d", currentUser.ID, team.ID)
+		return nil, httperr.New(http.StatusForbidden, "user do not have permission to perform request", err)
+	}
+	return team, nil
+}
+
+// authorizeManagers returns an error unless the current user may appoint
+// and remove the managers of the team.
+func authorizeManagers(s gorp.SqlExecutor, currentUser *acct.User, team *acct.Team) error {
+	allowed, err := policy.Allowed(s, currentUser, acct.ActionAssignManagers, acct.TableNameTeam, team)
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	if !allowed {
+		err = fmt.Errorf("handler: user %d may not assign managers of team %d", currentUser.ID, team.ID)
+		return httperr.New(http.StatusForbidden, "user do not have permission to perform request", err)
+	}
+	return nil
+}
+
+// memberAttributes returns the audited attributes of the team member.
+func memberAttributes(member *acct.TeamMember) map[string]interface{} {
+	return map[string]interface{}{"userID": member.UserID, "manager": member.Manager}
+}
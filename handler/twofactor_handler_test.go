@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/rahul2393/small-assignment-server/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+)
+
+const (
+	testLoginTwoFactorUrl  = `http://localhost:8000/login/2fa`
+	testSetupTwoFactorUrl  = `http://localhost:8000/api/user/%d/2fa/setup`
+	testVerifyTwoFactorUrl = `http://localhost:8000/api/user/%d/2fa/verify`
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/login/2fa", &testhelpers.TestHandler{T: t, Db: db, Handler: LoginTwoFactor()}).Methods("POST")
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/user/{id}/2fa/setup", &testhelpers.TestHandler{T: t, Db: db, Handler: SetupTwoFactor()}).Methods("POST")
+	r.Handle("/user/{id}/2fa/verify", &testhelpers.TestHandler{T: t, Db: db, Handler: VerifyTwoFactor()}).Methods("POST")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	post := func(h http.Handler, url string, user *acct.User, body interface{}) *httptest.ResponseRecorder {
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+		assert.NoError(t, err)
+		if user != nil {
+			setAuth(req, user)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	user, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: users can't enroll someone else
+	rec := post(middleware, fmt.Sprintf(testSetupTwoFactorUrl, 1), user, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// case 2: enroll with a code of the new secret
+	rec = post(middleware, fmt.Sprintf(testSetupTwoFactorUrl, user.ID), user, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	setup := &acct.TwoFactorSetup{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(setup))
+	code, err := totp.Code(setup.Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	rec = post(middleware, fmt.Sprintf(testVerifyTwoFactorUrl, user.ID), user, map[string]string{"Code": code})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	enrolled := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(enrolled))
+	assert.True(t, enrolled.TwoFactorEnabled)
+	assert.NotEmpty(t, enrolled.RecoveryCodes)
+
+	// case 3: password alone now yields a challenge instead of a token
+	challenged, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	assert.Empty(t, challenged.Token)
+	challenge := loginChallenge(t, mainRouter, db)
+
+	// case 4: the code used for enrollment can't be replayed
+	rec = post(mainRouter, testLoginTwoFactorUrl, nil, map[string]string{"Challenge": challenge, "Code": code})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// case 5: a recovery code works exactly once
+	rec = post(mainRouter, testLoginTwoFactorUrl, nil,
+		map[string]string{"Challenge": challenge, "Code": enrolled.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	loggedIn := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(loggedIn))
+	assert.NotEmpty(t, loggedIn.Token)
+
+	challenge = loginChallenge(t, mainRouter, db)
+	rec = post(mainRouter, testLoginTwoFactorUrl, nil,
+		map[string]string{"Challenge": challenge, "Code": enrolled.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// case 6: wrong codes are failed logins, which the password alone
+	// doesn't clear but the second factor does
+	failures := func() int64 {
+		count, err := db.SelectInt("select count(*) from "+acct.TableNameLoginAttempt+
+			" where Email = ? and Result = ? and Cleared = 0", user.Email, acct.LoginFailed)
+		assert.NoError(t, err)
+		return count
+	}
+	assert.Equal(t, int64(1), failures())
+	challenge = loginChallenge(t, mainRouter, db)
+	assert.Equal(t, int64(1), failures())
+	rec = post(mainRouter, testLoginTwoFactorUrl, nil, map[string]string{"Challenge": challenge, "Code": "not a code"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, int64(2), failures())
+	rec = post(mainRouter, testLoginTwoFactorUrl, nil,
+		map[string]string{"Challenge": challenge, "Code": enrolled.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(0), failures())
+}
+
+func loginChallenge(t *testing.T, mainRouter *mux.Router, db *gorp.DbMap) string {
+	payload, err := json.Marshal(map[string]string{
+		"Email":    "ritik.rishu@hotcocoasoftware.com",
+		"Password": "i am rahul",
+	})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", testLoginUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	challenge := &acct.LoginChallenge{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(challenge))
+	assert.NotEmpty(t, challenge.Challenge)
+	return challenge.Challenge
+}
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// LoginTwoFactor exchanges a login challenge and a one-time code for a
+// token.  Users whose group requires two-factor authentication but who
+// haven't enrolled yet enable it with the code of the secret returned by
+// LoginTwoFactorSetup and receive their recovery codes.
+func LoginTwoFactor() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Challenge string
+			Code      string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		challenge, err := acct.LoginChallengeFor(form.Challenge)
+		if err != nil {
+			return err
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		loginUser, err := findActiveUser(trans, challenge.UserID)
+		if err != nil {
+			return err
+		}
+		// codes are guessed under the same limits as passwords
+		client := acct.ClientFromRequest(r)
+		attempt := &acct.LoginAttempt{Email: loginUser.Email, UserID: loginUser.ID, IP: client.IP, UserAgent: client.UserAgent}
+		var wait time.Duration
+		if wait, err = acct.LoginDelay(trans, loginUser.Email, client.IP); err != nil {
+			return err
+		}
+		if wait > 0 {
+			attempt.Result = acct.LoginThrottled
+			if err = acct.RecordLogin(db, attempt); err != nil {
+				return err
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(wait/time.Second)+1, 10))
+			err = acct.ErrTooManyLogins
+			return err
+		}
+		if loginUser.TwoFactorEnabled {
+			var ok bool
+			if ok, err = loginUser.VerifyTwoFactor(trans, form.Code); err == nil && !ok {
+				err = acct.ErrInvalidTwoFactorCode
+			}
+		} else {
+			loginUser.RecoveryCodes, err = loginUser.EnableTwoFactor(trans, form.Code)
+		}
+		if err == acct.ErrInvalidTwoFactorCode {
+			// failures are recorded outside of the transaction that is
+			// rolled back
+			attempt.Result = acct.LoginFailed
+			if recordErr := acct.RecordLogin(db, attempt); recordErr != nil {
+				err = recordErr
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		attempt.Result = acct.LoginSucceeded
+		if err = acct.RecordLogin(trans, attempt); err != nil {
+			return err
+		}
+		challenge.Complete()
+		err = writeLogin(w, r, trans, loginUser)
+		return err
+	}
+}
+
+// LoginTwoFactorSetup returns a new secret for a login challenge of a
+// user who must enroll in two-factor authentication before logging in.
+func LoginTwoFactorSetup() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Challenge string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		challenge, err := acct.LoginChallengeFor(form.Challenge)
+		if err != nil {
+			return err
+		}
+		if !challenge.SetupRequired {
+			err := fmt.Errorf("handler: two-factor authentication already enabled")
+			return httperr.New(http.StatusBadRequest, "Two-factor authentication is already enabled", err)
+		}
+		loginUser, err := findActiveUser(db, challenge.UserID)
+		if err != nil {
+			return err
+		}
+		setup, err := loginUser.SetupTwoFactor(db)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(setup)
+	}
+}
+
+// SetupTwoFactor returns a new secret for the current user to enroll in
+// two-factor authentication with.
+func SetupTwoFactor() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		user, err := twoFactorSelf(r, db)
+		if err != nil {
+			return err
+		}
+		setup, err := user.SetupTwoFactor(db)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(setup)
+	}
+}
+
+// VerifyTwoFactor enables two-factor authentication for the current user
+// once the code of the pending secret is valid.  The recovery codes are
+// returned with the user and are never shown again.
+func VerifyTwoFactor() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Code string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		user, err := twoFactorSelf(r, db)
+		if err != nil {
+			return err
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		if user.RecoveryCodes, err = user.EnableTwoFactor(trans, form.Code); err != nil {
+			return err
+		}
+		if err = user.Expand(trans, ""); err != nil {
+			return httperr.New(http.StatusBadRequest, "error in expanding user", err)
+		}
+		return json.NewEncoder(w).Encode(user)
+	}
+}
+
+// DisableTwoFactor disables two-factor authentication.  Users disabling it
+// for themselves must supply a valid code and can't do so while their
+// group requires it; admins may disable it for anyone else without a code.
+func DisableTwoFactor() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Code string
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		params := mux.Vars(r)
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		user, err := findOrgUser(r, db, params["id"])
+		if err != nil {
+			return err
+		}
+		trans, err := db.Begin()
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		defer func() {
+			if err != nil {
+				trans.Rollback()
+			} else {
+				trans.Commit()
+			}
+		}()
+		if err = authorizeAction(trans, currentUser, acct.ActionDisableTwoFactor, acct.TableNameUser, user); err != nil {
+			return err
+		}
+		if currentUser.ID == user.ID {
+			var required, ok bool
+			if required, err = acct.GroupRequiresTwoFactor(trans, user.GroupID); err != nil {
+				return err
+			}
+			if required {
+				err = fmt.Errorf("handler: two-factor authentication required by group")
+				return httperr.New(http.StatusForbidden, "Two-factor authentication is required for your group", err)
+			}
+			if ok, err = user.VerifyTwoFactor(trans, form.Code); err != nil {
+				return err
+			}
+			if !ok {
+				err = acct.ErrInvalidTwoFactorCode
+				return err
+			}
+		}
+		if err = user.DisableTwoFactor(trans); err != nil {
+			return err
+		}
+		if err = user.Expand(trans, ""); err != nil {
+			return httperr.New(http.StatusBadRequest, "error in expanding user", err)
+		}
+		return json.NewEncoder(w).Encode(user)
+	}
+}
+
+// RequireGroupTwoFactor lets super admins require two-factor authentication for
+// every member of a group.
+func RequireGroupTwoFactor() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		type Form struct {
+			Required bool
+		}
+		form := &Form{}
+		if err := json.NewDecoder(r.Body).Decode(form); err != nil {
+			return httperr.New(http.StatusBadRequest, "invalid request", err)
+		}
+		if err := requireSuperAdmin(r, "require two-factor"); err != nil {
+			return err
+		}
+		params := mux.Vars(r)
+		groupID, err := strconv.ParseInt(params["id"], 10, 64)
+		if err != nil || acct.GroupForID(groupID) == nil {
+			err = fmt.Errorf("handler: must specify group")
+			return httperr.New(http.StatusBadRequest, "invalid group", err)
+		}
+		if err := acct.SetGroupRequiresTwoFactor(db, groupID, form.Required); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(acct.GroupSetting{GroupID: groupID, RequireTwoFactor: form.Required})
+	}
+}
+
+// twoFactorSelf returns the user of the id route parameter, who must be
+// the current user since enrollment is personal.
+func twoFactorSelf(r *http.Request, s gorp.SqlExecutor) (*acct.User, error) {
+	params := mux.Vars(r)
+	currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+	if strconv.FormatInt(currentUser.ID, 10) != params["id"] {
+		err := fmt.Errorf("handler: two-factor enrollment for another user")
+		return nil, httperr.New(http.StatusForbidden, "user do not have permission to perform request", err)
+	}
+	return findActiveUser(s, currentUser.ID)
+}
+
+func findActiveUser(s gorp.SqlExecutor, id interface{}) (*acct.User, error) {
+	user := &acct.User{}
+	query, args, _ := squirrel.Select("*").
+		From(acct.TableNameUser).
+		Where(squirrel.Eq{"ID": id, "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err != nil {
+		return nil, httperr.New(http.StatusBadRequest, "user not active in system", err)
+	}
+	return user, nil
+}
+
+// findOrgUser returns the active user with the id if the user belongs to
+// the organization of the current user.
+func findOrgUser(r *http.Request, s gorp.SqlExecutor, id interface{}) (*acct.User, error) {
+	user, err := findActiveUser(s, id)
+	if err != nil {
+		return nil, err
+	}
+	currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+	if !currentUser.InOrganization(user.OrgID) {
+		err := fmt.Errorf("handler: user %d is in another organization", user.ID)
+		return nil, httperr.New(http.StatusBadRequest, "user not active in system", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/mail"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFields(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	recorder := &mail.Recorder{}
+	mail.SetDefault(recorder)
+	defer mail.SetDefault(nil)
+
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/users", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetAll(&acct.User{})}).Methods("GET")
+	r.Handle("/users/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.GetByID(&acct.User{})}).Methods("GET")
+	r.Handle("/users/{id}", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.UpdateByID(&acct.User{})}).Methods("PUT")
+	r.Handle("/teams/{id}/members", &testhelpers.TestHandler{T: t, Db: db, Handler: mware.SessionOnly(ListTeamMembers())}).Methods("GET")
+	do := apiClient(t, db, r)
+	attributes := func(user *acct.User) map[int64]map[string]interface{} {
+		rec := do(user, "GET", testUsersUrl, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var users []map[string]interface{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&users))
+		byID := map[int64]map[string]interface{}{}
+		for _, u := range users {
+			byID[int64(u["id"].(float64))] = u
+		}
+		return byID
+	}
+
+	admin, respCode := loginRequest(t, mainRouter, db, "rahul.agrawal@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	manager, respCode := loginRequest(t, mainRouter, db, "rahul.yadav@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+	regular, respCode := loginRequest(t, mainRouter, db, "ritik.rishu@hotcocoasoftware.com", "i am rahul")
+	assert.Equal(t, http.StatusOK, respCode)
+
+	// case 1: managers don't see the security settings of their users
+	users := attributes(manager)
+	assert.Contains(t, users[manager.ID], "emailVerified")
+	assert.Contains(t, users[regular.ID], "email")
+	for _, field := range []string{"emailVerified", "twoFactorEnabled", "superAdmin"} {
+		assert.NotContains(t, users[regular.ID], field)
+	}
+	assert.Contains(t, attributes(admin)[regular.ID], "emailVerified")
+
+	// case 2: managers set the daily calories of their users
+	rec := do(manager, "PUT", fmt.Sprintf(testUserUrl, regular.ID), map[string]interface{}{
+		"name": regular.Name, "email": regular.Email, "expectedCaloriesPerDay": 1800,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	updated := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(updated))
+	assert.Equal(t, int64(1800), updated.ExpectedCaloriesPerDay)
+
+	// case 3: but not their email addresses or passwords
+	rec = do(manager, "PUT", fmt.Sprintf(testUserUrl, regular.ID), map[string]interface{}{
+		"name": regular.Name, "email": "taken@gmail.com", "password": "another password", "expectedCaloriesPerDay": 1800,
+	})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	resp := &struct{ Details []string }{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+	assert.Equal(t, []string{"email", "password"}, resp.Details)
+
+	// case 4: changed email addresses are verified again
+	rec = do(admin, "PUT", fmt.Sprintf(testUserUrl, regular.ID), map[string]interface{}{
+		"name": regular.Name, "email": "Ritik@Gmail.com", "expectedCaloriesPerDay": 1800,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(updated))
+	assert.Equal(t, "ritik@gmail.com", updated.Email)
+	assert.False(t, updated.EmailVerified)
+	message, ok := recorder.Last("ritik@gmail.com")
+	assert.True(t, ok)
+	assert.Regexp(t, verificationLink, message.Body)
+	// nor does a failing mailer fail the change
+	mail.SetDefault(failingMailer{})
+	rec = do(admin, "PUT", fmt.Sprintf(testUserUrl, regular.ID), map[string]interface{}{
+		"name": regular.Name, "email": "ritik.rishu@gmail.com", "expectedCaloriesPerDay": 1800,
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	mail.SetDefault(recorder)
+
+	// case 5: the users nested in other records are restricted alike
+	rec = do(manager, "GET", fmt.Sprintf(testTeamMembersUrl, 1), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var members []struct{ User map[string]interface{} }
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&members))
+	if assert.Len(t, members, 2) {
+		assert.Contains(t, members[0].User, "twoFactorEnabled")
+		assert.NotContains(t, members[1].User, "twoFactorEnabled")
+		assert.Contains(t, members[1].User, "email")
+	}
+
+	// case 6: nor can users be filtered or sorted by the fields
+	for _, query := range []url.Values{
+		{"twoFactorEnabled": {"true"}},
+		{"q-filter": {"emailVerified eq false"}},
+		{"q-order": {"asc-superAdmin"}},
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(manager, "GET", testUsersUrl+"?"+query.Encode(), nil).Code, query)
+		assert.Equal(t, http.StatusOK, do(admin, "GET", testUsersUrl+"?"+query.Encode(), nil).Code, query)
+	}
+}
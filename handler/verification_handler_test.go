@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/rahul2393/small-assignment-server/mail"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/negroni"
+)
+
+const (
+	testResendVerificationUrl = `http://localhost:8000/api/verify-email/resend`
+)
+
+var verificationLink = regexp.MustCompile(`/verify-email\?token=(\S+)`)
+
+func TestVerifyEmail(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	recorder := &mail.Recorder{}
+	mail.SetDefault(recorder)
+	defer mail.SetDefault(nil)
+
+	mainRouter := mux.NewRouter().StrictSlash(true)
+	mainRouter.Handle("/signup", &testhelpers.TestHandler{T: t, Db: db, Handler: SignUp()}).Methods("POST")
+	mainRouter.Handle("/verify-email", &testhelpers.TestHandler{T: t, Db: db, Handler: VerifyEmail()}).Methods("GET")
+	r := mainRouter.PathPrefix("/api").Subrouter()
+	r.Handle("/verify-email/resend", &testhelpers.TestHandler{T: t, Db: db, Handler: ResendVerificationEmail()}).Methods("POST")
+	middleware := negroni.New(
+		mware.TestUserAuth(db),
+		negroni.Wrap(r),
+	)
+	resend := func(user *acct.User) int {
+		req, err := http.NewRequest("POST", testResendVerificationUrl, nil)
+		assert.NoError(t, err)
+		setAuth(req, user)
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// case 1: signing up sends a verification link
+	payload, err := json.Marshal(map[string]string{"Email": "test@gmail.com", "Name": "test", "Password": "test password"})
+	assert.NoError(t, err)
+	req, err := http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	user := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(user))
+	assert.False(t, user.EmailVerified)
+	message, ok := recorder.Last("test@gmail.com")
+	assert.True(t, ok)
+	match := verificationLink.FindStringSubmatch(message.Body)
+	assert.Len(t, match, 2)
+
+	// case 2: resending is rate limited
+	assert.Equal(t, http.StatusOK, resend(user))
+	assert.Equal(t, http.StatusTooManyRequests, resend(user))
+
+	// case 3: a tampered link is rejected
+	req, err = http.NewRequest("GET", "http://localhost:8000/verify-email?token=forged."+match[1], nil)
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// case 4: the link verifies the email
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+	req, err = http.NewRequest("GET", "http://localhost:8000/verify-email?token="+url.QueryEscape(token), nil)
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	verified := &acct.User{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(verified))
+	assert.True(t, verified.EmailVerified)
+
+	// case 5: nothing is mailed for a sign up that fails
+	count := len(recorder.Messages())
+	req, err = http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+	assert.Len(t, recorder.Messages(), count)
+
+	// case 6: a failing mailer doesn't fail the sign up, the email can be
+	// sent again
+	mail.SetDefault(failingMailer{})
+	payload, err = json.Marshal(map[string]string{"Email": "unmailed@gmail.com", "Name": "test", "Password": "test password"})
+	assert.NoError(t, err)
+	req, err = http.NewRequest("POST", testSignUpUrl, bytes.NewReader(payload))
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	mainRouter.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// failingMailer fails to send every message.
+type failingMailer struct{}
+
+func (failingMailer) Send(mail.Message) error {
+	return errors.New("mail: server unavailable")
+}
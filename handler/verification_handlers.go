@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/mware"
+)
+
+// VerifyEmail confirms the email address of the signed link's token.
+func VerifyEmail() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		user, err := acct.VerifyEmail(db, r.URL.Query().Get("token"))
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(user)
+	}
+}
+
+// ResendVerificationEmail sends the current user another verification link.
+func ResendVerificationEmail() mware.Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		currentUser := acct.GetCurrentRequestUserFromCache(r.Header.Get("X-Request-Id"))
+		user, err := findActiveUser(db, currentUser.ID)
+		if err != nil {
+			return err
+		}
+		return user.ResendVerificationEmail()
+	}
+}
@@ -22,6 +22,9 @@ type Error struct {
 	Message string `json:"message"`
 	// Err is a text representation of the error
 	Err string `json:"error"`
+	// Details optionally lists what went wrong, such as the rules a
+	// password violated
+	Details interface{} `json:"details,omitempty"`
 }
 
 // New creates an Error with the given code, message, and err.
@@ -33,6 +36,13 @@ func New(code int, message string, err error) Error {
 	}
 }
 
+// NewWithDetails creates an Error with the given code, message, err, and details.
+func NewWithDetails(code int, message string, err error, details interface{}) Error {
+	e := New(code, message, err)
+	e.Details = details
+	return e
+}
+
 // NewInternal creates an Error with a 500 http status and default user message.
 func NewInternal(err error) Error {
 	if err == nil {
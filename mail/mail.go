@@ -0,0 +1,174 @@
+// Package mail sends emails to users through a pluggable Mailer.
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/logger"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(m Message) error
+}
+
+var (
+	mu      sync.RWMutex
+	current Mailer
+)
+
+// Default returns the mailer configured in the mail section of conf.toml
+// unless it was replaced with SetDefault.
+func Default() Mailer {
+	mu.RLock()
+	m := current
+	mu.RUnlock()
+	if m != nil {
+		return m
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		current = fromConfig(conf.Get().Mail)
+	}
+	return current
+}
+
+// SetDefault replaces the mailer returned by Default.
+func SetDefault(m Mailer) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = m
+}
+
+// Send sends the message with the default mailer.
+func Send(m Message) error {
+	return Default().Send(m)
+}
+
+func fromConfig(cfg conf.Mail) Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return &SMTPMailer{
+			Addr:     cfg.SMTPAddr,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	default:
+		return &LogMailer{Path: cfg.LogPath, From: cfg.From}
+	}
+}
+
+// SMTPMailer sends messages through an SMTP server using PLAIN auth.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send implements the Mailer interface.
+func (s *SMTPMailer) Send(m Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return errors.Wrap(err, "mail: invalid smtp address")
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, format(s.From, m)); err != nil {
+		return errors.Wrap(err, "mail: error in sending email")
+	}
+	return nil
+}
+
+// LogMailer writes messages to a file instead of sending them, which is
+// handy for local development.  Messages are written to the server log
+// when Path is empty.
+type LogMailer struct {
+	Path string
+	From string
+
+	mu sync.Mutex
+}
+
+// Send implements the Mailer interface.
+func (l *LogMailer) Send(m Message) error {
+	if l.Path == "" {
+		logger.Info(fmt.Sprintf("mail to %s: %s\n%s", m.To, m.Subject, m.Body))
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "mail: error in opening mail log")
+	}
+	defer f.Close()
+	if _, err := f.Write(append(format(l.From, m), '\n')); err != nil {
+		return errors.Wrap(err, "mail: error in writing mail log")
+	}
+	return nil
+}
+
+// Recorder keeps sent messages in memory so tests can inspect them.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send implements the Mailer interface.
+func (r *Recorder) Send(m Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Messages returns the messages sent so far.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.messages...)
+}
+
+// Last returns the last message sent to the address.
+func (r *Recorder) Last(to string) (Message, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.messages) - 1; i >= 0; i-- {
+		if r.messages[i].To == to {
+			return r.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+func format(from string, m Message) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + m.To,
+		"Subject: " + m.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + m.Body + "\r\n")
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &LogMailer{Path: filepath.Join(dir, "mail.log"), From: "no-reply@test.com"}
+	assert.NoError(t, m.Send(Message{To: "test@gmail.com", Subject: "Hello", Body: "World"}))
+
+	b, err := ioutil.ReadFile(m.Path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "To: test@gmail.com")
+	assert.Contains(t, string(b), "Subject: Hello")
+	assert.Contains(t, string(b), "World")
+}
+
+func TestRecorder(t *testing.T) {
+	r := &Recorder{}
+	SetDefault(r)
+	defer SetDefault(nil)
+
+	assert.NoError(t, Send(Message{To: "a@gmail.com", Subject: "1"}))
+	assert.NoError(t, Send(Message{To: "b@gmail.com", Subject: "2"}))
+	assert.NoError(t, Send(Message{To: "a@gmail.com", Subject: "3"}))
+
+	assert.Len(t, r.Messages(), 3)
+	m, ok := r.Last("a@gmail.com")
+	assert.True(t, ok)
+	assert.Equal(t, "3", m.Subject)
+	_, ok = r.Last("c@gmail.com")
+	assert.False(t, ok)
+}
@@ -7,6 +7,9 @@ import (
 
 	"gopkg.in/BurntSushi/toml.v0"
 
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/dbutil"
+	"github.com/rahul2393/small-assignment-server/models/acct"
 	"github.com/rahul2393/small-assignment-server/router"
 )
 
@@ -15,7 +18,15 @@ const (
 )
 
 func main() {
+	if err := conf.Get().Check(); err != nil {
+		log.Fatal(err)
+	}
 	handler := router.SetupRouters()
+	db, err := dbutil.DB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go acct.SweepGroupMemberships(db, conf.Get().Auth.GroupSweepInterval(), nil)
 	fmt.Println("Starting https server")
 	type configFile struct {
 		CertPath string `toml:"cert_path"`
@@ -26,7 +37,7 @@ func main() {
 		fmt.Printf("errors is %v\n", err)
 	}
 	go http.ListenAndServeTLS(":443", cfg.CertPath, cfg.KeyPath, handler)
-	err := http.ListenAndServe(":"+port, handler)
+	err = http.ListenAndServe(":"+port, handler)
 	if err != nil {
 		log.Fatal(err)
 	}
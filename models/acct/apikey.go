@@ -0,0 +1,330 @@
+package acct
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ShaleApps/gator"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/logger"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// TableNameAPIKey is the name of the api key sql table.
+	TableNameAPIKey = "api_keys"
+	// CacheKeyAPIKey is the cache namespace of authenticated api keys.
+	CacheKeyAPIKey = "api-key"
+
+	cacheKeyAPIKeyUsed = "api-key-used"
+	// apiKeyCacheDuration bounds how long a revoked key may still be
+	// accepted by another server.
+	apiKeyCacheDuration = 5 * time.Minute
+)
+
+// APIKey is a long-lived credential for services acting on behalf of its
+// owner.  A key is limited to its scopes, which are permission names, and
+// never grants more than the owner's group does.
+type APIKey struct {
+	basemodel.BaseModel
+
+	UserID int64  `json:"userID" gator:"nonzero"`
+	Name   string `json:"name" gator:"nonzero"`
+	// Key is the "value:id" secret, it is only returned on creation.
+	Key   string `db:"-" json:"key,omitempty"`
+	Value string `db:"-" json:"-"`
+	Hash  string `json:"-" gator:"nonzero"`
+	// Expiration is optional, zero means the key doesn't expire.
+	Expiration int64 `json:"expiration"`
+	LastUsed   int64 `json:"lastUsed"`
+
+	Scopes     []string `db:"-" json:"scopes"`
+	AllowedIPs []string `db:"-" json:"allowedIPs"`
+	// ScopeList and AllowedIPList are the comma separated columns of
+	// Scopes and AllowedIPs.
+	ScopeList     string `json:"-"`
+	AllowedIPList string `json:"-"`
+}
+
+func (k *APIKey) TableName() string {
+	return TableNameAPIKey
+}
+
+func (k *APIKey) PreInsert(s gorp.SqlExecutor) error {
+	k.Created = milli.Timestamp(time.Now())
+	k.Updated = milli.Timestamp(time.Now())
+	value, hash, err := newSecret()
+	if err != nil {
+		return err
+	}
+	k.Value = value
+	k.Hash = hash
+	k.ScopeList = strings.Join(k.Scopes, ",")
+	k.AllowedIPList = strings.Join(k.AllowedIPs, ",")
+	if err := gator.NewStruct(k).Validate(); err != nil {
+		return errors.Wrap(err, "error in validating api key")
+	}
+	return nil
+}
+
+// PostInsert implements the gorp.HasPostInsert interface.
+func (k *APIKey) PostInsert(s gorp.SqlExecutor) error {
+	k.Key = fmt.Sprintf("%s:%d", k.Value, k.ID)
+	return nil
+}
+
+func (k *APIKey) PreUpdate(s gorp.SqlExecutor) error {
+	k.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+// PostGet implements the gorp.HasPostGet interface.
+func (k *APIKey) PostGet(s gorp.SqlExecutor) error {
+	k.Scopes = splitList(k.ScopeList)
+	k.AllowedIPs = splitList(k.AllowedIPList)
+	return nil
+}
+
+// Expired returns whether or not the api key is expired.
+func (k *APIKey) Expired() bool {
+	return k.Expiration != 0 && time.Now().After(milli.Time(k.Expiration))
+}
+
+// AllowsIP returns whether or not the key may be used from the ip.  Keys
+// without an allowlist may be used from anywhere.
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, allowed := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if addr.Equal(net.ParseIP(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the scopes against the owner's group and the allowlist
+// entries before the key is created.
+func (k *APIKey) Validate(owner *User) error {
+	invalid := func(message string) error {
+		return httperr.New(http.StatusBadRequest, message, errors.New("acct: "+message))
+	}
+	if strings.TrimSpace(k.Name) == "" {
+		return invalid("API key requires a name")
+	}
+	if len(k.Scopes) == 0 {
+		return invalid("API key requires at least one scope")
+	}
+	group := GroupForID(owner.GroupID)
+	for _, name := range k.Scopes {
+		p := PermissionForName(name)
+		if p == NoPerm {
+			return invalid(fmt.Sprintf("unknown scope %s", name))
+		}
+		if group == nil || !group.grants(p) {
+			return invalid(fmt.Sprintf("scope %s exceeds your permissions", name))
+		}
+	}
+	for _, ip := range k.AllowedIPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			return invalid(fmt.Sprintf("invalid IP address %s", ip))
+		}
+	}
+	if k.Expiration != 0 && k.Expired() {
+		return invalid("API key expiration must be in the future")
+	}
+	return nil
+}
+
+// grants returns whether or not the group has the permission or the
+// permission to every row of the same table and level.
+func (group *Group) grants(p Permission) bool {
+	for _, perm := range group.Permissions {
+		if perm == p || (perm.IsAll && perm.TableName == p.TableName && perm.level == p.level) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopedGroup returns a group with the permissions of both the group and
+// the scopes.  The group has no id, so checks for a specific group, such
+// as admin only actions, always fail for api keys.
+func scopedGroup(group *Group, scopes []string) *Group {
+	scoped := &Group{Name: group.Name, Permissions: []Permission{}}
+	for _, name := range scopes {
+		if p := PermissionForName(name); p != NoPerm && group.grants(p) {
+			scoped.Permissions = append(scoped.Permissions, p)
+		}
+	}
+	return scoped
+}
+
+// apiKeySession is a cached, authenticated api key.
+type apiKeySession struct {
+	key  *APIKey
+	user *User
+}
+
+// AuthenticateAPIKey returns the owner of the api key limited to the
+// key's scopes.  The returned user's APIKeyID is set.
+func AuthenticateAPIKey(s gorp.SqlExecutor, key, ip string) (*User, error) {
+	if src, in := cache.Get(CacheKeyAPIKey, key); in {
+		session := src.(*apiKeySession)
+		if session.key.Expired() || !session.key.AllowsIP(ip) {
+			return nil, errInvalidAuth(errors.New("acct: api key expired or used from another ip"))
+		}
+		touchAPIKey(s, session.key.ID)
+		return session.user, nil
+	}
+	value, id, err := SplitToken(key)
+	if err != nil {
+		return nil, errInvalidAuth(err)
+	}
+	k := &APIKey{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameAPIKey).
+		Where(squirrel.Eq{"ID": id, "Deleted": false}).ToSql()
+	if err := s.SelectOne(k, query, args...); err != nil {
+		return nil, errInvalidAuth(err)
+	}
+	if !validPassword(value, k.Hash) {
+		return nil, errInvalidAuth(errors.New("acct: api key's value is incorrect"))
+	}
+	if k.Expired() {
+		return nil, errInvalidAuth(errors.New("acct: api key is expired"))
+	}
+	if !k.AllowsIP(ip) {
+		return nil, errInvalidAuth(errors.New("acct: api key used from ip " + ip))
+	}
+	owner := &User{}
+	query, args, _ = squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": k.UserID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(owner, query, args...); err != nil {
+		return nil, errInvalidAuth(err)
+	}
+	if err := owner.Expand(s, ""); err != nil {
+		return nil, errors.Wrap(err, "acct: error in expanding user")
+	}
+	if owner.Group == nil {
+		return nil, errInvalidAuth(errors.New("acct: api key owner has no group"))
+	}
+	user := *owner
+	user.Group = scopedGroup(owner.Group, k.Scopes)
+	user.APIKeyID = k.ID
+	cache.Set(CacheKeyAPIKey, key, cache.Item{Src: &apiKeySession{key: k, user: &user}, Duration: apiKeyCacheDuration})
+	touchAPIKey(s, k.ID)
+	return &user, nil
+}
+
+// APIKeys returns the user's api keys that aren't revoked.
+func APIKeys(s gorp.SqlExecutor, userID int64) ([]*APIKey, error) {
+	var keys []*APIKey
+	query, args, _ := squirrel.Select("*").
+		From(TableNameAPIKey).
+		Where(squirrel.Eq{"UserID": userID, "Deleted": false}).
+		OrderBy("ID").ToSql()
+	if _, err := s.Select(&keys, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding api keys")
+	}
+	return keys, nil
+}
+
+// APIKeyForID returns the api key with the id the user may revoke.  Keys
+// the user may not revoke aren't found.
+func APIKeyForID(s gorp.SqlExecutor, user *User, keyID int64) (*APIKey, error) {
+	k := &APIKey{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameAPIKey).
+		Where(squirrel.Eq{"ID": keyID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(k, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "API key")
+	}
+	allowed, err := policy.Allowed(s, user, policy.Write, TableNameAPIKey, k)
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in authorizing api key")
+	}
+	if !allowed {
+		return nil, httperr.NewNotFound(errors.Errorf("acct: user %d may not revoke api key %d", user.ID, k.ID), "API key")
+	}
+	return k, nil
+}
+
+// RevokeAPIKey revokes the api key.  Users writing every user may revoke
+// any key, other users only their own.
+func RevokeAPIKey(s gorp.SqlExecutor, user *User, keyID int64) (*APIKey, error) {
+	k, err := APIKeyForID(s, user, keyID)
+	if err != nil {
+		return nil, err
+	}
+	k.Deleted = true
+	if _, err := s.Update(k); err != nil {
+		return nil, errors.Wrap(err, "acct: error in revoking api key")
+	}
+	deleteCachedAPIKeys(func(session *apiKeySession) bool { return session.key.ID == k.ID })
+	return k, nil
+}
+
+// deleteCachedAPIKeys drops the cached api keys matching the predicate.
+func deleteCachedAPIKeys(match func(*apiKeySession) bool) {
+	for key, src := range cache.Items(CacheKeyAPIKey) {
+		if session, ok := src.(*apiKeySession); ok && match(session) {
+			cache.Delete(CacheKeyAPIKey, key)
+		}
+	}
+}
+
+// touchAPIKey records that the key authenticated a request.  Writes are
+// throttled to one per lastUsedInterval for each key.
+func touchAPIKey(s gorp.SqlExecutor, keyID int64) {
+	id := strconv.FormatInt(keyID, 10)
+	if _, in := cache.Get(cacheKeyAPIKeyUsed, id); in {
+		return
+	}
+	if _, err := s.Exec("update "+TableNameAPIKey+" set LastUsed = ? where ID = ?",
+		milli.Timestamp(time.Now()), keyID); err != nil {
+		logger.ErrorWithMsg("acct: error in updating api key last used", err)
+		return
+	}
+	cache.Set(cacheKeyAPIKeyUsed, id, cache.Item{Src: true, Duration: lastUsedInterval})
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
+
+func init() {
+	policy.Register(TableNameAPIKey,
+		policy.Rule{Action: policy.Write, Subject: policy.Anyone(), Resource: policy.Owner("UserID", func(record interface{}) int64 {
+			if k, ok := record.(*APIKey); ok {
+				return k.UserID
+			}
+			return 0
+		})},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(WriteAllUsers.Name)},
+	)
+}
@@ -0,0 +1,218 @@
+package acct
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// ModelNameAuditEntry is the name of the audit entry model.
+	ModelNameAuditEntry = "AuditEntry"
+	// TableNameAuditEntry is the name of the audit log sql table.
+	TableNameAuditEntry = "audit_log"
+
+	// AuditCreate, AuditUpdate and AuditDelete are the actions of the
+	// crud handlers.  The account handlers record their policy action.
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+
+	// redacted replaces the values of secret attributes.
+	redacted = "[redacted]"
+)
+
+// secretAttributes are the parts of the names of the attributes whose
+// values are never recorded.
+var secretAttributes = []string{"password", "hash", "secret", "token", "recoverycode"}
+
+// AuditEntry records a change to a record: who made it, in which request,
+// and the attributes it changed.
+type AuditEntry struct {
+	basemodel.BaseModel
+
+	ActorID int64 `json:"actorID"`
+	// ImpersonatorID is the admin acting as the actor, if any.
+	ImpersonatorID int64 `json:"impersonatorID,omitempty"`
+	// OrgID is the organization of the actor.
+	OrgID     int64     `json:"orgID"`
+	RequestID string    `json:"requestID"`
+	Action    string    `json:"action"`
+	Model     string    `json:"model"`
+	RecordID  int64     `json:"recordID"`
+	Diff      AuditDiff `json:"diff"`
+}
+
+// AuditDiff is the JSON object of the changed attributes, each with the
+// value it changed from and to.
+type AuditDiff string
+
+// AuditChange is the change of an attribute.
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// MarshalJSON embeds the diff as an object.
+func (d AuditDiff) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("{}"), nil
+	}
+	return []byte(d), nil
+}
+
+// UnmarshalJSON reads the diff object.
+func (d *AuditDiff) UnmarshalJSON(b []byte) error {
+	*d = AuditDiff(b)
+	return nil
+}
+
+// Changes returns the changed attributes.
+func (d AuditDiff) Changes() (map[string]AuditChange, error) {
+	changes := map[string]AuditChange{}
+	if d == "" {
+		return changes, nil
+	}
+	if err := json.Unmarshal([]byte(d), &changes); err != nil {
+		return nil, errors.Wrap(err, "acct: error in decoding audit diff")
+	}
+	return changes, nil
+}
+
+func (a *AuditEntry) TableName() string {
+	return TableNameAuditEntry
+}
+
+func (a *AuditEntry) PreInsert(s gorp.SqlExecutor) error {
+	a.Created = milli.Timestamp(time.Now())
+	a.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+// Delete refuses to delete entries, the audit log is append only.
+func (a *AuditEntry) Delete(s gorp.SqlExecutor) error {
+	return httperr.New(http.StatusForbidden, "audit entries can't be deleted",
+		errors.New("acct: deleting audit entry"))
+}
+
+func (a *AuditEntry) Expand(s gorp.SqlExecutor, exclude string) error {
+	a.ModelName = ModelNameAuditEntry
+	return nil
+}
+
+// Snapshot returns the attributes of the record before it is changed, to
+// be passed to Audit once it is.
+func Snapshot(record interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in encoding audited record")
+	}
+	return b, nil
+}
+
+// Audit records that the actor performed the action on the record of the
+// model in the request.  Before and after are the record, or the audited
+// attributes, before and after the change; nil for records being created
+// or removed.  Values of secret attributes are redacted.
+func Audit(s gorp.SqlExecutor, actor *User, requestID, action, model string, recordID int64, before, after interface{}) error {
+	from, err := auditAttributes(before)
+	if err != nil {
+		return err
+	}
+	to, err := auditAttributes(after)
+	if err != nil {
+		return err
+	}
+	diff, err := json.Marshal(auditDiff(from, to))
+	if err != nil {
+		return errors.Wrap(err, "acct: error in encoding audit diff")
+	}
+	entry := &AuditEntry{
+		ActorID:   actor.ID,
+		OrgID:     actor.OrgID,
+		RequestID: requestID,
+		Action:    action,
+		Model:     model,
+		RecordID:  recordID,
+		Diff:      AuditDiff(diff),
+	}
+	if actor.Impersonator != nil {
+		entry.ImpersonatorID = actor.Impersonator.ID
+	}
+	if err := s.Insert(entry); err != nil {
+		return errors.Wrap(err, "acct: error in inserting audit entry")
+	}
+	return nil
+}
+
+// auditAttributes returns the JSON attributes of the record.
+func auditAttributes(record interface{}) (map[string]interface{}, error) {
+	attrs := map[string]interface{}{}
+	if record == nil || reflect.ValueOf(record).Kind() == reflect.Ptr && reflect.ValueOf(record).IsNil() {
+		return attrs, nil
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in encoding audited record")
+	}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, errors.Wrap(err, "acct: error in decoding audited record")
+	}
+	return attrs, nil
+}
+
+// auditDiff returns the attributes whose values differ.  The update time
+// changes with every change and isn't recorded.
+func auditDiff(from, to map[string]interface{}) map[string]AuditChange {
+	diff := map[string]AuditChange{}
+	for _, attrs := range []map[string]interface{}{from, to} {
+		for name := range attrs {
+			if _, ok := diff[name]; ok || name == "updated" || reflect.DeepEqual(from[name], to[name]) {
+				continue
+			}
+			change := AuditChange{From: from[name], To: to[name]}
+			if secret(name) && change.From != nil {
+				change.From = redacted
+			}
+			if secret(name) && change.To != nil {
+				change.To = redacted
+			}
+			diff[name] = change
+		}
+	}
+	return diff
+}
+
+// secret returns whether or not the value of the attribute with the name
+// must not be recorded.
+func secret(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretAttributes {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	policy.Scope(TableNameAuditEntry, policy.Tenant("OrgID", func(s gorp.SqlExecutor, record interface{}) (int64, error) {
+		if a, ok := record.(*AuditEntry); ok {
+			return a.OrgID, nil
+		}
+		return 0, nil
+	}))
+	policy.Register(TableNameAuditEntry,
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadAuditLog.Name)},
+	)
+}
@@ -0,0 +1,77 @@
+package acct
+
+import (
+	"net/http"
+	"strings"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/rahul2393/small-assignment-server/conf"
+)
+
+const (
+	// AuthKeyEmail is the legacy query string key for the user's email.
+	AuthKeyEmail = "auth-email"
+	// AuthKeyToken is the legacy query string key for the user's token.
+	AuthKeyToken = "auth-token"
+	// HeaderAuthEmail is the optional header carrying the user's email.
+	HeaderAuthEmail = "X-Auth-Email"
+	// HeaderAuthorization is the header carrying the bearer token.
+	HeaderAuthorization = "Authorization"
+
+	bearerScheme = "bearer"
+	apiKeyScheme = "apikey"
+)
+
+// Credentials are the authentication details supplied with a request.
+type Credentials struct {
+	// Email is optional; when present it must match the token's owner.
+	Email string
+	// Token is the access token returned from login.
+	Token string
+	// APIKey is sent instead of Token by services using an api key.
+	APIKey string
+}
+
+// CredentialsFromRequest extracts the credentials from the Authorization
+// and X-Auth-Email headers.  Api keys use the ApiKey scheme instead of
+// Bearer.  The auth-email and auth-token query string
+// parameters are only consulted when allow_query_token is configured
+// and the Authorization header is absent.
+func CredentialsFromRequest(r *http.Request) Credentials {
+	creds := Credentials{
+		Email:  r.Header.Get(HeaderAuthEmail),
+		Token:  authorization(r.Header.Get(HeaderAuthorization), bearerScheme),
+		APIKey: authorization(r.Header.Get(HeaderAuthorization), apiKeyScheme),
+	}
+	if creds.Token == "" && creds.APIKey == "" && conf.Get().Auth.AllowQueryToken {
+		v := r.URL.Query()
+		creds.Token = v.Get(AuthKeyToken)
+		if creds.Email == "" {
+			creds.Email = v.Get(AuthKeyEmail)
+		}
+	}
+	creds.Email = strings.ToLower(strings.TrimSpace(creds.Email))
+	return creds
+}
+
+// authorization returns the credentials of the Authorization header if
+// it uses the scheme.
+func authorization(header, scheme string) string {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != scheme {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// AuthenticateRequest authenticates the user for the credentials
+// supplied with the request.  Api keys are checked against the remote
+// address of the request.
+func AuthenticateRequest(s gorp.SqlExecutor, r *http.Request) (*User, error) {
+	creds := CredentialsFromRequest(r)
+	if creds.APIKey != "" {
+		return AuthenticateAPIKey(s, creds.APIKey, RemoteIP(r))
+	}
+	return Authenticate(s, creds.Email, creds.Token)
+}
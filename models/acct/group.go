@@ -0,0 +1,229 @@
+package acct
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/logger"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// TableNameGroup is the name of the group sql table.  groups is a
+	// reserved word in MySQL 8, so raw queries quote it.
+	TableNameGroup = "groups"
+	// TableNamePermission is the name of the permission sql table.
+	TableNamePermission = "permissions"
+	// TableNameGroupPermission is the name of the sql table joining groups
+	// and their permissions.
+	TableNameGroupPermission = "group_permissions"
+
+	// groupSyncInterval is how often the groups are reloaded to pick up
+	// changes made by other servers.
+	groupSyncInterval = time.Minute
+)
+
+// permissionRow is a row of the permission table, Permission doesn't
+// export its level.
+type permissionRow struct {
+	ID        int64
+	Name      string
+	TableName string
+	Level     int
+	IsAll     bool
+}
+
+// groupPermissionRow is a row of the group permission table.
+type groupPermissionRow struct {
+	GroupID      int64
+	PermissionID int64
+}
+
+var (
+	groupMu          sync.Mutex
+	groupDB          gorp.SqlExecutor
+	groupSynced      time.Time
+	groupCache       []Group
+	permissionsCache []Permission
+)
+
+// UseGroupStore makes Groups, GroupForID and PermissionForName read the
+// groups and permissions of the database.  Until it is called the
+// built-in groups are used.
+func UseGroupStore(s gorp.SqlExecutor) {
+	groupMu.Lock()
+	defer groupMu.Unlock()
+	groupDB = s
+	groupSynced = time.Time{}
+}
+
+// InvalidateGroups drops the cached groups so that they are reloaded upon
+// the next use.  It has to be called once changes to the groups are
+// committed.
+func InvalidateGroups() {
+	groupMu.Lock()
+	defer groupMu.Unlock()
+	groupSynced = time.Time{}
+}
+
+// groupStore returns the cached groups and permissions, reloading them
+// every groupSyncInterval.  If they can't be loaded the last ones loaded
+// are kept.
+func groupStore() ([]Group, []Permission) {
+	groupMu.Lock()
+	defer groupMu.Unlock()
+	if groupDB == nil {
+		return builtinGroups(), builtinPermissions()
+	}
+	if time.Since(groupSynced) >= groupSyncInterval {
+		groups, permissions, err := loadGroups(groupDB)
+		if err != nil {
+			logger.ErrorWithMsg("acct: groups not reloaded", err)
+		} else {
+			groupCache, permissionsCache = groups, permissions
+			groupSynced = time.Now()
+		}
+	}
+	if groupCache == nil {
+		return builtinGroups(), builtinPermissions()
+	}
+	return groupCache, permissionsCache
+}
+
+func loadGroups(s gorp.SqlExecutor) ([]Group, []Permission, error) {
+	var rows []*permissionRow
+	if _, err := s.Select(&rows, "select * from "+TableNamePermission+" order by ID"); err != nil {
+		return nil, nil, errors.Wrap(err, "acct: error in loading permissions")
+	}
+	permissions := make([]Permission, 0, len(rows))
+	byID := map[int64]Permission{}
+	for _, row := range rows {
+		p := Permission{ID: row.ID, Name: row.Name, TableName: row.TableName, level: row.Level, IsAll: row.IsAll}
+		permissions = append(permissions, p)
+		byID[p.ID] = p
+	}
+	var groups []Group
+	if _, err := s.Select(&groups, "select * from `"+TableNameGroup+"` order by ID"); err != nil {
+		return nil, nil, errors.Wrap(err, "acct: error in loading groups")
+	}
+	var grants []*groupPermissionRow
+	if _, err := s.Select(&grants, "select * from "+TableNameGroupPermission+" order by PermissionID"); err != nil {
+		return nil, nil, errors.Wrap(err, "acct: error in loading group permissions")
+	}
+	for i := range groups {
+		groups[i].Permissions = []Permission{}
+		for _, grant := range grants {
+			if p, ok := byID[grant.PermissionID]; ok && grant.GroupID == groups[i].ID {
+				groups[i].Permissions = append(groups[i].Permissions, p)
+			}
+		}
+	}
+	return groups, permissions, nil
+}
+
+// SaveGroup inserts the group, or updates it if it has an id, along with
+// its permissions.  Call InvalidateGroups once the changes are committed.
+func SaveGroup(s gorp.SqlExecutor, group *Group) error {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return httperr.NewBadRequest(errors.New("acct: group without name"), "group requires a name")
+	}
+	for i, p := range group.Permissions {
+		perm := PermissionForName(p.Name)
+		if perm == NoPerm {
+			message := fmt.Sprintf("unknown permission %s", p.Name)
+			return httperr.NewBadRequest(errors.New("acct: "+message), message)
+		}
+		group.Permissions[i] = perm
+	}
+	if taken, err := s.SelectInt("select count(*) from `"+TableNameGroup+"` where Name = ? and ID != ?",
+		group.Name, group.ID); err != nil {
+		return errors.Wrap(err, "acct: error in finding group")
+	} else if taken > 0 {
+		message := fmt.Sprintf("group %s already exists", group.Name)
+		return httperr.NewBadRequest(errors.New("acct: "+message), message)
+	}
+	if group.ID == 0 {
+		if err := s.Insert(group); err != nil {
+			return errors.Wrap(err, "acct: error in inserting group")
+		}
+	} else {
+		exists, err := s.SelectInt("select count(*) from `"+TableNameGroup+"` where ID = ?", group.ID)
+		if err != nil {
+			return errors.Wrap(err, "acct: error in finding group")
+		}
+		if exists == 0 {
+			return httperr.NewNotFound(errors.New("acct: group not found"), "group")
+		}
+		if _, err := s.Update(group); err != nil {
+			return errors.Wrap(err, "acct: error in updating group")
+		}
+	}
+	if _, err := s.Exec("delete from "+TableNameGroupPermission+" where GroupID = ?", group.ID); err != nil {
+		return errors.Wrap(err, "acct: error in deleting group permissions")
+	}
+	for _, p := range group.Permissions {
+		if _, err := s.Exec("insert ignore into "+TableNameGroupPermission+" (GroupID, PermissionID) values (?, ?)",
+			group.ID, p.ID); err != nil {
+			return errors.Wrap(err, "acct: error in inserting group permission")
+		}
+	}
+	return nil
+}
+
+// DeleteGroup deletes the group.  The built-in groups and groups that
+// still have users can't be deleted.  Call InvalidateGroups once the
+// changes are committed.
+func DeleteGroup(s gorp.SqlExecutor, id int64) error {
+	for _, builtin := range builtinGroups() {
+		if builtin.ID == id {
+			message := fmt.Sprintf("group %s can't be deleted", builtin.Name)
+			return httperr.NewBadRequest(errors.New("acct: "+message), message)
+		}
+	}
+	users, err := s.SelectInt("select count(*) from "+TableNameUser+" where GroupID = ? and Deleted = 0", id)
+	if err != nil {
+		return errors.Wrap(err, "acct: error in counting group users")
+	}
+	if users > 0 {
+		err := errors.New("acct: group has users")
+		return httperr.New(http.StatusConflict, "group still has users, move them to another group first", err)
+	}
+	fallbacks, err := s.SelectInt("select count(*) from "+TableNameGroupMembership+
+		" where PreviousGroupID = ? and ExpiresAt > 0 and Ended = 0", id)
+	if err != nil {
+		return errors.Wrap(err, "acct: error in counting group memberships")
+	}
+	if fallbacks > 0 {
+		err := errors.New("acct: group has temporary members")
+		return httperr.New(http.StatusConflict, "users fall back to the group once their temporary group expires", err)
+	}
+	n, err := s.Delete(&Group{ID: id})
+	if err != nil {
+		return errors.Wrap(err, "acct: error in deleting group")
+	}
+	if n == 0 {
+		return httperr.NewNotFound(errors.New("acct: group not found"), "group")
+	}
+	if _, err := s.Exec("delete from "+TableNameGroupPermission+" where GroupID = ?", id); err != nil {
+		return errors.Wrap(err, "acct: error in deleting group permissions")
+	}
+	if _, err := s.Exec("delete from "+TableNameGroupSetting+" where GroupID = ?", id); err != nil {
+		return errors.Wrap(err, "acct: error in deleting group settings")
+	}
+	return nil
+}
+
+func init() {
+	// groups are changed by super admins, see the group handlers
+	policy.Register(TableNameGroup,
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+	)
+}
@@ -0,0 +1,105 @@
+package acct
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/pwpolicy"
+)
+
+const (
+	// TableNamePasswordHistory is the name of the password history sql table.
+	TableNamePasswordHistory = "password_history"
+
+	passwordPolicyMessage = "Password does not meet the password policy."
+)
+
+// PasswordHistory is the hash of a password the user had.
+type PasswordHistory struct {
+	ID      int64
+	Created int64
+	UserID  int64
+	Hash    string
+}
+
+func (h *PasswordHistory) TableName() string {
+	return TableNamePasswordHistory
+}
+
+// errPasswordPolicy returns a 400 error listing the violated rules.
+func errPasswordPolicy(violations []pwpolicy.Violation) error {
+	return httperr.NewWithDetails(
+		http.StatusBadRequest,
+		passwordPolicyMessage,
+		errors.New("acct: password violates the password policy"),
+		violations)
+}
+
+// GeneratePassword returns a random password satisfying the policy.
+func GeneratePassword() string {
+	return pwpolicy.Default().Generate()
+}
+
+// checkPasswordHistory rejects the new password of the user if it is one
+// of the previous passwords kept in the history.
+func (u *User) checkPasswordHistory(s gorp.SqlExecutor) error {
+	policy := pwpolicy.Default()
+	if policy.History <= 0 || u.ID == 0 {
+		return nil
+	}
+	hashes := []string{u.previousHash}
+	var history []*PasswordHistory
+	query, args, _ := squirrel.Select("*").
+		From(TableNamePasswordHistory).
+		Where(squirrel.Eq{"UserID": u.ID}).
+		OrderBy("ID desc").
+		Limit(uint64(policy.History)).ToSql()
+	if _, err := s.Select(&history, query, args...); err != nil {
+		return errors.Wrap(err, "acct: error in finding password history")
+	}
+	for _, h := range history {
+		hashes = append(hashes, h.Hash)
+	}
+	for _, h := range hashes {
+		if h != "" && validPassword(u.Password, h) {
+			return errPasswordPolicy([]pwpolicy.Violation{policy.HistoryViolation()})
+		}
+	}
+	return nil
+}
+
+// recordPassword adds the user's new password to the history and drops
+// the entries that no longer count.
+func (u *User) recordPassword(s gorp.SqlExecutor) error {
+	u.passwordChanged = false
+	policy := pwpolicy.Default()
+	if policy.History <= 0 {
+		return nil
+	}
+	h := &PasswordHistory{Created: milli.Timestamp(time.Now()), UserID: u.ID, Hash: u.PasswordHash}
+	if err := s.Insert(h); err != nil {
+		return errors.Wrap(err, "acct: error in inserting password history")
+	}
+	var ids []int64
+	query, args, _ := squirrel.Select("ID").
+		From(TableNamePasswordHistory).
+		Where(squirrel.Eq{"UserID": u.ID}).
+		OrderBy("ID desc").ToSql()
+	if _, err := s.Select(&ids, query, args...); err != nil {
+		return errors.Wrap(err, "acct: error in finding password history")
+	}
+	if len(ids) <= policy.History {
+		return nil
+	}
+	if _, err := s.Exec("delete from "+TableNamePasswordHistory+" where UserID = ? and ID < ?",
+		u.ID, ids[policy.History-1]); err != nil {
+		return errors.Wrap(err, "acct: error in deleting password history")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package acct
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// TableNameImpersonationLog is the name of the impersonation log sql
+	// table.
+	TableNameImpersonationLog = "impersonation_logs"
+)
+
+// ImpersonationLog records a request made by an admin acting as another
+// user, including the request that started the impersonation.
+type ImpersonationLog struct {
+	basemodel.BaseModel
+
+	ImpersonatorID int64  `json:"impersonatorID" gator:"nonzero"`
+	UserID         int64  `json:"userID" gator:"nonzero"`
+	TokenID        int64  `json:"tokenID"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	IP             string `json:"ip"`
+	// Status is the response status, zero until the request is handled.
+	Status int `json:"status"`
+}
+
+func (l *ImpersonationLog) TableName() string {
+	return TableNameImpersonationLog
+}
+
+func (l *ImpersonationLog) PreInsert(s gorp.SqlExecutor) error {
+	l.Created = milli.Timestamp(time.Now())
+	l.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+func (l *ImpersonationLog) PreUpdate(s gorp.SqlExecutor) error {
+	l.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+// Impersonate starts a session in which the admin acts as the user with
+// the id.  The returned user carries an access token flagged with the
+// admin that expires after impersonation_minutes and can't be
+// refreshed.  Other admins can't be impersonated.
+func Impersonate(s gorp.SqlExecutor, admin *User, userID int64, client Client) (*User, error) {
+	user := &User{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": userID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "user")
+	}
+	if err := user.Expand(s, ""); err != nil {
+		return nil, errors.Wrap(err, "acct: error in expanding user")
+	}
+	if err := CheckImpersonation(s, admin, user); err != nil {
+		return nil, err
+	}
+	token := &Token{
+		UserID:         user.ID,
+		ImpersonatorID: admin.ID,
+		UserAgent:      client.UserAgent,
+		IP:             client.IP,
+	}
+	if err := s.Insert(token); err != nil {
+		return nil, errors.Wrap(err, "acct: error in inserting impersonation token")
+	}
+	encoded, err := Tokens().Encode(token, user.GroupID)
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in encoding token")
+	}
+	user.Token = encoded
+	user.TokenExpiration = token.Expiration
+	user.Impersonator = admin
+	return user, nil
+}
+
+// CheckImpersonation returns an error unless the admin may start
+// impersonating the user.
+func CheckImpersonation(s gorp.SqlExecutor, admin, user *User) error {
+	if admin.Impersonator != nil {
+		return httperr.New(
+			http.StatusForbidden,
+			"You are already impersonating a user.",
+			errors.New("acct: nested impersonation"))
+	}
+	if user.ID == admin.ID {
+		return httperr.New(
+			http.StatusBadRequest,
+			"You can't impersonate yourself.",
+			errors.New("acct: self impersonation"))
+	}
+	allowed, err := policy.Allowed(s, admin, ActionImpersonate, TableNameUser, user)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return httperr.New(
+			http.StatusForbidden,
+			"user do not have permission to perform request",
+			errors.Errorf("acct: user %d may not impersonate user %d", admin.ID, user.ID))
+	}
+	return nil
+}
+
+// LogImpersonation records the request of the impersonated user made
+// with the access token.  The status is set with SetStatus once the
+// request is handled.
+func LogImpersonation(s gorp.SqlExecutor, user *User, tokenID int64, r *http.Request) (*ImpersonationLog, error) {
+	if user.Impersonator == nil {
+		return nil, errors.New("acct: user isn't impersonated")
+	}
+	l := &ImpersonationLog{
+		ImpersonatorID: user.Impersonator.ID,
+		UserID:         user.ID,
+		TokenID:        tokenID,
+		Method:         r.Method,
+		Path:           r.URL.RequestURI(),
+		IP:             ClientFromRequest(r).IP,
+	}
+	if err := s.Insert(l); err != nil {
+		return nil, errors.Wrap(err, "acct: error in inserting impersonation log")
+	}
+	return l, nil
+}
+
+// SetStatus records the response status of the logged request.
+func (l *ImpersonationLog) SetStatus(s gorp.SqlExecutor, status int) error {
+	l.Status = status
+	if _, err := s.Update(l); err != nil {
+		return errors.Wrap(err, "acct: error in updating impersonation log")
+	}
+	return nil
+}
+
+// setImpersonator sets the admin acting as the user after checking that
+// the admin may still impersonate the user.
+func (u *User) setImpersonator(s gorp.SqlExecutor, impersonatorID int64) error {
+	admin := &User{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": impersonatorID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(admin, query, args...); err != nil {
+		return errors.Wrap(err, "acct: impersonator not found")
+	}
+	if err := admin.Expand(s, ""); err != nil {
+		return errors.Wrap(err, "acct: error in expanding impersonator")
+	}
+	allowed, err := policy.Allowed(s, admin, ActionImpersonate, TableNameUser, u)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.Errorf("acct: user %d may no longer impersonate user %d", admin.ID, u.ID)
+	}
+	u.Impersonator = admin
+	return nil
+}
+
+// impersonatedBy returns whether or not the user with the id acts as the
+// user.
+func (u *User) impersonatedBy(userID int64) bool {
+	return u.Impersonator != nil && u.Impersonator.ID == userID
+}
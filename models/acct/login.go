@@ -0,0 +1,228 @@
+package acct
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// TableNameLoginAttempt is the name of the login attempt sql table.
+	TableNameLoginAttempt = "login_attempts"
+
+	// LoginSucceeded is the result of a login with the correct password
+	// and, if required, the correct second factor.
+	LoginSucceeded = "success"
+	// LoginChallenged is the result of a login with the correct password
+	// of a user who still has to pass two-factor authentication.  It
+	// doesn't clear the failures of the email.
+	LoginChallenged = "challenged"
+	// LoginFailed is the result of a login with an unknown email, an
+	// incorrect password or an incorrect two-factor code.
+	LoginFailed = "failure"
+	// LoginThrottled is the result of a login rejected because of too
+	// many failed logins, without checking the password.
+	LoginThrottled = "throttled"
+	// LoginLockedOut records that an account was locked.
+	LoginLockedOut = "lockout"
+
+	// freeLoginFailures is the number of failed logins allowed before
+	// the backoff kicks in.
+	freeLoginFailures = 3
+	maxLoginAttempts  = 100
+)
+
+// ErrTooManyLogins occurs when logins are throttled.  The message is the
+// same for accounts and IPs so it doesn't reveal if an account exists.
+var ErrTooManyLogins = httperr.New(
+	http.StatusTooManyRequests,
+	"Too many failed login attempts, please try again later.",
+	errors.New("acct: login throttled"))
+
+// LoginAttempt records a login so that password guessing can be slowed
+// down and admins can review it.  The email is recorded as entered, so
+// attempts against unknown emails are throttled like the others.
+type LoginAttempt struct {
+	basemodel.BaseModel
+
+	Email     string `json:"email"`
+	UserID    int64  `json:"userID"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Result    string `json:"result" gator:"nonzero"`
+	// Cleared failures and lockouts no longer count against the email.
+	Cleared bool `json:"cleared"`
+}
+
+func (a *LoginAttempt) PreInsert(s gorp.SqlExecutor) error {
+	a.Created = milli.Timestamp(time.Now())
+	a.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+func (a *LoginAttempt) TableName() string {
+	return TableNameLoginAttempt
+}
+
+func init() {
+	// the attempts are reviewed by those who read every user and are
+	// listed for their organization, see LoginAttempts
+	policy.Register(TableNameLoginAttempt,
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+	)
+}
+
+// loginFailures is the number of failed logins and the time of the last one.
+type loginFailures struct {
+	Count int64
+	Last  int64
+}
+
+// LoginDelay returns how long logins of the email from the ip have to
+// wait.  Zero means the login is allowed.  An email is locked out after
+// MaxFailedLogins consecutive failures and an ip is slowed down after
+// MaxIPFailedLogins failures; below that every further failure doubles
+// the wait.
+func LoginDelay(s gorp.SqlExecutor, email, ip string) (time.Duration, error) {
+	cfg := conf.Get().Auth
+	now := time.Now()
+	since := milli.Timestamp(now.Add(-cfg.LockoutDuration()))
+
+	lockout, err := s.SelectInt("select coalesce(max(Created), 0) from "+TableNameLoginAttempt+
+		" where Email = ? and Result = ? and Cleared = 0 and Created > ?", email, LoginLockedOut, since)
+	if err != nil {
+		return 0, errors.Wrap(err, "acct: error in finding lockouts")
+	}
+	if lockout > 0 {
+		return milli.Time(lockout).Add(cfg.LockoutDuration()).Sub(now), nil
+	}
+	failures, err := countLoginFailures(s, squirrel.Eq{"Email": email, "Cleared": false}, since)
+	if err != nil {
+		return 0, err
+	}
+	wait := loginWait(failures.Count, 0, failures.Last, now)
+	if ip != "" {
+		failures, err = countLoginFailures(s, squirrel.Eq{"IP": ip}, since)
+		if err != nil {
+			return 0, err
+		}
+		free := cfg.MaxIPFailedLogins - freeLoginFailures
+		if ipWait := loginWait(failures.Count, free, failures.Last, now); ipWait > wait {
+			wait = ipWait
+		}
+	}
+	return wait, nil
+}
+
+// RecordLogin saves the login attempt.  A failure that reaches
+// MaxFailedLogins locks the email and a success clears its failures.
+func RecordLogin(s gorp.SqlExecutor, attempt *LoginAttempt) error {
+	if err := s.Insert(attempt); err != nil {
+		return errors.Wrap(err, "acct: error in inserting login attempt")
+	}
+	switch attempt.Result {
+	case LoginSucceeded:
+		return UnlockLogin(s, attempt.Email)
+	case LoginFailed:
+		cfg := conf.Get().Auth
+		since := milli.Timestamp(time.Now().Add(-cfg.LockoutDuration()))
+		failures, err := countLoginFailures(s, squirrel.Eq{"Email": attempt.Email, "Cleared": false}, since)
+		if err != nil {
+			return err
+		}
+		if failures.Count < cfg.MaxFailedLogins {
+			return nil
+		}
+		lockout := *attempt
+		lockout.ID = 0
+		lockout.Result = LoginLockedOut
+		if err := s.Insert(&lockout); err != nil {
+			return errors.Wrap(err, "acct: error in inserting lockout")
+		}
+		// the failures are paid for by the lockout
+		return clearLoginFailures(s, attempt.Email, LoginFailed)
+	}
+	return nil
+}
+
+// UnlockLogin clears the failures and lockouts of the email.
+func UnlockLogin(s gorp.SqlExecutor, email string) error {
+	return clearLoginFailures(s, email, LoginFailed, LoginLockedOut)
+}
+
+// LoginAttempts returns the most recent login attempts, optionally only
+// those of the email or the ip.  Unless orgID is zero only the attempts on
+// users of the organization are returned.
+func LoginAttempts(s gorp.SqlExecutor, orgID int64, email, ip string) ([]*LoginAttempt, error) {
+	pred := squirrel.Eq{}
+	if email != "" {
+		pred["Email"] = email
+	}
+	if ip != "" {
+		pred["IP"] = ip
+	}
+	builder := squirrel.Select("*").
+		From(TableNameLoginAttempt).
+		Where(pred)
+	if orgID != 0 {
+		builder = builder.Where("UserID in (select ID from "+TableNameUser+" where OrgID = ?)", orgID)
+	}
+	var attempts []*LoginAttempt
+	query, args, _ := builder.OrderBy("ID desc").Limit(maxLoginAttempts).ToSql()
+	if _, err := s.Select(&attempts, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding login attempts")
+	}
+	return attempts, nil
+}
+
+func clearLoginFailures(s gorp.SqlExecutor, email string, results ...string) error {
+	query, args, _ := squirrel.Update(TableNameLoginAttempt).
+		Set("Cleared", true).
+		Set("Updated", milli.Timestamp(time.Now())).
+		Where(squirrel.Eq{"Email": email, "Result": results, "Cleared": false}).ToSql()
+	if _, err := s.Exec(query, args...); err != nil {
+		return errors.Wrap(err, "acct: error in clearing login failures")
+	}
+	return nil
+}
+
+func countLoginFailures(s gorp.SqlExecutor, pred squirrel.Eq, since int64) (*loginFailures, error) {
+	failures := &loginFailures{}
+	query, args, _ := squirrel.Select("count(*) as Count", "coalesce(max(Created), 0) as Last").
+		From(TableNameLoginAttempt).
+		Where(pred).
+		Where(squirrel.Eq{"Result": LoginFailed}).
+		Where(squirrel.Gt{"Created": since}).ToSql()
+	if err := s.SelectOne(failures, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in counting login failures")
+	}
+	return failures, nil
+}
+
+// loginWait returns the time left to wait at now after count failures,
+// the last of them at last.  The first free plus freeLoginFailures
+// failures aren't delayed, every further failure doubles the wait and
+// the wait never exceeds the lockout duration.
+func loginWait(count, free, last int64, now time.Time) time.Duration {
+	n := count - free - freeLoginFailures
+	if n < 0 {
+		return 0
+	}
+	wait := conf.Get().Auth.LockoutDuration()
+	if n < 32 && time.Second<<uint(n) < wait {
+		wait = time.Second << uint(n)
+	}
+	if left := milli.Time(last).Add(wait).Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
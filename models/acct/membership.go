@@ -0,0 +1,270 @@
+package acct
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/logger"
+	"github.com/rahul2393/small-assignment-server/milli"
+)
+
+const (
+	// TableNameGroupMembership is the name of the group membership sql
+	// table.
+	TableNameGroupMembership = "group_memberships"
+
+	// ReasonExpired is the reason of the change made when a temporary
+	// membership expires.
+	ReasonExpired = "expired"
+	// maxReasonLength is the longest reason a group change may be given.
+	maxReasonLength = 255
+)
+
+// GroupMembership is a change of the group of a user.  The memberships
+// of a user are the history of their groups.  Temporary memberships
+// expire, after which the user falls back to their previous group.
+type GroupMembership struct {
+	ID      int64 `json:"id"`
+	Created int64 `json:"created"`
+	UserID  int64 `json:"userID"`
+	GroupID int64 `json:"groupID"`
+	// PreviousGroupID is the group the user falls back to once the
+	// membership expires.
+	PreviousGroupID int64 `json:"previousGroupID"`
+	// AssignedByID is the user who changed the group, zero when the change
+	// is the fall back of an expired membership.
+	AssignedByID int64  `json:"assignedByID"`
+	Reason       string `json:"reason"`
+	// ExpiresAt is zero for permanent memberships.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// Ended is when a temporary membership expired or was replaced by
+	// another change of group.
+	Ended int64 `json:"ended,omitempty"`
+}
+
+func (m *GroupMembership) TableName() string {
+	return TableNameGroupMembership
+}
+
+func (m *GroupMembership) PreInsert(s gorp.SqlExecutor) error {
+	m.Created = milli.Timestamp(time.Now())
+	return nil
+}
+
+// ChangeGroup moves the user to the group with the id and records the
+// change.  A non zero expiresAt makes the membership temporary; the user
+// falls back to their current group once it passes, or to the group a
+// temporary membership being extended falls back to.
+func ChangeGroup(s gorp.SqlExecutor, user, assignedBy *User, groupID int64, reason string, expiresAt time.Time) (*GroupMembership, error) {
+	if len(reason) > maxReasonLength {
+		message := "reason is too long"
+		return nil, httperr.NewBadRequest(errors.New("acct: "+message), message)
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		message := "expiry must be in the future"
+		return nil, httperr.NewBadRequest(errors.New("acct: "+message), message)
+	}
+	active, err := activeMembership(s, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	previous := user.GroupID
+	if active != nil && !expiresAt.IsZero() {
+		previous = active.PreviousGroupID
+	}
+	if !expiresAt.IsZero() && previous == groupID {
+		err := errors.Errorf("acct: user %d would fall back to group %d", user.ID, groupID)
+		return nil, httperr.New(http.StatusBadRequest, "a temporary group must differ from the previous group", err)
+	}
+	if active != nil {
+		active.Ended = milli.Timestamp(time.Now())
+		if _, err := s.Update(active); err != nil {
+			return nil, errors.Wrap(err, "acct: error in ending group membership")
+		}
+	}
+	user.GroupID = groupID
+	if _, err := s.Update(user); err != nil {
+		return nil, errors.Wrap(err, "acct: error in updating user group")
+	}
+	m := &GroupMembership{
+		UserID:          user.ID,
+		GroupID:         groupID,
+		PreviousGroupID: previous,
+		AssignedByID:    assignedBy.ID,
+		Reason:          reason,
+	}
+	if !expiresAt.IsZero() {
+		m.ExpiresAt = milli.Timestamp(expiresAt)
+	}
+	if err := s.Insert(m); err != nil {
+		return nil, errors.Wrap(err, "acct: error in inserting group membership")
+	}
+	return m, nil
+}
+
+// GroupMemberships returns the history of the groups of the user, latest
+// first.
+func GroupMemberships(s gorp.SqlExecutor, userID int64) ([]*GroupMembership, error) {
+	memberships := []*GroupMembership{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameGroupMembership).
+		Where(squirrel.Eq{"UserID": userID}).
+		OrderBy("ID desc").ToSql()
+	if _, err := s.Select(&memberships, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding group memberships")
+	}
+	return memberships, nil
+}
+
+// activeMembership returns the temporary membership of the user that
+// hasn't ended, if any.
+func activeMembership(s gorp.SqlExecutor, userID int64) (*GroupMembership, error) {
+	var memberships []*GroupMembership
+	query, args, _ := squirrel.Select("*").
+		From(TableNameGroupMembership).
+		Where(squirrel.Eq{"UserID": userID, "Ended": 0}).
+		Where(squirrel.Gt{"ExpiresAt": 0}).
+		OrderBy("ID desc").
+		Limit(1).ToSql()
+	if _, err := s.Select(&memberships, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding group membership")
+	}
+	if len(memberships) == 0 {
+		return nil, nil
+	}
+	return memberships[0], nil
+}
+
+// ExpireGroupMemberships moves the users whose temporary memberships
+// expired by now back to their previous group and returns how many
+// memberships expired.  Each membership expires in its own transaction;
+// a membership that fails to expire is logged and retried by the next
+// sweep.
+func ExpireGroupMemberships(db *gorp.DbMap, now time.Time) (int, error) {
+	var due []*GroupMembership
+	query, args, _ := squirrel.Select("*").
+		From(TableNameGroupMembership).
+		Where(squirrel.Eq{"Ended": 0}).
+		Where(squirrel.Gt{"ExpiresAt": 0}).
+		Where(squirrel.LtOrEq{"ExpiresAt": milli.Timestamp(now)}).
+		OrderBy("ID").ToSql()
+	if _, err := db.Select(&due, query, args...); err != nil {
+		return 0, errors.Wrap(err, "acct: error in finding expired group memberships")
+	}
+	expired := 0
+	for _, m := range due {
+		user, err := expireMembershipInTx(db, m, now)
+		if err == errMembershipEnded {
+			continue
+		}
+		if err != nil {
+			logger.ErrorWithMsg("acct: error in expiring group membership", err)
+			continue
+		}
+		expired++
+		if user != nil {
+			user.DeleteCacheSession()
+		}
+	}
+	return expired, nil
+}
+
+// errMembershipEnded occurs when a membership due to expire was ended
+// meanwhile, by another change of group or another sweep.
+var errMembershipEnded = errors.New("acct: group membership already ended")
+
+// expireMembershipInTx expires the membership in a transaction of its
+// own.
+func expireMembershipInTx(db *gorp.DbMap, m *GroupMembership, now time.Time) (user *User, err error) {
+	trans, err := db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in beginning transaction")
+	}
+	defer func() {
+		if err != nil {
+			trans.Rollback()
+		} else if err = trans.Commit(); err != nil {
+			user, err = nil, errors.Wrapf(err, "acct: error in committing expired group membership %d", m.ID)
+		}
+	}()
+	return expireMembership(trans, m, now)
+}
+
+// expireMembership ends the membership and returns the user moved back
+// to their previous group, nil if the user left the group or was deleted
+// meanwhile.  Users whose previous group was deleted become regular users.
+// It fails with errMembershipEnded if the membership already ended.
+func expireMembership(s gorp.SqlExecutor, m *GroupMembership, now time.Time) (*User, error) {
+	m.Ended = milli.Timestamp(now)
+	res, err := s.Exec("update "+TableNameGroupMembership+" set Ended = ? where ID = ? and Ended = 0", m.Ended, m.ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "acct: error in ending group membership %d", m.ID)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, errMembershipEnded
+	}
+	user := &User{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": m.UserID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding user")
+	}
+	if user.GroupID != m.GroupID {
+		return nil, nil
+	}
+	fallback := m.PreviousGroupID
+	if GroupForID(fallback) == nil {
+		fallback = Regular.ID
+	}
+	user.GroupID = fallback
+	if _, err := s.Update(user); err != nil {
+		return nil, errors.Wrap(err, "acct: error in updating user group")
+	}
+	if err := s.Insert(&GroupMembership{
+		UserID:          user.ID,
+		GroupID:         fallback,
+		PreviousGroupID: m.GroupID,
+		Reason:          ReasonExpired,
+	}); err != nil {
+		return nil, errors.Wrap(err, "acct: error in inserting group membership")
+	}
+	// the change is made by no one, in no request
+	if err := Audit(s, &User{OrgID: user.OrgID}, "", string(ActionChangeGroup), TableNameUser, user.ID,
+		map[string]int64{"groupID": m.GroupID}, map[string]int64{"groupID": fallback}); err != nil {
+		return nil, err
+	}
+	if err := DeleteSessions(s, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SweepGroupMemberships expires temporary memberships every interval
+// until the stop channel is closed, forever when it is nil.
+func SweepGroupMemberships(db *gorp.DbMap, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			n, err := ExpireGroupMemberships(db, now)
+			if err != nil {
+				logger.ErrorWithMsg("acct: error in expiring group memberships", err)
+			}
+			if n > 0 {
+				logger.Infof("acct: %d group memberships expired", n)
+			}
+		}
+	}
+}
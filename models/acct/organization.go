@@ -0,0 +1,169 @@
+package acct
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ShaleApps/gator"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// ModelNameOrganization is the name of the organization model.
+	ModelNameOrganization = "Organization"
+	// TableNameOrganization is the name of the organization sql table.
+	TableNameOrganization = "organizations"
+
+	// DefaultOrgID is the organization users sign up to unless they
+	// name another one.
+	DefaultOrgID int64 = 1
+)
+
+// Organization is a tenant, such as a clinic.  Users and their meals
+// belong to one organization and are invisible to the others.  Only super
+// admins manage organizations.
+type Organization struct {
+	basemodel.BaseModel
+
+	Name string `json:"name" gator:"nonzero"`
+	// Slug names the organization when users sign up.
+	Slug string `json:"slug" gator:"nonzero"`
+	// OpenSignup lets anyone sign up to the organization with its slug.
+	// The users of other organizations are created by their admins.
+	OpenSignup bool `json:"openSignup"`
+}
+
+func (o *Organization) TableName() string {
+	return TableNameOrganization
+}
+
+func (o *Organization) Merge(src interface{}) error {
+	from, ok := src.(*Organization)
+	if !ok {
+		return ErrMergeWrongType
+	}
+	o.Name = from.Name
+	o.Slug = from.Slug
+	o.OpenSignup = from.OpenSignup
+	return nil
+}
+
+func (o *Organization) PreInsert(s gorp.SqlExecutor) error {
+	o.Created = milli.Timestamp(time.Now())
+	o.Updated = milli.Timestamp(time.Now())
+	return o.validate()
+}
+
+func (o *Organization) PreUpdate(s gorp.SqlExecutor) error {
+	o.Updated = milli.Timestamp(time.Now())
+	return o.validate()
+}
+
+func (o *Organization) validate() error {
+	o.Slug = strings.ToLower(strings.TrimSpace(o.Slug))
+	if err := gator.NewStruct(o).Validate(); err != nil {
+		return httperr.New(http.StatusBadRequest, "organization requires a name and a slug",
+			errors.Wrap(err, "error in validating organization"))
+	}
+	return nil
+}
+
+// Delete marks the organization deleted.  The default organization and
+// organizations with users can't be deleted.
+func (o *Organization) Delete(s gorp.SqlExecutor) error {
+	if o.ID == DefaultOrgID {
+		return httperr.New(http.StatusBadRequest, "the default organization can't be deleted",
+			errors.New("acct: deleting the default organization"))
+	}
+	query, args, _ := squirrel.Select("count(*)").
+		From(TableNameUser).
+		Where(squirrel.Eq{"OrgID": o.ID, "Deleted": false}).ToSql()
+	n, err := s.SelectInt(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "acct: error in counting organization users")
+	}
+	if n > 0 {
+		return httperr.New(http.StatusConflict, "organization still has users",
+			errors.Errorf("acct: organization %d has %d users", o.ID, n))
+	}
+	o.Deleted = true
+	return nil
+}
+
+func (o *Organization) Expand(s gorp.SqlExecutor, exclude string) error {
+	o.ModelName = ModelNameOrganization
+	return nil
+}
+
+// OrganizationForSlug returns the organization with the slug.
+func OrganizationForSlug(s gorp.SqlExecutor, slug string) (*Organization, error) {
+	o := &Organization{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameOrganization).
+		Where(squirrel.Eq{"Slug": strings.ToLower(strings.TrimSpace(slug)), "Deleted": false}).ToSql()
+	if err := s.SelectOne(o, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "organization")
+	}
+	return o, nil
+}
+
+// OrganizationForID returns the organization with the id.
+func OrganizationForID(s gorp.SqlExecutor, id int64) (*Organization, error) {
+	o := &Organization{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameOrganization).
+		Where(squirrel.Eq{"ID": id, "Deleted": false}).ToSql()
+	if err := s.SelectOne(o, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "organization")
+	}
+	return o, nil
+}
+
+// UserOrgID returns the organization of the user with the id, zero if
+// there is no such user.
+func UserOrgID(s gorp.SqlExecutor, userID int64) (int64, error) {
+	query, args, _ := squirrel.Select("coalesce(max(OrgID), 0)").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": userID}).ToSql()
+	id, err := s.SelectInt(query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "acct: error in finding organization of user")
+	}
+	return id, nil
+}
+
+// InOrganization returns whether or not the user may access the data of
+// the organization.
+func (u *User) InOrganization(orgID int64) bool {
+	return u.SubjectOrgID() == 0 || u.OrgID == orgID
+}
+
+// superAdmin matches the users managing the platform.
+func superAdmin(sub policy.Subject) bool {
+	u, ok := sub.(*User)
+	return ok && u.SuperAdmin
+}
+
+func init() {
+	policy.Register(TableNameOrganization,
+		policy.Rule{Action: policy.Read, Subject: superAdmin},
+		policy.Rule{Action: policy.Read, Subject: policy.Anyone(), Resource: &policy.Condition{
+			Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+				o, ok := record.(*Organization)
+				return ok && o.ID == sub.SubjectOrgID(), nil
+			},
+			SQL: func(sub policy.Subject) squirrel.Sqlizer {
+				return squirrel.Eq{"ID": sub.SubjectOrgID()}
+			},
+		}},
+		policy.Rule{Action: policy.Write, Subject: superAdmin},
+	)
+}
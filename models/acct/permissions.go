@@ -43,6 +43,15 @@ var (
 	ReadAllMeals  = Permission{ID: 6, Name: "read:all_meals", TableName: TableNameMeal, level: read, IsAll: true}
 	WriteMeals    = Permission{ID: 7, Name: "write:meals", TableName: TableNameMeal, level: Write}
 	WriteAllMeals = Permission{ID: 8, Name: "write:all_meals", TableName: TableNameMeal, level: Write, IsAll: true}
+	// ReadTeamUsers and WriteTeamUsers grant access to the members of the
+	// teams the user manages.
+	ReadTeamUsers  = Permission{ID: 9, Name: "read:team_users", TableName: TableNameUser, level: read}
+	WriteTeamUsers = Permission{ID: 10, Name: "write:team_users", TableName: TableNameUser, level: Write}
+	// ImpersonateUsers lets users act as the other users of their
+	// organization, see Impersonate.
+	ImpersonateUsers = Permission{ID: 11, Name: "impersonate:users", TableName: TableNameUser, level: Write}
+	// ReadAuditLog grants access to the audit log of the organization.
+	ReadAuditLog = Permission{ID: 12, Name: "read:audit", TableName: TableNameAuditEntry, level: read, IsAll: true}
 )
 
 // AllPermissions returns a list of all permissions.
@@ -50,13 +59,37 @@ func AllPermissions() []Permission {
 	return []Permission{ReadUsers, WriteUsers, ReadMeals, WriteMeals}
 }
 
+// builtinPermissions are the permissions seeded by the groups migration.
+func builtinPermissions() []Permission {
+	return []Permission{
+		ReadUsers, ReadAllUsers, WriteUsers, WriteAllUsers,
+		ReadMeals, ReadAllMeals, WriteMeals, WriteAllMeals,
+		ReadTeamUsers, WriteTeamUsers,
+		ImpersonateUsers, ReadAuditLog,
+	}
+}
+
+// PermissionForName returns the permission with the given name.
+// If no permission is found, NoPerm is returned.
+func PermissionForName(name string) Permission {
+	_, permissions := groupStore()
+	for _, p := range permissions {
+		if p.Name == name {
+			return p
+		}
+	}
+	return NoPerm
+}
+
 // Group represents a named grouping of permissions for ease of assignment.
 type Group struct {
 	ID          int64        `json:"id"`
 	Name        string       `json:"name"`
-	Permissions []Permission `json:"permissions"`
+	Permissions []Permission `db:"-" json:"permissions"`
 }
 
+// The built-in groups.  The groups migration seeds them with these ids;
+// their permissions are those of the database.
 var (
 	// Admins can manage users and generally do everything
 	Admin = Group{ID: 1, Name: "Admin", Permissions: []Permission{
@@ -64,13 +97,16 @@ var (
 		WriteAllUsers,
 		ReadAllMeals,
 		WriteAllMeals,
+		ImpersonateUsers,
+		ReadAuditLog,
 	}}
-	// UserManager is a group allowed to CRUD users
+	// UserManager is a group allowed to CRUD the users of the teams they
+	// manage
 	UserManager = Group{ID: 2, Name: "UserManager", Permissions: []Permission{
-		ReadAllUsers,
-		WriteAllUsers,
 		ReadMeals,
 		WriteMeals,
+		ReadTeamUsers,
+		WriteTeamUsers,
 	}}
 	// Regular is a group allowed to only view data
 	Regular = Group{ID: 3, Name: "Regular", Permissions: []Permission{
@@ -80,8 +116,9 @@ var (
 	}}
 )
 
-// Groups returns a list of all groups.
-func Groups() []Group {
+// builtinGroups are the groups seeded by the groups migration.  They are
+// used until the group store is set up with UseGroupStore.
+func builtinGroups() []Group {
 	return []Group{
 		Admin,
 		UserManager,
@@ -89,6 +126,12 @@ func Groups() []Group {
 	}
 }
 
+// Groups returns a list of all groups.
+func Groups() []Group {
+	groups, _ := groupStore()
+	return groups
+}
+
 // GroupForID returns the group for the given id.
 // If no group is found, nil is returned.
 func GroupForID(id int64) *Group {
@@ -100,11 +143,30 @@ func GroupForID(id int64) *Group {
 	return nil
 }
 
+// GetPermission returns the group's permission to the table at the level.
 func (group *Group) GetPermission(tableName string, level int) Permission {
-	for _, perm := range group.Permissions {
+	for _, perm := range group.current() {
 		if perm.TableName == tableName && perm.level == level {
 			return perm
 		}
 	}
 	return NoPerm
 }
+
+// Has returns whether or not the group grants the permission.
+func (group *Group) Has(p Permission) bool {
+	return Permissions(group.current()).Has(p)
+}
+
+// current returns the group's permissions.  Groups with an id are looked
+// up in the group store, so changes to a group apply to cached sessions as
+// well.
+func (group *Group) current() []Permission {
+	if group.ID == 0 {
+		return group.Permissions
+	}
+	if current := GroupForID(group.ID); current != nil {
+		return current.Permissions
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package acct
+
+import (
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// ActionResetPassword is setting the password of a user.
+	ActionResetPassword policy.Action = "reset_password"
+	// ActionChangeGroup is moving a user to a group, the record is a
+	// *GroupChange.
+	ActionChangeGroup policy.Action = "change_group"
+	// ActionImpersonate is acting as a user, see Impersonate.
+	ActionImpersonate policy.Action = "impersonate"
+	// ActionUnlock is lifting the lockout of a user, see UnlockLogin.
+	ActionUnlock policy.Action = "unlock"
+	// ActionDisableTwoFactor is disabling the two-factor authentication of
+	// a user, see User.DisableTwoFactor.
+	ActionDisableTwoFactor policy.Action = "disable_two_factor"
+)
+
+// GroupChange is the record of the ActionChangeGroup action.  Users being
+// created are moved from and to their group.
+type GroupChange struct {
+	From int64
+	To   int64
+	// OrgID is the organization of the user.
+	OrgID int64
+	// UserID is the user, zero for users being created.
+	UserID int64
+	// TeamID is the team users being created join, if any.
+	TeamID int64
+}
+
+func init() {
+	self := policy.Owner("ID", func(record interface{}) int64 {
+		if u, ok := record.(*User); ok {
+			return u.ID
+		}
+		return 0
+	})
+	policy.Scope(TableNameUser, policy.Tenant("OrgID", func(s gorp.SqlExecutor, record interface{}) (int64, error) {
+		switch r := record.(type) {
+		case *User:
+			return r.OrgID, nil
+		case *GroupChange:
+			return r.OrgID, nil
+		}
+		return 0, nil
+	}))
+	policy.Register(TableNameUser,
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadUsers.Name), Resource: self},
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadTeamUsers.Name), Resource: policy.Or(self, managed)},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(WriteAllUsers.Name)},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(WriteUsers.Name), Resource: self},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(WriteTeamUsers.Name), Resource: policy.Or(self, managed)},
+
+		// everyone may change their own password, users writing every user
+		// anyone's and those writing the users of their teams the passwords
+		// of the regular users of their teams, but not while an admin acts
+		// as them
+		policy.Rule{Action: ActionResetPassword, Subject: unimpersonated(policy.Anyone()), Resource: self},
+		policy.Rule{Action: ActionResetPassword, Subject: unimpersonated(policy.Permission(WriteAllUsers.Name))},
+		policy.Rule{Action: ActionResetPassword, Subject: unimpersonated(policy.Permission(WriteTeamUsers.Name)),
+			Resource: policy.And(inGroup(Regular.ID), managed)},
+
+		// users writing every user may move anyone, those writing the users
+		// of their teams may move themselves and the users of their teams
+		// other than admins to groups other than admin
+		policy.Rule{Action: ActionChangeGroup, Subject: policy.Permission(WriteAllUsers.Name)},
+		policy.Rule{Action: ActionChangeGroup, Subject: policy.Permission(WriteTeamUsers.Name), Resource: &policy.Condition{
+			Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+				change, ok := record.(*GroupChange)
+				if !ok || change.From == Admin.ID || change.To == Admin.ID {
+					return false, nil
+				}
+				switch {
+				case change.UserID == sub.SubjectID():
+					return true, nil
+				case change.UserID != 0:
+					return Manages(s, sub.SubjectID(), change.UserID)
+				case change.TeamID != 0:
+					return ManagesTeam(s, sub.SubjectID(), change.TeamID)
+				}
+				return false, nil
+			},
+		}},
+
+		// admins may not be impersonated
+		policy.Rule{Action: ActionImpersonate, Subject: policy.Permission(ImpersonateUsers.Name), Resource: notInGroup(Admin.ID)},
+
+		// users writing every user lift lockouts and disable anyone's
+		// two-factor authentication, the others only disable their own
+		policy.Rule{Action: ActionUnlock, Subject: policy.Permission(WriteAllUsers.Name)},
+		policy.Rule{Action: ActionDisableTwoFactor, Subject: policy.Anyone(), Resource: self},
+		policy.Rule{Action: ActionDisableTwoFactor, Subject: policy.Permission(WriteAllUsers.Name)},
+	)
+	policy.Fields(TableNameUser,
+		// user managers may only set the daily calories of their users,
+		// passwords are reset with ActionResetPassword and credentials are
+		// never changed while an admin acts as the user
+		policy.FieldRule{Field: "email", Action: policy.Write, Subject: unimpersonated(policy.Anyone()), Resource: self},
+		policy.FieldRule{Field: "email", Action: policy.Write, Subject: unimpersonated(policy.Permission(WriteAllUsers.Name))},
+		policy.FieldRule{Field: "password", Action: policy.Write, Subject: unimpersonated(policy.Anyone()), Resource: self},
+		policy.FieldRule{Field: "password", Action: policy.Write, Subject: unimpersonated(policy.Permission(WriteAllUsers.Name))},
+		policy.FieldRule{Field: "expectedCaloriesPerDay", Action: policy.Write, Subject: policy.Anyone(), Resource: self},
+		policy.FieldRule{Field: "expectedCaloriesPerDay", Action: policy.Write, Subject: policy.Permission(WriteAllUsers.Name)},
+		policy.FieldRule{Field: "expectedCaloriesPerDay", Action: policy.Write, Subject: policy.Permission(WriteTeamUsers.Name)},
+
+		// the security settings of users are private to them and admins
+		policy.FieldRule{Field: "emailVerified", Action: policy.Read, Subject: policy.Anyone(), Resource: self},
+		policy.FieldRule{Field: "emailVerified", Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+		policy.FieldRule{Field: "twoFactorEnabled", Action: policy.Read, Subject: policy.Anyone(), Resource: self},
+		policy.FieldRule{Field: "twoFactorEnabled", Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+		policy.FieldRule{Field: "superAdmin", Action: policy.Read, Subject: policy.Anyone(), Resource: self},
+		policy.FieldRule{Field: "superAdmin", Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+	)
+}
+
+// unimpersonated matches the subjects of the matcher unless an admin is
+// acting as them.
+func unimpersonated(m policy.Matcher) policy.Matcher {
+	return func(sub policy.Subject) bool {
+		if u, ok := sub.(*User); ok && u.Impersonator != nil {
+			return false
+		}
+		return m(sub)
+	}
+}
+
+// inGroup matches the users of the group.
+func inGroup(groupID int64) *policy.Condition {
+	return &policy.Condition{
+		Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+			u, ok := record.(*User)
+			return ok && u.GroupID == groupID, nil
+		},
+		SQL: func(sub policy.Subject) squirrel.Sqlizer {
+			return squirrel.Eq{"GroupID": groupID}
+		},
+	}
+}
+
+// notInGroup matches the users of every other group.
+func notInGroup(groupID int64) *policy.Condition {
+	return &policy.Condition{
+		Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+			u, ok := record.(*User)
+			return ok && u.GroupID != groupID, nil
+		},
+		SQL: func(sub policy.Subject) squirrel.Sqlizer {
+			return squirrel.NotEq{"GroupID": groupID}
+		},
+	}
+}
+
+// SubjectID implements the policy.Subject interface.
+func (u *User) SubjectID() int64 {
+	return u.ID
+}
+
+// SubjectGroupID implements the policy.Subject interface.  Users
+// authenticated with an api key act as a group without id.
+func (u *User) SubjectGroupID() int64 {
+	if u.Group == nil {
+		return 0
+	}
+	return u.Group.ID
+}
+
+// SubjectOrgID implements the policy.Subject interface.  Super admins
+// span every organization.
+func (u *User) SubjectOrgID() int64 {
+	if u.SuperAdmin {
+		return 0
+	}
+	return u.OrgID
+}
+
+// HasPermission implements the policy.Subject interface.
+func (u *User) HasPermission(name string) bool {
+	if u.Group == nil {
+		return false
+	}
+	p := PermissionForName(name)
+	return p != NoPerm && u.Group.Has(p)
+}
@@ -1,6 +1,9 @@
 package acct
 
 import (
+	"sync"
+
+	"github.com/dchest/uniuri"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -17,3 +20,18 @@ func validPassword(password, hash string) bool {
 	return nil == bcrypt.CompareHashAndPassword(
 		[]byte(hash), []byte(password))
 }
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// SimulatePasswordCheck takes as long as checking the password of a user.
+// It is used for unknown emails so response times don't reveal which
+// emails have an account.
+func SimulatePasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = hash(uniuri.New())
+	})
+	validPassword(password, dummyHash)
+}
@@ -0,0 +1,248 @@
+package acct
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ShaleApps/gator"
+	"github.com/dchest/uniuri"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+)
+
+const (
+	// TableNameRefreshToken is the name of the refresh token sql table.
+	TableNameRefreshToken = "refresh_tokens"
+)
+
+// ErrRefreshTokenReused occurs when a refresh token is used again.  The
+// family of the token is revoked by then, which callers must keep.
+var ErrRefreshTokenReused = httperr.New(
+	http.StatusUnauthorized,
+	"Refresh token was already used, please login again",
+	errors.New("acct: refresh token reuse detected"))
+
+// RefreshToken is exchanged for a new access token once the previous
+// one expires.  Every refresh token can be used exactly once; using it
+// again revokes the whole family since the token must have leaked.
+type RefreshToken struct {
+	basemodel.BaseModel
+
+	UserID        int64  `gator:"nonzero"`
+	AccessTokenID int64  `gator:"nonzero"`
+	FamilyID      string `gator:"nonzero"`
+	Value         string `db:"-"`
+	Hash          string `gator:"nonzero"`
+	Expiration    int64  `gator:"nonzero"`
+	Used          bool
+}
+
+func (t *RefreshToken) String() string {
+	return fmt.Sprintf("%s:%d", t.Value, t.ID)
+}
+
+// Expired returns whether or not the refresh token is expired.
+func (t *RefreshToken) Expired() bool {
+	exp := milli.Time(t.Expiration)
+	return time.Now().After(exp)
+}
+
+// HasValue returns whether or not the value is equal
+// to the hashed value.
+func (t *RefreshToken) HasValue(value string) bool {
+	return validPassword(value, t.Hash)
+}
+
+func (t *RefreshToken) PreInsert(s gorp.SqlExecutor) error {
+	t.Created = milli.Timestamp(time.Now())
+	t.Updated = milli.Timestamp(time.Now())
+	value, hash, err := newSecret()
+	if err != nil {
+		return err
+	}
+	t.Value = value
+	t.Hash = hash
+	ex := time.Now().Add(conf.Get().Auth.RefreshTokenLifetime())
+	t.Expiration = milli.Timestamp(ex)
+	if err := gator.NewStruct(t).Validate(); err != nil {
+		return errors.Wrap(err, "error in validating refresh-token")
+	}
+	return nil
+}
+
+// PostInsert implements the gorp.HasPostInsert interface.
+func (t *RefreshToken) PostInsert(s gorp.SqlExecutor) error {
+	timestamp := milli.Timestamp(time.Now())
+	if _, err := s.Exec("delete from "+TableNameRefreshToken+" where Expiration < ?", timestamp); err != nil {
+		return errors.New("Error in deleting expired refresh-token")
+	}
+	return nil
+}
+
+func (t *RefreshToken) TableName() string {
+	return TableNameRefreshToken
+}
+
+// NewSession inserts an access token and the refresh token paired with it
+// for the user.  A new token family is started when family is empty.
+func NewSession(s gorp.SqlExecutor, userID int64, family string, client Client) (*Token, *RefreshToken, error) {
+	if family == "" {
+		family = uniuri.NewLen(20)
+	}
+	token := &Token{
+		UserID:    userID,
+		FamilyID:  family,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	}
+	if err := s.Insert(token); err != nil {
+		return nil, nil, errors.Wrap(err, "error in inserting token")
+	}
+	refresh := &RefreshToken{UserID: userID, AccessTokenID: token.ID, FamilyID: family}
+	if err := s.Insert(refresh); err != nil {
+		return nil, nil, errors.Wrap(err, "error in inserting refresh token")
+	}
+	return token, refresh, nil
+}
+
+// SetSession sets the tokens returned to the user.  The access token is
+// encoded with the configured token strategy.
+func (u *User) SetSession(token *Token, refresh *RefreshToken) error {
+	encoded, err := Tokens().Encode(token, u.GroupID)
+	if err != nil {
+		return errors.Wrap(err, "acct: error in encoding token")
+	}
+	u.Token = encoded
+	u.TokenExpiration = token.Expiration
+	u.RefreshToken = refresh.String()
+	u.RefreshTokenExpiration = refresh.Expiration
+	return nil
+}
+
+// Refresh exchanges the refresh token for a new access and refresh token
+// pair.  The presented refresh token and its access token are revoked.  If
+// the refresh token was already used the whole token family is revoked.
+func Refresh(s gorp.SqlExecutor, refreshToken string, client Client) (*User, error) {
+	value, id, err := SplitToken(refreshToken)
+	if err != nil {
+		return nil, errInvalidAuth(err)
+	}
+	old := &RefreshToken{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameRefreshToken).
+		Where(squirrel.Eq{"ID": id}).ToSql()
+	if err := s.SelectOne(old, query, args...); err != nil {
+		return nil, errInvalidAuth(err)
+	}
+	if !old.HasValue(value) {
+		return nil, errInvalidAuth(errors.New("acct: refresh token's value is incorrect"))
+	}
+	if old.Deleted {
+		return nil, errInvalidAuth(errors.New("acct: refresh token is revoked"))
+	}
+	if old.Expired() {
+		return nil, errInvalidAuth(errors.New("acct: refresh token is expired"))
+	}
+	// mark the token as used, a concurrent refresh with the same token loses
+	res, err := s.Exec("update "+TableNameRefreshToken+" set Used = 1, Updated = ? where ID = ? and Used = 0",
+		milli.Timestamp(time.Now()), old.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in using refresh token")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err := RevokeFamily(s, old.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err := revokeTokens(s, squirrel.Eq{"ID": old.AccessTokenID}); err != nil {
+		return nil, err
+	}
+	user := &User{}
+	query, args, _ = squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": old.UserID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err != nil {
+		return nil, errInvalidAuth(err)
+	}
+	token, refresh, err := NewSession(s, user.ID, old.FamilyID, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.SetSession(token, refresh); err != nil {
+		return nil, err
+	}
+	if err := user.Expand(s, ""); err != nil {
+		return nil, errors.Wrap(err, "acct: error in expanding user")
+	}
+	return user, nil
+}
+
+// RevokeFamily revokes every access and refresh token of the family.
+func RevokeFamily(s gorp.SqlExecutor, family string) error {
+	if _, err := s.Exec("update "+TableNameRefreshToken+" set Deleted = 1 where FamilyID = ?", family); err != nil {
+		return errors.Wrap(err, "acct: error in revoking refresh tokens")
+	}
+	return revokeTokens(s, squirrel.Eq{"FamilyID": family})
+}
+
+// revokeTokens marks the access tokens matching the predicate deleted,
+// adds them to the revocation list and drops their cached sessions.
+func revokeTokens(s gorp.SqlExecutor, pred squirrel.Eq) error {
+	tokens, err := findRevokedTokens(s, pred)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(tokens))
+	for _, t := range tokens {
+		ids = append(ids, t.TokenID)
+	}
+	query, args, _ := squirrel.Update(TableNameToken).
+		Set("Deleted", true).
+		Where(squirrel.Eq{"ID": ids}).ToSql()
+	if _, err := s.Exec(query, args...); err != nil {
+		return errors.Wrap(err, "acct: error in revoking tokens")
+	}
+	if err := addRevoked(s, tokens); err != nil {
+		return err
+	}
+	deleteCachedTokens(ids...)
+	return nil
+}
+
+// findRevokedTokens returns revocation list entries for the access
+// tokens matching the predicate.
+func findRevokedTokens(s gorp.SqlExecutor, pred squirrel.Eq) ([]*RevokedToken, error) {
+	var tokens []*RevokedToken
+	query, args, _ := squirrel.Select("ID as TokenID", "Expiration").
+		From(TableNameToken).
+		Where(pred).ToSql()
+	if _, err := s.Select(&tokens, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding tokens")
+	}
+	return tokens, nil
+}
+
+// deleteCachedTokens drops the cached sessions of the token ids.
+func deleteCachedTokens(ids ...int64) {
+	revoked := map[int64]bool{}
+	for _, id := range ids {
+		revoked[id] = true
+	}
+	for token := range cache.Items(CacheKeySession) {
+		if id, err := TokenID(token); err == nil && revoked[id] {
+			cache.Delete(CacheKeySession, token)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package acct
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ShaleApps/gator"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/logger"
+	"github.com/rahul2393/small-assignment-server/mail"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+)
+
+const (
+	// TableNamePasswordReset is the name of the password reset sql table.
+	TableNamePasswordReset = "password_resets"
+
+	purposePasswordReset     = "password-reset"
+	passwordResetSubject     = "Reset your password"
+	passwordResetBodyFormat  = "Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open the link below to choose a new password.\n\n%s\n\nThe link expires on %s. If you didn't ask for a new password you can ignore this email."
+	passwordResetInvalidText = "Invalid or expired password reset token"
+)
+
+// PasswordReset is a single-use token emailed to a user who forgot their
+// password.  Only the hash of the token is stored.
+type PasswordReset struct {
+	basemodel.BaseModel
+
+	UserID     int64  `gator:"nonzero"`
+	Value      string `db:"-"`
+	Hash       string `gator:"nonzero"`
+	Expiration int64  `gator:"nonzero"`
+	Used       bool
+}
+
+func (p *PasswordReset) String() string {
+	return fmt.Sprintf("%s:%d", p.Value, p.ID)
+}
+
+// Expired returns whether or not the reset token is expired.
+func (p *PasswordReset) Expired() bool {
+	return time.Now().After(milli.Time(p.Expiration))
+}
+
+func (p *PasswordReset) PreInsert(s gorp.SqlExecutor) error {
+	p.Created = milli.Timestamp(time.Now())
+	p.Updated = milli.Timestamp(time.Now())
+	value, hash, err := newSecret()
+	if err != nil {
+		return err
+	}
+	p.Value = value
+	p.Hash = hash
+	ex := time.Now().Add(conf.Get().Auth.PasswordResetLifetime())
+	p.Expiration = milli.Timestamp(ex)
+	if err := gator.NewStruct(p).Validate(); err != nil {
+		return errors.Wrap(err, "error in validating password reset")
+	}
+	return nil
+}
+
+// PostInsert implements the gorp.HasPostInsert interface.
+func (p *PasswordReset) PostInsert(s gorp.SqlExecutor) error {
+	timestamp := milli.Timestamp(time.Now())
+	if _, err := s.Exec("delete from "+TableNamePasswordReset+" where Expiration < ?", timestamp); err != nil {
+		return errors.New("Error in deleting expired password resets")
+	}
+	return nil
+}
+
+func (p *PasswordReset) TableName() string {
+	return TableNamePasswordReset
+}
+
+// RequestPasswordReset emails a password reset token to the user with the
+// email.  Nothing is sent for unknown emails, or if a token was sent
+// recently, but no error is returned so callers can't probe for accounts.
+func RequestPasswordReset(s gorp.SqlExecutor, email string) error {
+	user := &User{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"Email": strings.ToLower(email), "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err != nil {
+		logger.Debugf("password reset requested for unknown email %s", email)
+		return nil
+	}
+	if !allowEmail(purposePasswordReset, user.ID) {
+		logger.Debugf("password reset for %s rate limited", email)
+		return nil
+	}
+	reset := &PasswordReset{UserID: user.ID}
+	if err := s.Insert(reset); err != nil {
+		return errors.Wrap(err, "acct: error in inserting password reset")
+	}
+	link := conf.Get().BaseURL + "/password/reset?token=" + url.QueryEscape(reset.String())
+	exp := milli.Time(reset.Expiration)
+	return mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: passwordResetSubject,
+		Body:    fmt.Sprintf(passwordResetBodyFormat, user.Name, link, exp.Format(time.RFC1123)),
+	})
+}
+
+// ResetPasswordWithToken sets the password of the user the reset token
+// was issued to.  The token and every session of the user are revoked.
+func ResetPasswordWithToken(s gorp.SqlExecutor, token, password string) (*User, error) {
+	invalid := func(err error) (*User, error) {
+		return nil, httperr.New(http.StatusBadRequest, passwordResetInvalidText, err)
+	}
+	value, id, err := SplitToken(token)
+	if err != nil {
+		return invalid(err)
+	}
+	reset := &PasswordReset{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNamePasswordReset).
+		Where(squirrel.Eq{"ID": id, "Deleted": false, "Used": false}).ToSql()
+	if err := s.SelectOne(reset, query, args...); err != nil {
+		return invalid(err)
+	}
+	if reset.Expired() {
+		return invalid(errors.New("acct: password reset expired"))
+	}
+	if !validPassword(value, reset.Hash) {
+		return invalid(errors.New("acct: password reset value is incorrect"))
+	}
+	// consume the token, a concurrent reset with the same token loses
+	res, err := s.Exec("update "+TableNamePasswordReset+" set Used = 1, Updated = ? where ID = ? and Used = 0",
+		milli.Timestamp(time.Now()), reset.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "acct: error in using password reset")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return invalid(errors.New("acct: password reset already used"))
+	}
+	user := &User{}
+	query, args, _ = squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": reset.UserID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err != nil {
+		return invalid(err)
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+	if _, err := s.Update(user); err != nil {
+		if e, ok := err.(httperr.Error); ok {
+			return nil, e
+		}
+		return nil, errors.Wrap(err, "acct: error in resetting password")
+	}
+	// the other outstanding reset tokens are no longer needed
+	if _, err := s.Exec("update "+TableNamePasswordReset+" set Deleted = 1 where UserID = ?", user.ID); err != nil {
+		return nil, errors.Wrap(err, "acct: error in revoking password resets")
+	}
+	user.DeleteCacheSession()
+	if err := DeleteSessions(s, user.ID); err != nil {
+		return nil, err
+	}
+	if err := user.Expand(s, ""); err != nil {
+		return nil, errors.Wrap(err, "acct: error in expanding user")
+	}
+	return user, nil
+}
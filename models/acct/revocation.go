@@ -0,0 +1,101 @@
+package acct
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/milli"
+)
+
+const (
+	// TableNameRevokedToken is the name of the revoked token sql table.
+	TableNameRevokedToken = "revoked_tokens"
+
+	cacheKeyRevokedToken = "revoked-token"
+	// revocationSyncInterval is how often the revocation list is reloaded
+	// to pick up tokens revoked by other servers.
+	revocationSyncInterval = time.Minute
+)
+
+// RevokedToken is an entry of the revocation list.  Entries are kept
+// until the token expires on its own.
+type RevokedToken struct {
+	TokenID    int64
+	Expiration int64
+}
+
+func (t *RevokedToken) TableName() string {
+	return TableNameRevokedToken
+}
+
+var (
+	revocationMu     sync.Mutex
+	revocationSynced time.Time
+)
+
+// addRevoked adds the tokens to the revocation list.
+func addRevoked(s gorp.SqlExecutor, tokens []*RevokedToken) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(tokens))
+	args := make([]interface{}, 0, 2*len(tokens))
+	for _, t := range tokens {
+		values = append(values, "(?, ?)")
+		args = append(args, t.TokenID, t.Expiration)
+	}
+	if _, err := s.Exec("insert ignore into "+TableNameRevokedToken+" (TokenID, Expiration) values "+
+		strings.Join(values, ", "), args...); err != nil {
+		return errors.Wrap(err, "acct: error in saving revoked tokens")
+	}
+	for _, t := range tokens {
+		cacheRevoked(t)
+	}
+	return nil
+}
+
+// isRevoked returns whether or not the token id is on the revocation
+// list.  The list is held in memory and synced with the database every
+// revocationSyncInterval.
+func isRevoked(s gorp.SqlExecutor, tokenID int64) (bool, error) {
+	if err := syncRevoked(s); err != nil {
+		return false, err
+	}
+	_, in := cache.Get(cacheKeyRevokedToken, strconv.FormatInt(tokenID, 10))
+	return in, nil
+}
+
+func syncRevoked(s gorp.SqlExecutor) error {
+	revocationMu.Lock()
+	defer revocationMu.Unlock()
+	if time.Since(revocationSynced) < revocationSyncInterval {
+		return nil
+	}
+	now := milli.Timestamp(time.Now())
+	if _, err := s.Exec("delete from "+TableNameRevokedToken+" where Expiration < ?", now); err != nil {
+		return errors.Wrap(err, "acct: error in deleting expired revoked tokens")
+	}
+	var tokens []*RevokedToken
+	if _, err := s.Select(&tokens, "select * from "+TableNameRevokedToken); err != nil {
+		return errors.Wrap(err, "acct: error in loading revoked tokens")
+	}
+	for _, t := range tokens {
+		cacheRevoked(t)
+	}
+	revocationSynced = time.Now()
+	return nil
+}
+
+func cacheRevoked(t *RevokedToken) {
+	d := milli.Time(t.Expiration).Sub(time.Now())
+	if d <= 0 {
+		return
+	}
+	cache.Set(cacheKeyRevokedToken, strconv.FormatInt(t.TokenID, 10), cache.Item{Src: true, Duration: d})
+}
@@ -0,0 +1,207 @@
+package acct
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/logger"
+	"github.com/rahul2393/small-assignment-server/milli"
+)
+
+const (
+	cacheKeyTokenUsed = "token-used"
+	// lastUsedInterval throttles the writes of Token.LastUsed.
+	lastUsedInterval = 5 * time.Minute
+
+	maxUserAgentLength = 255
+)
+
+// Client describes the device a session was created from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// ClientFromRequest returns the client of the request.
+func ClientFromRequest(r *http.Request) Client {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return Client{UserAgent: userAgent, IP: ClientIP(r)}
+}
+
+// ClientIP returns the ip of the client of the request.  The
+// X-Forwarded-For header is read from the right for as long as the
+// addresses are trusted proxies, any other entry may have been chosen by
+// the client.
+func ClientIP(r *http.Request) string {
+	ip := RemoteIP(r)
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0 && trustedProxy(ip); i-- {
+		next := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(next) == nil {
+			break
+		}
+		ip = next
+	}
+	return ip
+}
+
+// RemoteIP returns the ip of the remote address of the request.  Unlike
+// the X-Forwarded-For header it can't be chosen by the client.
+func RemoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// trustedProxy tells whether the ip is one of the configured proxies.
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range conf.Get().Auth.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if other := net.ParseIP(proxy); other != nil && other.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Session is an active access token as shown to its owner.
+type Session struct {
+	ID         int64  `json:"id"`
+	Created    int64  `json:"created"`
+	LastUsed   int64  `json:"lastUsed"`
+	Expiration int64  `json:"expiration"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	Current    bool   `json:"current"`
+	// ImpersonatorID is the admin acting as the user in the session.
+	ImpersonatorID int64 `json:"impersonatorID,omitempty"`
+}
+
+// Sessions returns the user's non-deleted, unexpired access tokens.
+// The session of currentTokenID is flagged as current.
+func Sessions(s gorp.SqlExecutor, userID, currentTokenID int64) ([]Session, error) {
+	var tokens []*Token
+	query, args, _ := squirrel.Select("*").
+		From(TableNameToken).
+		Where(squirrel.Eq{"UserID": userID, "Deleted": false}).
+		Where(squirrel.Gt{"Expiration": milli.Timestamp(time.Now())}).
+		OrderBy("LastUsed desc").ToSql()
+	if _, err := s.Select(&tokens, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding sessions")
+	}
+	sessions := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, Session{
+			ID:             t.ID,
+			Created:        t.Created,
+			LastUsed:       t.LastUsed,
+			Expiration:     t.Expiration,
+			UserAgent:      t.UserAgent,
+			IP:             t.IP,
+			Current:        t.ID == currentTokenID,
+			ImpersonatorID: t.ImpersonatorID,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes the user's access token along with every token
+// rotated from the same login.  An error is returned if the user doesn't
+// own an active token with the id.
+func RevokeSession(s gorp.SqlExecutor, userID, tokenID int64) error {
+	t := &Token{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameToken).
+		Where(squirrel.Eq{"ID": tokenID, "UserID": userID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(t, query, args...); err != nil {
+		return errors.Wrap(err, "acct: session not found")
+	}
+	return revokeSessionToken(s, t)
+}
+
+// RevokeOtherSessions revokes every session of the user except the one
+// of currentTokenID.
+func RevokeOtherSessions(s gorp.SqlExecutor, userID, currentTokenID int64) error {
+	var tokens []*Token
+	query, args, _ := squirrel.Select("*").
+		From(TableNameToken).
+		Where(squirrel.Eq{"UserID": userID, "Deleted": false}).
+		Where(squirrel.NotEq{"ID": currentTokenID}).ToSql()
+	if _, err := s.Select(&tokens, query, args...); err != nil {
+		return errors.Wrap(err, "acct: error in finding sessions")
+	}
+	for _, t := range tokens {
+		if err := revokeSessionToken(s, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSessions deletes every access and refresh token of the user and
+// drops their cached sessions.
+func DeleteSessions(s gorp.SqlExecutor, userID int64) error {
+	// signed tokens stay valid without their rows unless they are revoked
+	tokens, err := findRevokedTokens(s, squirrel.Eq{"UserID": userID, "Deleted": false})
+	if err != nil {
+		return err
+	}
+	if err := addRevoked(s, tokens); err != nil {
+		return err
+	}
+	// the user's impersonation of others ends too
+	if err := revokeTokens(s, squirrel.Eq{"ImpersonatorID": userID, "Deleted": false}); err != nil {
+		return err
+	}
+	if _, err := s.Exec("delete from "+TableNameRefreshToken+" where UserID = ?", userID); err != nil {
+		return errors.Wrap(err, "acct: error in deleting refresh tokens")
+	}
+	if _, err := s.Exec("delete from "+TableNameToken+" where UserID = ?", userID); err != nil {
+		return errors.Wrap(err, "acct: error in deleting tokens")
+	}
+	deleteCachedSessions(userID)
+	return nil
+}
+
+func revokeSessionToken(s gorp.SqlExecutor, t *Token) error {
+	// tokens issued before refresh tokens have no family
+	if t.FamilyID == "" {
+		return revokeTokens(s, squirrel.Eq{"ID": t.ID})
+	}
+	return RevokeFamily(s, t.FamilyID)
+}
+
+// touchToken records that the token authenticated a request.  Writes
+// are throttled to one per lastUsedInterval for each token.
+func touchToken(s gorp.SqlExecutor, tokenID int64) {
+	id := strconv.FormatInt(tokenID, 10)
+	if _, in := cache.Get(cacheKeyTokenUsed, id); in {
+		return
+	}
+	if _, err := s.Exec("update "+TableNameToken+" set LastUsed = ? where ID = ?",
+		milli.Timestamp(time.Now()), id); err != nil {
+		logger.ErrorWithMsg("acct: error in updating token last used", err)
+		return
+	}
+	cache.Set(cacheKeyTokenUsed, id, cache.Item{Src: true, Duration: lastUsedInterval})
+}
@@ -0,0 +1,206 @@
+package acct
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ShaleApps/gator"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+)
+
+const (
+	// TableNameShare is the name of the share sql table.
+	TableNameShare = "shares"
+
+	// ShareRead lets the grantee read the owner's meals.
+	ShareRead = "read"
+	// ShareWrite lets the grantee read, add, change and delete the owner's
+	// meals.
+	ShareWrite = "write"
+)
+
+// Share grants another user, such as a dietitian or a coach, access to the
+// owner's meals.  Shares without an expiration last until revoked.
+type Share struct {
+	basemodel.BaseModel
+
+	OwnerID    int64  `json:"ownerID" gator:"nonzero"`
+	GranteeID  int64  `json:"granteeID" gator:"nonzero"`
+	Access     string `json:"access" gator:"nonzero"`
+	Expiration int64  `json:"expiration"`
+
+	Owner   *User `db:"-" json:"owner,omitempty"`
+	Grantee *User `db:"-" json:"grantee,omitempty"`
+}
+
+func (sh *Share) TableName() string {
+	return TableNameShare
+}
+
+func (sh *Share) PreInsert(s gorp.SqlExecutor) error {
+	sh.Created = milli.Timestamp(time.Now())
+	sh.Updated = milli.Timestamp(time.Now())
+	if err := gator.NewStruct(sh).Validate(); err != nil {
+		return errors.Wrap(err, "error in validating share")
+	}
+	return nil
+}
+
+func (sh *Share) PreUpdate(s gorp.SqlExecutor) error {
+	sh.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+// Expand loads the owner and the grantee.
+func (sh *Share) Expand(s gorp.SqlExecutor, exclude string) error {
+	var users []*User
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": []int64{sh.OwnerID, sh.GranteeID}}).ToSql()
+	if _, err := s.Select(&users, query, args...); err != nil {
+		return errors.Wrap(err, "acct: error in expanding share")
+	}
+	for _, u := range users {
+		switch u.ID {
+		case sh.OwnerID:
+			sh.Owner = u
+		case sh.GranteeID:
+			sh.Grantee = u
+		}
+	}
+	return nil
+}
+
+// Expired returns whether or not the share is expired.
+func (sh *Share) Expired() bool {
+	return sh.Expiration != 0 && time.Now().After(milli.Time(sh.Expiration))
+}
+
+// shareAccess returns the share access levels granting the permission's
+// level.
+func shareAccess(p Permission) []string {
+	if p.level == Write {
+		return []string{ShareWrite}
+	}
+	return []string{ShareRead, ShareWrite}
+}
+
+// activeShares matches the shares granting the grantee the permission's
+// level that aren't revoked or expired.
+func activeShares(granteeID int64, p Permission) squirrel.Sqlizer {
+	return squirrel.And{
+		squirrel.Eq{"GranteeID": granteeID, "Deleted": false, "Access": shareAccess(p)},
+		squirrel.Or{
+			squirrel.Eq{"Expiration": 0},
+			squirrel.Gt{"Expiration": milli.Timestamp(time.Now())},
+		},
+	}
+}
+
+// SharedWith returns a predicate matching the rows whose column is an
+// owner sharing with the grantee at the permission's level.
+func SharedWith(column string, granteeID int64, p Permission) squirrel.Sqlizer {
+	shared, args, _ := squirrel.Select("OwnerID").
+		From(TableNameShare).
+		Where(activeShares(granteeID, p)).ToSql()
+	return squirrel.Expr(column+" in ("+shared+")", args...)
+}
+
+// HasShare returns whether or not the owner shares with the grantee at the
+// permission's level.
+func HasShare(s gorp.SqlExecutor, ownerID, granteeID int64, p Permission) (bool, error) {
+	query, args, _ := squirrel.Select("count(*)").
+		From(TableNameShare).
+		Where(activeShares(granteeID, p)).
+		Where(squirrel.Eq{"OwnerID": ownerID}).ToSql()
+	n, err := s.SelectInt(query, args...)
+	if err != nil {
+		return false, errors.Wrap(err, "acct: error in finding shares")
+	}
+	return n > 0, nil
+}
+
+// Shares returns the shares the user gave and received that aren't
+// revoked, expired ones included.
+func Shares(s gorp.SqlExecutor, userID int64) ([]*Share, error) {
+	var shares []*Share
+	query, args, _ := squirrel.Select("*").
+		From(TableNameShare).
+		Where(squirrel.Eq{"Deleted": false}).
+		Where(squirrel.Or{squirrel.Eq{"OwnerID": userID}, squirrel.Eq{"GranteeID": userID}}).
+		OrderBy("ID").ToSql()
+	if _, err := s.Select(&shares, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding shares")
+	}
+	for _, sh := range shares {
+		if err := sh.Expand(s, ""); err != nil {
+			return nil, err
+		}
+	}
+	return shares, nil
+}
+
+// ShareMeals grants the user with the email access to the owner's meals.
+// The user must belong to the owner's organization.  An existing share
+// with the same user is replaced.
+func ShareMeals(s gorp.SqlExecutor, owner *User, email, access string, expiration int64) (*Share, error) {
+	invalid := func(message string) error {
+		return httperr.New(http.StatusBadRequest, message, errors.New("acct: "+message))
+	}
+	if access != ShareRead && access != ShareWrite {
+		return nil, invalid("access must be read or write")
+	}
+	if expiration != 0 && time.Now().After(milli.Time(expiration)) {
+		return nil, invalid("share expiration must be in the future")
+	}
+	grantee := &User{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"Email": strings.ToLower(email), "OrgID": owner.OrgID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(grantee, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "user")
+	}
+	if grantee.ID == owner.ID {
+		return nil, invalid("meals can't be shared with yourself")
+	}
+	query, args, _ = squirrel.Update(TableNameShare).
+		Set("Deleted", true).
+		Set("Updated", milli.Timestamp(time.Now())).
+		Where(squirrel.Eq{"OwnerID": owner.ID, "GranteeID": grantee.ID, "Deleted": false}).ToSql()
+	if _, err := s.Exec(query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in replacing share")
+	}
+	sh := &Share{OwnerID: owner.ID, GranteeID: grantee.ID, Access: access, Expiration: expiration}
+	if err := s.Insert(sh); err != nil {
+		return nil, errors.Wrap(err, "acct: error in inserting share")
+	}
+	if err := sh.Expand(s, ""); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// RevokeShare revokes the share.  Both the owner and the grantee may
+// revoke it.
+func RevokeShare(s gorp.SqlExecutor, user *User, shareID int64) (*Share, error) {
+	sh := &Share{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameShare).
+		Where(squirrel.Eq{"ID": shareID, "Deleted": false}).
+		Where(squirrel.Or{squirrel.Eq{"OwnerID": user.ID}, squirrel.Eq{"GranteeID": user.ID}}).ToSql()
+	if err := s.SelectOne(sh, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "share")
+	}
+	sh.Deleted = true
+	if _, err := s.Update(sh); err != nil {
+		return nil, errors.Wrap(err, "acct: error in revoking share")
+	}
+	return sh, nil
+}
@@ -0,0 +1,172 @@
+package acct
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/sign"
+)
+
+const (
+	// TokenStrategyDatabase is the name of the DatabaseTokens strategy.
+	TokenStrategyDatabase = "database"
+	// TokenStrategySigned is the name of the SignedTokens strategy.
+	TokenStrategySigned = "signed"
+
+	purposeAccessToken = "access-token"
+)
+
+// AccessClaims are the verified facts about an access token.
+type AccessClaims struct {
+	TokenID    int64 `json:"jti"`
+	UserID     int64 `json:"uid"`
+	GroupID    int64 `json:"gid"`
+	Expiration int64 `json:"exp"`
+	// ImpersonatorID is the admin acting as the user, if any.
+	ImpersonatorID int64 `json:"imp,omitempty"`
+}
+
+// TokenStrategy turns access tokens into the strings handed to clients
+// and verifies them.  Every strategy stores its tokens in the tokens
+// table so sessions can be listed, refreshed and revoked alike.
+type TokenStrategy interface {
+	// Encode returns the string handed to the client for the inserted
+	// token of a user in the group.
+	Encode(t *Token, groupID int64) (string, error)
+	// Decode verifies the token string and returns its claims.  A zero
+	// GroupID means the strategy doesn't carry the group.
+	Decode(s gorp.SqlExecutor, token string) (*AccessClaims, error)
+	// ID returns the token id of a token string issued by the strategy
+	// without checking whether the token is expired or revoked.
+	ID(token string) (int64, error)
+}
+
+var (
+	strategyMu sync.RWMutex
+	strategy   TokenStrategy
+)
+
+// Tokens returns the token strategy configured with token_strategy in
+// conf.toml unless it was replaced with SetTokenStrategy.
+func Tokens() TokenStrategy {
+	strategyMu.RLock()
+	ts := strategy
+	strategyMu.RUnlock()
+	if ts != nil {
+		return ts
+	}
+	strategyMu.Lock()
+	defer strategyMu.Unlock()
+	if strategy == nil {
+		switch conf.Get().Auth.TokenStrategy {
+		case TokenStrategySigned:
+			strategy = SignedTokens{}
+		default:
+			strategy = DatabaseTokens{}
+		}
+	}
+	return strategy
+}
+
+// SetTokenStrategy replaces the strategy returned by Tokens.  Tokens
+// issued by the previous strategy stop working.
+func SetTokenStrategy(ts TokenStrategy) {
+	strategyMu.Lock()
+	defer strategyMu.Unlock()
+	strategy = ts
+}
+
+// DatabaseTokens are "value:id" tokens whose value is compared with the
+// bcrypt hash of the stored token on every cache miss.
+type DatabaseTokens struct{}
+
+func (DatabaseTokens) Encode(t *Token, groupID int64) (string, error) {
+	return t.String(), nil
+}
+
+func (DatabaseTokens) Decode(s gorp.SqlExecutor, token string) (*AccessClaims, error) {
+	value, id, err := SplitToken(token)
+	if err != nil {
+		return nil, err
+	}
+	t := &Token{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameToken).
+		Where(squirrel.Eq{"ID": id, "Deleted": false}).ToSql()
+	if err := s.SelectOne(t, query, args...); err != nil {
+		return nil, err
+	}
+	if t.Expired() {
+		return nil, errors.New("acct: token is expired")
+	}
+	if !t.HasValue(value) {
+		return nil, errors.New("acct: token's value is incorrect")
+	}
+	return &AccessClaims{
+		TokenID:        t.ID,
+		UserID:         t.UserID,
+		Expiration:     t.Expiration,
+		ImpersonatorID: t.ImpersonatorID,
+	}, nil
+}
+
+func (DatabaseTokens) ID(token string) (int64, error) {
+	_, id, err := SplitToken(token)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(id, 10, 64)
+}
+
+// SignedTokens are HMAC signed claims, see the sign package.  They are
+// verified without a database lookup; revoked tokens are rejected with
+// the revocation list.
+type SignedTokens struct{}
+
+func (SignedTokens) Encode(t *Token, groupID int64) (string, error) {
+	return sign.Encode(purposeAccessToken, &AccessClaims{
+		TokenID:        t.ID,
+		UserID:         t.UserID,
+		GroupID:        groupID,
+		Expiration:     t.Expiration,
+		ImpersonatorID: t.ImpersonatorID,
+	})
+}
+
+func (SignedTokens) Decode(s gorp.SqlExecutor, token string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	if err := sign.Decode(purposeAccessToken, token, claims); err != nil {
+		return nil, err
+	}
+	if time.Now().After(milli.Time(claims.Expiration)) {
+		return nil, errors.New("acct: token is expired")
+	}
+	revoked, err := isRevoked(s, claims.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("acct: token is revoked")
+	}
+	return claims, nil
+}
+
+func (SignedTokens) ID(token string) (int64, error) {
+	claims := &AccessClaims{}
+	if err := sign.Decode(purposeAccessToken, token, claims); err != nil {
+		return 0, err
+	}
+	return claims.TokenID, nil
+}
+
+// TokenID returns the id of the access token string.
+func TokenID(token string) (int64, error) {
+	return Tokens().ID(token)
+}
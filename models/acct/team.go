@@ -0,0 +1,304 @@
+package acct
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/ShaleApps/gator"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
+)
+
+const (
+	// ModelNameTeam is the name of the team model.
+	ModelNameTeam = "Team"
+	// TableNameTeam is the name of the team sql table.
+	TableNameTeam = "teams"
+	// TableNameTeamMember is the name of the sql table joining teams and
+	// their members.
+	TableNameTeamMember = "team_members"
+
+	// ActionManageMembers is adding users to and removing them from a
+	// team, the record is a *Team.
+	ActionManageMembers policy.Action = "manage_members"
+	// ActionAssignManagers is making members managers of a team or
+	// removing managers, the record is a *Team.
+	ActionAssignManagers policy.Action = "assign_managers"
+)
+
+// Team groups users under the managers looking after them.  User managers
+// only see and manage the members of the teams they manage.
+type Team struct {
+	basemodel.BaseModel
+
+	Name  string `json:"name" gator:"nonzero"`
+	OrgID int64  `json:"orgID"`
+
+	Members []*TeamMember `db:"-" json:"members,omitempty"`
+}
+
+// TeamMember is a user of a team.  Users may be members of several teams.
+type TeamMember struct {
+	TeamID  int64 `json:"teamID"`
+	UserID  int64 `json:"userID"`
+	Manager bool  `json:"manager"`
+	Created int64 `json:"created"`
+
+	User *User `db:"-" json:"user,omitempty"`
+}
+
+func (t *Team) TableName() string {
+	return TableNameTeam
+}
+
+func (t *Team) Merge(src interface{}) error {
+	from, ok := src.(*Team)
+	if !ok {
+		return ErrMergeWrongType
+	}
+	t.Name = from.Name
+	return nil
+}
+
+func (t *Team) PreInsert(s gorp.SqlExecutor) error {
+	t.Created = milli.Timestamp(time.Now())
+	t.Updated = milli.Timestamp(time.Now())
+	return t.validate()
+}
+
+func (t *Team) PreUpdate(s gorp.SqlExecutor) error {
+	t.Updated = milli.Timestamp(time.Now())
+	return t.validate()
+}
+
+func (t *Team) validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if err := gator.NewStruct(t).Validate(); err != nil {
+		return httperr.New(http.StatusBadRequest, "team requires a name",
+			errors.Wrap(err, "error in validating team"))
+	}
+	return nil
+}
+
+// Verify puts new teams in the organization of the current user unless
+// another one is named.  The tenant scope leaves naming another one to
+// super admins.
+func (t *Team) Verify(reqId string, s gorp.SqlExecutor) error {
+	if t.OrgID == 0 {
+		t.OrgID = GetCurrentRequestUserFromCache(reqId).OrgID
+	}
+	return nil
+}
+
+// Delete marks the team deleted and removes its members.
+func (t *Team) Delete(s gorp.SqlExecutor) error {
+	if _, err := s.Exec("delete from "+TableNameTeamMember+" where TeamID = ?", t.ID); err != nil {
+		return errors.Wrap(err, "acct: error in deleting team members")
+	}
+	t.Deleted = true
+	return nil
+}
+
+// Expand loads the members of the team.
+func (t *Team) Expand(s gorp.SqlExecutor, exclude string) error {
+	t.ModelName = ModelNameTeam
+	members, err := TeamMembers(s, t.ID)
+	if err != nil {
+		return err
+	}
+	t.Members = members
+	return nil
+}
+
+// TeamForID returns the team with the id.
+func TeamForID(s gorp.SqlExecutor, id int64) (*Team, error) {
+	t := &Team{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameTeam).
+		Where(squirrel.Eq{"ID": id, "Deleted": false}).ToSql()
+	if err := s.SelectOne(t, query, args...); err != nil {
+		return nil, httperr.NewNotFound(err, "team")
+	}
+	return t, nil
+}
+
+// TeamMembers returns the members of the team, managers first.
+func TeamMembers(s gorp.SqlExecutor, teamID int64) ([]*TeamMember, error) {
+	members := []*TeamMember{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameTeamMember).
+		Where(squirrel.Eq{"TeamID": teamID}).
+		OrderBy("Manager desc", "UserID").ToSql()
+	if _, err := s.Select(&members, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding team members")
+	}
+	if len(members) == 0 {
+		return members, nil
+	}
+	ids := make([]int64, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	var users []*User
+	query, args, _ = squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": ids}).ToSql()
+	if _, err := s.Select(&users, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding team members")
+	}
+	byID := map[int64]*User{}
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	for _, m := range members {
+		m.User = byID[m.UserID]
+	}
+	return members, nil
+}
+
+// Member returns the membership of the user in the team, nil if the
+// user isn't a member.
+func (t *Team) Member(s gorp.SqlExecutor, userID int64) (*TeamMember, error) {
+	var members []*TeamMember
+	query, args, _ := squirrel.Select("*").
+		From(TableNameTeamMember).
+		Where(squirrel.Eq{"TeamID": t.ID, "UserID": userID}).ToSql()
+	if _, err := s.Select(&members, query, args...); err != nil {
+		return nil, errors.Wrap(err, "acct: error in finding team member")
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return members[0], nil
+}
+
+// SetMember adds the user to the team, or changes whether or not the
+// member is a manager.  Users join teams of their own organization, and
+// only users whose group may manage team users become managers.
+func (t *Team) SetMember(s gorp.SqlExecutor, user *User, manager bool) (*TeamMember, error) {
+	if user.OrgID != t.OrgID {
+		return nil, httperr.New(http.StatusBadRequest, "user is in another organization",
+			errors.Errorf("acct: user %d isn't in the organization of team %d", user.ID, t.ID))
+	}
+	if manager && !user.HasPermission(WriteTeamUsers.Name) && !user.HasPermission(WriteAllUsers.Name) {
+		return nil, httperr.New(http.StatusBadRequest, "user may not manage users",
+			errors.Errorf("acct: user %d can't manage team %d", user.ID, t.ID))
+	}
+	m := &TeamMember{TeamID: t.ID, UserID: user.ID, Manager: manager, Created: milli.Timestamp(time.Now())}
+	if _, err := s.Exec("insert into "+TableNameTeamMember+" (TeamID, UserID, Manager, Created) values (?, ?, ?, ?) "+
+		"on duplicate key update Manager = values(Manager)", m.TeamID, m.UserID, m.Manager, m.Created); err != nil {
+		return nil, errors.Wrap(err, "acct: error in setting team member")
+	}
+	m.User = user
+	return m, nil
+}
+
+// RemoveMember removes the user from the team.
+func (t *Team) RemoveMember(s gorp.SqlExecutor, userID int64) error {
+	if _, err := s.Exec("delete from "+TableNameTeamMember+" where TeamID = ? and UserID = ?", t.ID, userID); err != nil {
+		return errors.Wrap(err, "acct: error in removing team member")
+	}
+	return nil
+}
+
+// managedTeams returns the query of the ids of the teams the user
+// manages.
+func managedTeams(userID int64) (string, []interface{}) {
+	query, args, _ := squirrel.Select("TeamID").
+		From(TableNameTeamMember).
+		Where(squirrel.Eq{"UserID": userID, "Manager": true}).ToSql()
+	return query, args
+}
+
+// managedUsers returns the query of the ids of the members of the teams
+// the user manages.
+func managedUsers(userID int64) (string, []interface{}) {
+	teams, args := managedTeams(userID)
+	query, _, _ := squirrel.Select("UserID").
+		From(TableNameTeamMember).
+		Where("TeamID in (" + teams + ")").ToSql()
+	return query, args
+}
+
+// Manages returns whether or not the manager manages a team the user is a
+// member of.
+func Manages(s gorp.SqlExecutor, managerID, userID int64) (bool, error) {
+	users, args := managedUsers(managerID)
+	n, err := s.SelectInt("select count(*) from ("+users+") managed where UserID = ?", append(args, userID)...)
+	if err != nil {
+		return false, errors.Wrap(err, "acct: error in finding managed users")
+	}
+	return n > 0, nil
+}
+
+// ManagesTeam returns whether or not the manager manages the team.
+func ManagesTeam(s gorp.SqlExecutor, managerID, teamID int64) (bool, error) {
+	teams, args := managedTeams(managerID)
+	n, err := s.SelectInt("select count(*) from ("+teams+") managed where TeamID = ?", append(args, teamID)...)
+	if err != nil {
+		return false, errors.Wrap(err, "acct: error in finding managed teams")
+	}
+	return n > 0, nil
+}
+
+// managed matches the users of the teams the subject manages.  Admins
+// are never managed, even as members of a team.
+var managed = &policy.Condition{
+	Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+		u, ok := record.(*User)
+		if !ok || u.GroupID == Admin.ID || u.SuperAdmin {
+			return false, nil
+		}
+		return Manages(s, sub.SubjectID(), u.ID)
+	},
+	SQL: func(sub policy.Subject) squirrel.Sqlizer {
+		users, args := managedUsers(sub.SubjectID())
+		return squirrel.And{
+			squirrel.Expr("ID in ("+users+")", args...),
+			squirrel.NotEq{"GroupID": Admin.ID},
+			squirrel.Eq{"SuperAdmin": false},
+		}
+	},
+}
+
+// managedTeam matches the teams the subject manages.
+var managedTeam = &policy.Condition{
+	Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+		t, ok := record.(*Team)
+		if !ok {
+			return false, nil
+		}
+		return ManagesTeam(s, sub.SubjectID(), t.ID)
+	},
+	SQL: func(sub policy.Subject) squirrel.Sqlizer {
+		teams, args := managedTeams(sub.SubjectID())
+		return squirrel.Expr("ID in ("+teams+")", args...)
+	},
+}
+
+func init() {
+	policy.Scope(TableNameTeam, policy.Tenant("OrgID", func(s gorp.SqlExecutor, record interface{}) (int64, error) {
+		if t, ok := record.(*Team); ok {
+			return t.OrgID, nil
+		}
+		return 0, nil
+	}))
+	policy.Register(TableNameTeam,
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadAllUsers.Name)},
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(ReadTeamUsers.Name), Resource: managedTeam},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(WriteAllUsers.Name)},
+
+		// managers may move the users they manage between their teams, but
+		// only those who manage every user appoint managers
+		policy.Rule{Action: ActionManageMembers, Subject: policy.Permission(WriteAllUsers.Name)},
+		policy.Rule{Action: ActionManageMembers, Subject: policy.Permission(WriteTeamUsers.Name), Resource: managedTeam},
+		policy.Rule{Action: ActionAssignManagers, Subject: policy.Permission(WriteAllUsers.Name)},
+	)
+}
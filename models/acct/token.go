@@ -10,6 +10,7 @@ import (
 	"github.com/ShaleApps/gator"
 	"github.com/dchest/uniuri"
 	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
 	"github.com/rahul2393/small-assignment-server/milli"
 	"github.com/rahul2393/small-assignment-server/models/basemodel"
 )
@@ -29,6 +30,14 @@ type Token struct {
 	Value      string `db:"-"`
 	Hash       string `gator:"nonzero"`
 	Expiration int64  `gator:"nonzero"`
+	// FamilyID groups the access and refresh tokens rotated from the same login.
+	FamilyID string
+	// LastUsed is the last time the token authenticated a request.
+	LastUsed  int64
+	UserAgent string
+	IP        string
+	// ImpersonatorID is the admin acting as the user with the token.
+	ImpersonatorID int64
 }
 
 func (t *Token) String() string {
@@ -50,15 +59,18 @@ func (t *Token) HasValue(value string) bool {
 func (t *Token) PreInsert(s gorp.SqlExecutor) error {
 	t.Created = milli.Timestamp(time.Now())
 	t.Updated = milli.Timestamp(time.Now())
-	value := uniuri.NewLen(15)
-	hash, err := hash(value)
+	value, hash, err := newSecret()
 	if err != nil {
-		return errors.New("Error in creating token hash value")
+		return err
 	}
 	t.Value = value
 	t.Hash = hash
-	ex := time.Now().AddDate(0, 0, 7)
-	t.Expiration = milli.Timestamp(ex)
+	t.LastUsed = t.Created
+	lifetime := conf.Get().Auth.AccessTokenLifetime()
+	if t.ImpersonatorID != 0 {
+		lifetime = conf.Get().Auth.ImpersonationLifetime()
+	}
+	t.Expiration = milli.Timestamp(time.Now().Add(lifetime))
 	if err := gator.NewStruct(t).Validate(); err != nil {
 		return errors.Wrap(err, "error in validating access-token")
 	}
@@ -78,6 +90,25 @@ func (t *Token) TableName() string {
 	return TableNameToken
 }
 
+// sessionCacheDuration returns how long an authenticated session may be
+// cached without outliving its token.
+func sessionCacheDuration(expiration int64) time.Duration {
+	d := milli.Time(expiration).Sub(time.Now())
+	if d > time.Hour {
+		return time.Hour
+	}
+	return d
+}
+
+func newSecret() (value, hashed string, err error) {
+	value = uniuri.NewLen(15)
+	hashed, err = hash(value)
+	if err != nil {
+		return "", "", errors.New("Error in creating token hash value")
+	}
+	return value, hashed, nil
+}
+
 // SplitToken seperates the token encoded in the query string into its value and id.
 // An error is returned the token format is invalid.
 func SplitToken(token string) (value, id string, err error) {
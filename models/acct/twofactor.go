@@ -0,0 +1,278 @@
+package acct
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/dchest/uniuri"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/totp"
+)
+
+const (
+	// TableNameRecoveryCode is the name of the recovery code sql table.
+	TableNameRecoveryCode = "recovery_codes"
+	// TableNameGroupSetting is the name of the group setting sql table.
+	TableNameGroupSetting = "group_settings"
+
+	twoFactorIssuer        = "Small-Assignment"
+	cacheKeyLoginChallenge = "login-challenge"
+	challengeLifetime      = 5 * time.Minute
+	maxChallengeAttempts   = 5
+	recoveryCodeCount      = 10
+	recoveryCodeLength     = 10
+)
+
+var (
+	recoveryCodeChars = []byte("abcdefghjkmnpqrstuvwxyz23456789")
+
+	// ErrInvalidTwoFactorCode occurs when a one-time code is incorrect.
+	ErrInvalidTwoFactorCode = httperr.New(
+		http.StatusUnauthorized,
+		"Invalid two-factor authentication code",
+		errors.New("acct: invalid two-factor code"))
+)
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the
+// user has lost their authenticator.
+type RecoveryCode struct {
+	basemodel.BaseModel
+
+	UserID int64  `gator:"nonzero"`
+	Hash   string `gator:"nonzero"`
+	Used   bool
+}
+
+func (c *RecoveryCode) PreInsert(s gorp.SqlExecutor) error {
+	c.Created = milli.Timestamp(time.Now())
+	c.Updated = milli.Timestamp(time.Now())
+	return nil
+}
+
+func (c *RecoveryCode) TableName() string {
+	return TableNameRecoveryCode
+}
+
+// GroupSetting holds the settings admins can change for a group.
+type GroupSetting struct {
+	GroupID          int64 `json:"groupID"`
+	RequireTwoFactor bool  `json:"requireTwoFactor"`
+	Updated          int64 `json:"updated"`
+}
+
+func (g *GroupSetting) TableName() string {
+	return TableNameGroupSetting
+}
+
+// GroupRequiresTwoFactor returns whether or not members of the group
+// must use two-factor authentication.
+func GroupRequiresTwoFactor(s gorp.SqlExecutor, groupID int64) (bool, error) {
+	// groups without settings don't require two-factor authentication
+	required, err := s.SelectInt(
+		"select RequireTwoFactor from "+TableNameGroupSetting+" where GroupID = ?", groupID)
+	if err != nil {
+		return false, errors.Wrap(err, "acct: error in finding group setting")
+	}
+	return required == 1, nil
+}
+
+// SetGroupRequiresTwoFactor sets whether or not members of the group
+// must use two-factor authentication.
+func SetGroupRequiresTwoFactor(s gorp.SqlExecutor, groupID int64, required bool) error {
+	_, err := s.Exec("insert into "+TableNameGroupSetting+" (GroupID, RequireTwoFactor, Updated) values (?, ?, ?) "+
+		"on duplicate key update RequireTwoFactor = values(RequireTwoFactor), Updated = values(Updated)",
+		groupID, required, milli.Timestamp(time.Now()))
+	return errors.Wrap(err, "acct: error in saving group setting")
+}
+
+// RequiresTwoFactor returns whether or not the user has to supply a
+// one-time code to login.
+func (u *User) RequiresTwoFactor(s gorp.SqlExecutor) (bool, error) {
+	if u.TwoFactorEnabled {
+		return true, nil
+	}
+	return GroupRequiresTwoFactor(s, u.GroupID)
+}
+
+// TwoFactorSetup is the secret an authenticator app is enrolled with.
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// SetupTwoFactor generates and saves a new pending secret.  Two-factor
+// authentication is enabled once a code of the secret is verified.
+func (u *User) SetupTwoFactor(s gorp.SqlExecutor) (*TwoFactorSetup, error) {
+	if u.TwoFactorEnabled {
+		err := errors.New("acct: two-factor authentication already enabled")
+		return nil, httperr.New(http.StatusBadRequest, "Two-factor authentication is already enabled", err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	u.TwoFactorSecret = secret
+	u.TwoFactorLastStep = 0
+	if _, err := s.Update(u); err != nil {
+		return nil, errors.Wrap(err, "acct: error in saving two-factor secret")
+	}
+	return &TwoFactorSetup{
+		Secret: secret,
+		URI:    totp.URI(twoFactorIssuer, u.Email, secret),
+	}, nil
+}
+
+// EnableTwoFactor enables two-factor authentication if the code is valid
+// for the pending secret.  Fresh recovery codes are returned.
+func (u *User) EnableTwoFactor(s gorp.SqlExecutor, code string) ([]string, error) {
+	if u.TwoFactorEnabled {
+		err := errors.New("acct: two-factor authentication already enabled")
+		return nil, httperr.New(http.StatusBadRequest, "Two-factor authentication is already enabled", err)
+	}
+	if u.TwoFactorSecret == "" {
+		err := errors.New("acct: two-factor authentication not set up")
+		return nil, httperr.New(http.StatusBadRequest, "Two-factor authentication must be set up first", err)
+	}
+	if ok, err := u.validateTOTP(s, code); err != nil || !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	u.TwoFactorEnabled = true
+	if _, err := s.Update(u); err != nil {
+		return nil, errors.Wrap(err, "acct: error in enabling two-factor authentication")
+	}
+	return u.generateRecoveryCodes(s)
+}
+
+// DisableTwoFactor disables two-factor authentication and removes the
+// secret and recovery codes.
+func (u *User) DisableTwoFactor(s gorp.SqlExecutor) error {
+	u.TwoFactorEnabled = false
+	u.TwoFactorSecret = ""
+	u.TwoFactorLastStep = 0
+	if _, err := s.Update(u); err != nil {
+		return errors.Wrap(err, "acct: error in disabling two-factor authentication")
+	}
+	if _, err := s.Exec("delete from "+TableNameRecoveryCode+" where UserID = ?", u.ID); err != nil {
+		return errors.Wrap(err, "acct: error in deleting recovery codes")
+	}
+	return nil
+}
+
+// VerifyTwoFactor returns whether or not the code is a valid TOTP code
+// or an unused recovery code.  Either kind of code can only be used once.
+func (u *User) VerifyTwoFactor(s gorp.SqlExecutor, code string) (bool, error) {
+	if !u.TwoFactorEnabled {
+		return false, nil
+	}
+	if ok, err := u.validateTOTP(s, code); err != nil || ok {
+		return ok, err
+	}
+	return u.useRecoveryCode(s, code)
+}
+
+func (u *User) validateTOTP(s gorp.SqlExecutor, code string) (bool, error) {
+	step, ok := totp.Validate(u.TwoFactorSecret, code, time.Now())
+	// reject replays of a code that was already accepted
+	if !ok || step <= u.TwoFactorLastStep {
+		return false, nil
+	}
+	u.TwoFactorLastStep = step
+	if _, err := s.Update(u); err != nil {
+		return false, errors.Wrap(err, "acct: error in saving two-factor step")
+	}
+	return true, nil
+}
+
+func (u *User) useRecoveryCode(s gorp.SqlExecutor, code string) (bool, error) {
+	var codes []*RecoveryCode
+	query, args, _ := squirrel.Select("*").
+		From(TableNameRecoveryCode).
+		Where(squirrel.Eq{"UserID": u.ID, "Used": false}).ToSql()
+	if _, err := s.Select(&codes, query, args...); err != nil {
+		return false, errors.Wrap(err, "acct: error in finding recovery codes")
+	}
+	for _, c := range codes {
+		if !validPassword(code, c.Hash) {
+			continue
+		}
+		c.Used = true
+		c.Updated = milli.Timestamp(time.Now())
+		if _, err := s.Update(c); err != nil {
+			return false, errors.Wrap(err, "acct: error in using recovery code")
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (u *User) generateRecoveryCodes(s gorp.SqlExecutor) ([]string, error) {
+	if _, err := s.Exec("delete from "+TableNameRecoveryCode+" where UserID = ?", u.ID); err != nil {
+		return nil, errors.Wrap(err, "acct: error in deleting recovery codes")
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := uniuri.NewLenChars(recoveryCodeLength, recoveryCodeChars)
+		h, err := hash(code)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Insert(&RecoveryCode{UserID: u.ID, Hash: h}); err != nil {
+			return nil, errors.Wrap(err, "acct: error in inserting recovery code")
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// LoginChallenge is handed out instead of a token when a user with
+// two-factor authentication logs in.  The challenge is exchanged for a
+// token with a valid code before it expires.
+type LoginChallenge struct {
+	Challenge     string `json:"challenge"`
+	Expiration    int64  `json:"challengeExpiration"`
+	SetupRequired bool   `json:"twoFactorSetupRequired"`
+	UserID        int64  `json:"-"`
+
+	attempts int32
+}
+
+// NewLoginChallenge creates a challenge for the user whose password
+// was verified.
+func NewLoginChallenge(u *User) *LoginChallenge {
+	c := &LoginChallenge{
+		Challenge:     uniuri.NewLen(32),
+		Expiration:    milli.Timestamp(time.Now().Add(challengeLifetime)),
+		SetupRequired: !u.TwoFactorEnabled,
+		UserID:        u.ID,
+	}
+	cache.Set(cacheKeyLoginChallenge, c.Challenge, cache.Item{Src: c, Duration: challengeLifetime})
+	return c
+}
+
+// LoginChallengeFor returns the pending challenge.  Every lookup counts as
+// an attempt and the challenge is discarded after too many attempts.
+func LoginChallengeFor(challenge string) (*LoginChallenge, error) {
+	src, in := cache.Get(cacheKeyLoginChallenge, challenge)
+	if !in || challenge == "" {
+		return nil, errInvalidAuth(errors.New("acct: login challenge not found or expired"))
+	}
+	c := src.(*LoginChallenge)
+	if atomic.AddInt32(&c.attempts, 1) > maxChallengeAttempts {
+		c.Complete()
+		return nil, errInvalidAuth(errors.New("acct: too many login challenge attempts"))
+	}
+	return c, nil
+}
+
+// Complete discards the challenge so it can't be used again.
+func (c *LoginChallenge) Complete() {
+	cache.Delete(cacheKeyLoginChallenge, c.Challenge)
+}
@@ -12,13 +12,17 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rahul2393/small-assignment-server/cache"
 	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/logger"
 	"github.com/rahul2393/small-assignment-server/milli"
 	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/pwpolicy"
 )
 
 const (
 	// ModelNameUser is the name of the user model.
 	ModelNameUser = "User"
+	// CacheKeySession is the cache namespace of authenticated tokens.
+	CacheKeySession = "session"
 )
 
 type Verifier interface {
@@ -32,13 +36,38 @@ type User struct {
 	Name                   string `json:"name"`
 	Password               string `db:"-" json:"password,omitempty"`
 	ExpectedCaloriesPerDay int64  `json:"expectedCaloriesPerDay"`
+	EmailVerified          bool   `json:"emailVerified"`
 
 	Token        string `db:"-" json:"token,omitempty"`
 	PasswordHash string `json:"-"`
 
-	TokenExpiration int64  `db:"-" json:"tokenExpiration"`
-	GroupID         int64  `db:"groupID" json:"-"`
-	Group           *Group `db:"-" json:"group,omitempty"`
+	TokenExpiration        int64  `db:"-" json:"tokenExpiration"`
+	RefreshToken           string `db:"-" json:"refreshToken,omitempty"`
+	RefreshTokenExpiration int64  `db:"-" json:"refreshTokenExpiration,omitempty"`
+	GroupID                int64  `db:"groupID" json:"-"`
+	Group                  *Group `db:"-" json:"group,omitempty"`
+	OrgID                  int64  `json:"orgID"`
+	// SuperAdmin users manage organizations and aren't confined to their
+	// own.
+	SuperAdmin bool `json:"superAdmin"`
+
+	TwoFactorEnabled  bool     `json:"twoFactorEnabled"`
+	TwoFactorSecret   string   `json:"-"`
+	TwoFactorLastStep int64    `json:"-"`
+	RecoveryCodes     []string `db:"-" json:"recoveryCodes,omitempty"`
+
+	// APIKeyID is the api key the request was authenticated with, if any.
+	APIKeyID int64 `db:"-" json:"-"`
+	// Impersonator is the admin acting as the user, if any.
+	Impersonator *User `db:"-" json:"impersonator,omitempty"`
+
+	// passwordChanged is set by SetPassword until the password is saved
+	// to the history.
+	passwordChanged bool   `db:"-"`
+	previousHash    string `db:"-"`
+	// emailChanged is set for new and changed addresses until their
+	// verification email is sent by PostCommit.
+	emailChanged bool `db:"-"`
 }
 
 func (u *User) Merge(src interface{}) error {
@@ -48,9 +77,16 @@ func (u *User) Merge(src interface{}) error {
 	}
 
 	u.Name = from.Name
-	u.Email = from.Email
+	if email := strings.ToLower(from.Email); email != u.Email {
+		// the new address is confirmed before the user may make changes
+		u.Email = email
+		u.EmailVerified = false
+		u.emailChanged = true
+	}
 	if from.Password != "" {
-		u.SetPassword(from.Password)
+		if err := u.SetPassword(from.Password); err != nil {
+			return err
+		}
 	}
 	u.ExpectedCaloriesPerDay = from.ExpectedCaloriesPerDay
 	return nil
@@ -72,28 +108,49 @@ func (u *User) PreInsert(s gorp.SqlExecutor) error {
 	if u.Password == "" {
 		return errors.New("auth: user requires password")
 	}
+	if u.OrgID == 0 {
+		u.OrgID = DefaultOrgID
+	}
+	u.emailChanged = !u.EmailVerified
 	if err := gator.NewStruct(u).Validate(); err != nil {
 		return errors.Wrap(err, "error in validating user")
 	}
 	return nil
 }
 
+// PostInsert implements the gorp.HasPostInsert interface.
+func (u *User) PostInsert(s gorp.SqlExecutor) error {
+	if u.passwordChanged {
+		return u.recordPassword(s)
+	}
+	return nil
+}
+
 func (u *User) PostUpdate(s gorp.SqlExecutor) error {
-	//update cache
-	for key := range cache.GetAll() {
-		cacheKeys := strings.Split(key, ":")
-		cacheEmail := cacheKeys[0]
-		cacheToken := cacheKeys[1]
-		if len(cacheKeys) == 3 {
-			cacheToken = cacheToken + ":" + cacheKeys[2]
-		}
-		if cacheEmail == u.Email {
-			cache.Set(cacheEmail, cacheToken, cache.Item{Src: u})
+	if u.passwordChanged {
+		if err := u.recordPassword(s); err != nil {
+			return err
 		}
 	}
+	// cached sessions are loaded again from the database, which only holds
+	// the update once it's committed
+	deleteCachedSessions(u.ID)
 	return nil
 }
 
+// PostCommit implements the basemodel.Committer interface.  The
+// verification email of a new address is only sent once the address is
+// stored; failures are logged since the user can ask for another.
+func (u *User) PostCommit() {
+	if !u.emailChanged {
+		return
+	}
+	u.emailChanged = false
+	if err := u.SendVerificationEmail(); err != nil {
+		logger.ErrorWithMsg("acct: error in sending verification email", err)
+	}
+}
+
 func (u *User) Delete(s gorp.SqlExecutor) error {
 	u.Deleted = true
 	return nil
@@ -101,20 +158,34 @@ func (u *User) Delete(s gorp.SqlExecutor) error {
 
 func (u *User) PreUpdate(s gorp.SqlExecutor) error {
 	u.Updated = milli.Timestamp(time.Now())
+	if u.passwordChanged {
+		if err := u.checkPasswordHistory(s); err != nil {
+			return err
+		}
+	}
 	if err := gator.NewStruct(u).Validate(); err != nil {
 		return errors.Wrap(err, "error in validating user")
 	}
 	return nil
 }
 
-// SetPassword sets the password and password hash.
+// SetPassword sets the password and password hash if the password
+// satisfies the password policy.  The password history is checked when
+// the user is updated.
 func (u *User) SetPassword(p string) error {
+	if violations := pwpolicy.Default().Check(p); len(violations) > 0 {
+		return errPasswordPolicy(violations)
+	}
 	h, err := hash(p)
 	if err != nil {
 		return errors.Wrap(err, "error in creating hash of password")
 	}
+	if !u.passwordChanged {
+		u.previousHash = u.PasswordHash
+	}
 	u.Password = p
 	u.PasswordHash = h
+	u.passwordChanged = true
 	return nil
 }
 
@@ -131,66 +202,73 @@ func errInvalidAuth(err error) error {
 		err)
 }
 
+// Authenticate returns the user owning the token.  The email is
+// optional, but when supplied it must belong to the token's owner.
 func Authenticate(
 	s gorp.SqlExecutor,
 	email, token string) (*User, error) {
 	// check if credentials are provided
 	email = strings.ToLower(email)
-	if email == "" || token == "" {
+	if token == "" {
 		return nil, errInvalidAuth(errors.New("account: authentication credentials missing"))
 	}
-
-	if src, in := cache.Get(email, token); in {
-		return src.(*User), nil
-	}
 	// handleErr filters 401 or 500 error
 	handleErr := func(err error) (*User, error) {
 		return nil, errInvalidAuth(err)
 	}
+	if src, in := cache.Get(CacheKeySession, token); in {
+		user := src.(*User)
+		if email != "" && user.Email != email {
+			return handleErr(errors.New("acct: token doesn't belong to email"))
+		}
+		if id, err := TokenID(token); err == nil {
+			touchToken(s, id)
+		}
+		return user, nil
+	}
+	// verify the token with the configured strategy
+	claims, err := Tokens().Decode(s, token)
+	if err != nil {
+		return handleErr(err)
+	}
 	user := &User{}
 	query, args, _ := squirrel.Select("*").
 		From(TableNameUser).
-		Where(squirrel.Eq{"Email": email}).ToSql()
+		Where(squirrel.Eq{"ID": claims.UserID, "Deleted": false}).ToSql()
 	if err := s.SelectOne(user, query, args...); err != nil {
 		return handleErr(err)
 	}
-	// retrieve token from cache or db
-	value, id, err := SplitToken(token)
-	if err != nil {
-		return nil, errInvalidAuth(err)
-	}
-	// retrieve and validate token
-	t := &Token{}
-	query, args, _ = squirrel.Select("*").
-		From(TableNameToken).
-		Where(squirrel.Eq{"ID": id}).ToSql()
-	if err := s.SelectOne(t, query, args...); err != nil {
-		return handleErr(err)
-	}
-	if t.Expired() {
-		return handleErr(errors.New("acct: token is expired"))
+	if claims.GroupID != 0 && claims.GroupID != user.GroupID {
+		return handleErr(errors.New("acct: token was issued for another group"))
 	}
-	if !t.HasValue(value) {
-		return handleErr(errors.New("acct: token's value is incorrect"))
+	if email != "" && user.Email != email {
+		return handleErr(errors.New("acct: token doesn't belong to email"))
 	}
 	if err := user.Expand(s, ""); err != nil {
 		return nil, errors.Wrap(err, "acct: error in expanding user")
 	}
-	item := cache.Item{Src: user, Duration: time.Hour}
-	cache.Set(email, token, item)
+	if claims.ImpersonatorID != 0 {
+		if err := user.setImpersonator(s, claims.ImpersonatorID); err != nil {
+			return handleErr(err)
+		}
+	}
+	item := cache.Item{Src: user, Duration: sessionCacheDuration(claims.Expiration)}
+	cache.Set(CacheKeySession, token, item)
+	touchToken(s, claims.TokenID)
 	return user, nil
 }
 
+// DeleteCacheSession removes every cached session of the user so the
+// next request has to authenticate against the database again.
 func (u *User) DeleteCacheSession() {
-	for key := range cache.GetAll() {
-		cacheKeys := strings.Split(key, ":")
-		if len(cacheKeys) == 3 {
-			cacheEmail := cacheKeys[0]
-			cacheToken := cacheKeys[1] + ":" + cacheKeys[2]
-			if cacheEmail == u.Email {
-				cache.Delete(cacheEmail, cacheToken)
-			}
+	deleteCachedSessions(u.ID)
+}
+
+func deleteCachedSessions(userID int64) {
+	for token, src := range cache.Items(CacheKeySession) {
+		if cached, ok := src.(*User); ok && (cached.ID == userID || cached.impersonatedBy(userID)) {
+			cache.Delete(CacheKeySession, token)
 		}
 	}
-	return
+	deleteCachedAPIKeys(func(session *apiKeySession) bool { return session.key.UserID == userID })
 }
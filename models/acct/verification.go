@@ -0,0 +1,115 @@
+package acct
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/httperr"
+	"github.com/rahul2393/small-assignment-server/mail"
+	"github.com/rahul2393/small-assignment-server/milli"
+	"github.com/rahul2393/small-assignment-server/sign"
+)
+
+const (
+	purposeVerifyEmail          = "verify-email"
+	cacheKeyEmailSent           = "email-sent"
+	verificationEmailSubject    = "Please verify your email address"
+	verificationEmailBodyFormat = "Hi %s,\n\nPlease confirm your email address by opening the link below.\n\n%s\n\nThe link expires on %s."
+)
+
+// emailClaims are carried by the signed verification link.  The email is
+// included so the link stops working once the email is changed.
+type emailClaims struct {
+	UserID     int64  `json:"uid"`
+	Email      string `json:"email"`
+	Expiration int64  `json:"exp"`
+}
+
+// SendVerificationEmail mails the user a signed link confirming they own
+// their email address.
+func (u *User) SendVerificationEmail() error {
+	exp := time.Now().Add(conf.Get().Auth.EmailVerificationLifetime())
+	token, err := sign.Encode(purposeVerifyEmail, emailClaims{
+		UserID:     u.ID,
+		Email:      u.Email,
+		Expiration: milli.Timestamp(exp),
+	})
+	if err != nil {
+		return err
+	}
+	link := conf.Get().BaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return mail.Send(mail.Message{
+		To:      u.Email,
+		Subject: verificationEmailSubject,
+		Body:    fmt.Sprintf(verificationEmailBodyFormat, u.Name, link, exp.Format(time.RFC1123)),
+	})
+}
+
+// ResendVerificationEmail sends another verification email unless one
+// was sent to the user within the configured interval.
+func (u *User) ResendVerificationEmail() error {
+	if u.EmailVerified {
+		err := errors.New("acct: email already verified")
+		return httperr.New(http.StatusBadRequest, "Email address is already verified", err)
+	}
+	if !allowEmail(purposeVerifyEmail, u.ID) {
+		err := errors.New("acct: verification email rate limited")
+		return httperr.New(http.StatusTooManyRequests, "A verification email was sent recently, please try again later", err)
+	}
+	return u.SendVerificationEmail()
+}
+
+// allowEmail returns whether or not an email of the kind may be sent to
+// the user and, if so, records it so no other is sent within the
+// configured interval.
+func allowEmail(kind string, userID int64) bool {
+	key := kind + ":" + strconv.FormatInt(userID, 10)
+	if _, in := cache.Get(cacheKeyEmailSent, key); in {
+		return false
+	}
+	cache.Set(cacheKeyEmailSent, key, cache.Item{Src: true, Duration: conf.Get().Auth.EmailInterval()})
+	return true
+}
+
+// VerifyEmail marks the email of the signed verification token verified.
+func VerifyEmail(s gorp.SqlExecutor, token string) (*User, error) {
+	invalid := func(err error) (*User, error) {
+		return nil, httperr.New(http.StatusBadRequest, "Invalid or expired verification link", err)
+	}
+	claims := &emailClaims{}
+	if err := sign.Decode(purposeVerifyEmail, token, claims); err != nil {
+		return invalid(err)
+	}
+	if time.Now().After(milli.Time(claims.Expiration)) {
+		return invalid(errors.New("acct: verification link expired"))
+	}
+	user := &User{}
+	query, args, _ := squirrel.Select("*").
+		From(TableNameUser).
+		Where(squirrel.Eq{"ID": claims.UserID, "Deleted": false}).ToSql()
+	if err := s.SelectOne(user, query, args...); err != nil {
+		return invalid(err)
+	}
+	if user.Email != claims.Email {
+		return invalid(errors.New("acct: email changed since the link was sent"))
+	}
+	if !user.EmailVerified {
+		user.EmailVerified = true
+		if _, err := s.Update(user); err != nil {
+			return nil, errors.Wrap(err, "acct: error in verifying email")
+		}
+	}
+	if err := user.Expand(s, ""); err != nil {
+		return nil, errors.Wrap(err, "acct: error in expanding user")
+	}
+	return user, nil
+}
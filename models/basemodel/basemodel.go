@@ -30,6 +30,12 @@ type Expander interface {
 	Expand(s gorp.SqlExecutor, exclude string) error
 }
 
+// Committer is told once the changes to the model are committed, to take
+// the actions that can't be rolled back such as sending emails.
+type Committer interface {
+	PostCommit()
+}
+
 type Deleter interface {
 	Delete(s gorp.SqlExecutor) error
 }
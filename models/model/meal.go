@@ -11,6 +11,7 @@ import (
 	"github.com/rahul2393/small-assignment-server/milli"
 	"github.com/rahul2393/small-assignment-server/models/acct"
 	"github.com/rahul2393/small-assignment-server/models/basemodel"
+	"github.com/rahul2393/small-assignment-server/policy"
 )
 
 const (
@@ -22,6 +23,7 @@ type Meal struct {
 	basemodel.BaseModel
 
 	UserID      int64  `json:"userID"`
+	OrgID       int64  `json:"orgID"`
 	Description string `json:"description"`
 	MealTime    int64  `json:"mealTime"`
 	MealDate    int64  `json:"mealDate"`
@@ -57,6 +59,11 @@ func (meal *Meal) PreInsert(s gorp.SqlExecutor) error {
 	if meal.UserID <= 0 {
 		return errors.New("handler: meal requires userID")
 	}
+	orgID, err := mealOrg(s, meal)
+	if err != nil {
+		return err
+	}
+	meal.OrgID = orgID
 	if err := gator.NewStruct(meal).Validate(); err != nil {
 		return errors.Wrap(err, "error in validating meal")
 	}
@@ -85,10 +92,46 @@ func (meal *Meal) Expand(s gorp.SqlExecutor, exclude string) error {
 	return nil
 }
 
-func (meal *Meal) Verify(reqId string, s gorp.SqlExecutor) error {
-	currentUser := acct.GetCurrentRequestUserFromCache(reqId)
-	if currentUser.GroupID != acct.Admin.ID && meal.UserID != currentUser.ID {
-		return errors.Wrapf(errors.New("invalid meal"), "verification failed")
+// mealOwner returns the owner of the meal record.
+func mealOwner(record interface{}) int64 {
+	if meal, ok := record.(*Meal); ok {
+		return meal.UserID
 	}
-	return nil
+	return 0
+}
+
+// mealOrg returns the organization of the meal, which is always the one of
+// its owner.
+func mealOrg(s gorp.SqlExecutor, record interface{}) (int64, error) {
+	meal, ok := record.(*Meal)
+	if !ok {
+		return 0, nil
+	}
+	return acct.UserOrgID(s, meal.UserID)
+}
+
+// sharedMeals matches the meals of the owners sharing with the subject at
+// the permission's level.
+func sharedMeals(p acct.Permission) *policy.Condition {
+	return &policy.Condition{
+		Check: func(s gorp.SqlExecutor, sub policy.Subject, record interface{}) (bool, error) {
+			return acct.HasShare(s, mealOwner(record), sub.SubjectID(), p)
+		},
+		SQL: func(sub policy.Subject) squirrel.Sqlizer {
+			return acct.SharedWith("UserID", sub.SubjectID(), p)
+		},
+	}
+}
+
+func init() {
+	owned := policy.Owner("UserID", mealOwner)
+	policy.Scope(TableNameMeal, policy.Tenant("OrgID", mealOrg))
+	policy.Register(TableNameMeal,
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(acct.ReadAllMeals.Name)},
+		policy.Rule{Action: policy.Read, Subject: policy.Permission(acct.ReadMeals.Name),
+			Resource: policy.Or(owned, sharedMeals(acct.ReadMeals))},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(acct.WriteAllMeals.Name)},
+		policy.Rule{Action: policy.Write, Subject: policy.Permission(acct.WriteMeals.Name),
+			Resource: policy.Or(owned, sharedMeals(acct.WriteMeals))},
+	)
 }
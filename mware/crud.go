@@ -1,10 +1,13 @@
 package mware
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"gopkg.in/gorp.v1"
 
@@ -15,15 +18,10 @@ import (
 	"github.com/rahul2393/small-assignment-server/logger"
 	"github.com/rahul2393/small-assignment-server/models/acct"
 	"github.com/rahul2393/small-assignment-server/models/basemodel"
-	"github.com/rahul2393/small-assignment-server/permission"
+	"github.com/rahul2393/small-assignment-server/policy"
 	"github.com/rahul2393/small-assignment-server/rest"
 )
 
-const (
-	read = iota + 1
-	Write
-)
-
 type Merger interface {
 	Merge(src interface{}) error
 }
@@ -38,21 +36,31 @@ func GetAll(m basemodel.Model) Handler {
 		var (
 			params = r.URL.Query()
 			models []interface{}
-			limit  = rest.UintFromKey(params, rest.KeyLimit, rest.LimitDefault)
+			limit  = rest.Limit(params)
 		)
 		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
-		requiredPermission := user.Group.GetPermission(m.TableName(), read)
-		if requiredPermission == acct.NoPerm {
-			err := errors.New("mware: inadequate permissions for request")
-			return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+		filter, err := policy.Filter(user, policy.Read, m.TableName())
+		if err != nil {
+			return errInadequatePermissions()
 		}
 
-		q, err := rest.Query(m, m.TableName(), params)
+		// fields the user can't read of every record can't be filtered
+		// or sorted by either
+		zero := reflect.New(reflect.TypeOf(m).Elem()).Interface()
+		denied, err := policy.DeniedFields(db, user, policy.Read, m.TableName(), zero)
 		if err != nil {
-			return clientError(err)
+			return httperr.NewInternal(err)
+		}
+
+		// the legacy credentials aren't conditions on the records
+		params.Del(acct.AuthKeyEmail)
+		params.Del(acct.AuthKeyToken)
+		q, err := rest.Query(rest.Without(m, denied), m.TableName(), params, filter)
+		if err != nil {
+			return queryError(err)
 		}
-		q.Query = q.Query.Limit(limit)
-		q.Query = permissions.UserLevelFilter(q.Query, m, user, requiredPermission)
+		// one more record tells whether or not there is a next page
+		q.Query = q.Query.Limit(limit + 1)
 
 		sql, args, _ := q.Query.ToSql()
 		logger.Debug(fmt.Sprintf("sql %s and args %v\n", sql, args))
@@ -61,7 +69,22 @@ func GetAll(m basemodel.Model) Handler {
 		if err != nil {
 			return clientError(err)
 		}
-		if params.Get(rest.KeyExpand) == "true" {
+		models, prev, next, err := q.Page(models, limit)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if params.Get(rest.KeyCount) == "true" {
+			sql, args, _ := q.CountQuery.ToSql()
+			total, err := db.SelectInt(sql, args...)
+			if err != nil {
+				return clientError(err)
+			}
+			w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		}
+		if links := rest.Links(r.URL, prev, next); links != "" {
+			w.Header().Set("Link", links)
+		}
+		if params.Get(rest.KeyExpand) == "true" && q.Fields.Expands() {
 			if _, ok := m.(basemodel.Expander); ok {
 				logger.Debugf("Expanding")
 				for _, m := range models {
@@ -72,45 +95,75 @@ func GetAll(m basemodel.Model) Handler {
 				}
 			}
 		}
-		return json.NewEncoder(w).Encode(models)
+		records := make([]interface{}, len(models))
+		for i, record := range models {
+			if records[i], err = Readable(db, user, m.TableName(), record); err != nil {
+				return httperr.NewInternal(err)
+			}
+			if records[i], err = q.Fields.Narrow(records[i]); err != nil {
+				return httperr.NewInternal(err)
+			}
+		}
+		return json.NewEncoder(w).Encode(records)
 	}
 }
 
 func GetByID(m basemodel.Model) Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
 		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
-		requiredPermission := user.Group.GetPermission(m.TableName(), read)
-		if requiredPermission == acct.NoPerm {
-			err := errors.New("mware: inadequate permissions for request")
-			return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+		if err := permit(user, m, policy.Read); err != nil {
+			return err
 		}
 		values := r.URL.Query()
 		params := mux.Vars(r)
-		if err := GetID(db, user, m, params["id"], requiredPermission); err != nil {
+		// the record is always expanded, so nested fields are too
+		fields, err := rest.ParseFields(m, values.Get(rest.KeyFields), true)
+		if err != nil {
+			return queryError(err)
+		}
+		var columns []string
+		if !fields.Expands() {
+			columns = fields.Columns
+		}
+		if err := GetID(db, user, m, params["id"], policy.Read, columns...); err != nil {
 			return err
 		}
-		if e, ok := m.(basemodel.Expander); ok {
+		if e, ok := m.(basemodel.Expander); ok && fields.Expands() {
 			if err := e.Expand(db, values.Get(rest.KeyExclude)); err != nil {
 				return err
 			}
 		}
-		return json.NewEncoder(w).Encode(m)
+		record, err := Readable(db, user, m.TableName(), m)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if record, err = fields.Narrow(record); err != nil {
+			return httperr.NewInternal(err)
+		}
+		return json.NewEncoder(w).Encode(record)
 	}
 }
 
 func Create(m basemodel.Model) Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
 		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
-		requiredPermission := user.Group.GetPermission(m.TableName(), Write)
-		if requiredPermission == acct.NoPerm {
-			err := errors.New("mware: inadequate permissions for request")
-			return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+		if err := permit(user, m, policy.Write); err != nil {
+			return err
 		}
 
 		mCopy := copyResource(m)
 		if err := json.NewDecoder(r.Body).Decode(mCopy); err != nil {
 			return clientError(err)
 		}
+		// the attributes the request sets
+		zero, err := acct.Snapshot(copyResource(m))
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		set, err := acct.Snapshot(mCopy)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
 		trans, err := db.Begin()
 		if err != nil {
 			message := fmt.Sprintf("%s could not begin create transaction.", m.TableName())
@@ -125,6 +178,8 @@ func Create(m basemodel.Model) Handler {
 			} else {
 				if err = trans.Commit(); err != nil {
 					logger.ErrorMsgf("unable to commit %s create transaction: %s", m.TableName(), err.Error())
+				} else if c, ok := mCopy.(basemodel.Committer); ok {
+					c.PostCommit()
 				}
 			}
 		}()
@@ -135,29 +190,42 @@ func Create(m basemodel.Model) Handler {
 			}
 		}
 
+		allowed, err := policy.Allowed(trans, user, policy.Write, m.TableName(), mCopy)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if !allowed {
+			err = errors.New("mware: policy denies creating the record")
+			return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+		}
+		if err = checkFields(trans, user, mCopy, zero, set); err != nil {
+			return err
+		}
+
 		if err = trans.Insert(mCopy); err != nil {
 			return clientError(err)
 		}
+		if err = audit(trans, r, acct.AuditCreate, mCopy, nil); err != nil {
+			return httperr.NewInternal(err)
+		}
 		if e, ok := mCopy.(basemodel.Expander); ok {
 			if err = e.Expand(db, ""); err != nil {
 				return err
 			}
 		}
-		return json.NewEncoder(w).Encode(mCopy)
+		return encode(w, db, user, mCopy)
 	}
 }
 
 func UpdateByID(m MergeModel) Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
 		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
-		requiredPermission := user.Group.GetPermission(m.TableName(), Write)
-		if requiredPermission == acct.NoPerm {
-			err := errors.New("mware: inadequate permissions for request")
-			return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+		if err := permit(user, m, policy.Write); err != nil {
+			return err
 		}
 		params := mux.Vars(r)
 		mCopy := copyResource(m)
-		err := GetID(db, user, mCopy, params["id"], requiredPermission)
+		err := GetID(db, user, mCopy, params["id"], policy.Write)
 		if err != nil {
 			return err
 		}
@@ -179,6 +247,8 @@ func UpdateByID(m MergeModel) Handler {
 			} else {
 				if err = trans.Commit(); err != nil {
 					logger.ErrorMsgf("unable to commit %s create transaction: %s", m.TableName(), err.Error())
+				} else if c, ok := mCopy.(basemodel.Committer); ok {
+					c.PostCommit()
 				}
 			}
 		}()
@@ -189,35 +259,53 @@ func UpdateByID(m MergeModel) Handler {
 			}
 		}
 
+		before, err := acct.Snapshot(mCopy)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		// field rules apply to the record as it is stored
+		stored := copyResource(m)
+		reflect.ValueOf(stored).Elem().Set(reflect.ValueOf(mCopy).Elem())
 		if merge, ok := mCopy.(MergeModel); ok {
 			if err = merge.Merge(from); err != nil {
+				if e, ok := err.(httperr.Error); ok {
+					return e
+				}
 				return httperr.New(http.StatusBadRequest, err.Error(), err)
 			}
 		}
+		after, err := acct.Snapshot(mCopy)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
+		if err = checkFields(trans, user, stored, before, after); err != nil {
+			return err
+		}
 
 		if _, err = trans.Update(mCopy); err != nil {
 			return clientError(err)
 		}
+		if err = audit(trans, r, acct.AuditUpdate, mCopy, before); err != nil {
+			return httperr.NewInternal(err)
+		}
 		if e, ok := mCopy.(basemodel.Expander); ok {
 			if err = e.Expand(db, ""); err != nil {
 				return err
 			}
 		}
-		return json.NewEncoder(w).Encode(mCopy)
+		return encode(w, db, user, mCopy)
 	}
 }
 
 func DeleteByID(m MergeModel) Handler {
 	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
 		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
-		requiredPermission := user.Group.GetPermission(m.TableName(), Write)
-		if requiredPermission == acct.NoPerm {
-			err := errors.New("mware: inadequate permissions for request")
-			return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+		if err := permit(user, m, policy.Write); err != nil {
+			return err
 		}
 		params := mux.Vars(r)
 		mCopy := copyResource(m)
-		if err := GetID(db, user, mCopy, params["id"], requiredPermission); err != nil {
+		if err := GetID(db, user, mCopy, params["id"], policy.Write); err != nil {
 			return err
 		}
 		trans, err := db.Begin()
@@ -229,6 +317,8 @@ func DeleteByID(m MergeModel) Handler {
 			} else {
 				if err = trans.Commit(); err != nil {
 					logger.ErrorMsgf("unable to commit %s create transaction: %s", m.TableName(), err.Error())
+				} else if c, ok := mCopy.(basemodel.Committer); ok {
+					c.PostCommit()
 				}
 			}
 		}()
@@ -239,6 +329,10 @@ func DeleteByID(m MergeModel) Handler {
 			}
 		}
 
+		before, err := acct.Snapshot(mCopy)
+		if err != nil {
+			return httperr.NewInternal(err)
+		}
 		if err = mCopy.Delete(trans); err != nil {
 			return err
 		}
@@ -246,12 +340,15 @@ func DeleteByID(m MergeModel) Handler {
 		if _, err = trans.Update(mCopy); err != nil {
 			return clientError(err)
 		}
+		if err = audit(trans, r, acct.AuditDelete, mCopy, before); err != nil {
+			return httperr.NewInternal(err)
+		}
 		if e, ok := mCopy.(basemodel.Expander); ok {
 			if err = e.Expand(db, ""); err != nil {
 				return err
 			}
 		}
-		return json.NewEncoder(w).Encode(mCopy)
+		return encode(w, db, user, mCopy)
 	}
 }
 
@@ -267,20 +364,263 @@ func FullHttpErrorAndDebug(err error, model, location string) error {
 	return httperr.New(http.StatusUnauthorized, message, err)
 }
 
-func GetID(dbMap *gorp.DbMap, user *acct.User, m basemodel.Model, id interface{}, requiredPermission acct.Permission) error {
-	builder := squirrel.Select("*").
+// permit returns an error unless the user may perform the action on at
+// least some records of the model.  Users who haven't verified their email
+// address may only read.
+func permit(user *acct.User, m basemodel.Model, action policy.Action) error {
+	if !policy.Can(user, action, m.TableName()) {
+		return errInadequatePermissions()
+	}
+	if action == policy.Write {
+		return requireVerified(user)
+	}
+	return nil
+}
+
+// Authorize returns the error the crud handlers of the model respond with
+// when the user performs the action on the record with the id.  Without an
+// id reading is listing and writing is creating records.  Nil means the
+// handlers go ahead; new records are still checked once they are decoded.
+func Authorize(dbMap *gorp.DbMap, user *acct.User, m basemodel.Model, action policy.Action, id string) error {
+	if id != "" {
+		if err := permit(user, m, action); err != nil {
+			return err
+		}
+		return GetID(dbMap, user, copyResource(m), id, action)
+	}
+	if action == policy.Read {
+		if _, err := policy.Filter(user, policy.Read, m.TableName()); err != nil {
+			return errInadequatePermissions()
+		}
+		return nil
+	}
+	return permit(user, m, action)
+}
+
+// GetID selects the record with the id into m if the user may perform the
+// action on it.  Only the columns given are selected, all by default.
+func GetID(s gorp.SqlExecutor, user *acct.User, m basemodel.Model, id interface{}, action policy.Action, columns ...string) error {
+	selected := []string{"*"}
+	if len(columns) > 0 {
+		selected = nil
+		for _, c := range columns {
+			selected = append(selected, m.TableName()+"."+c)
+		}
+	}
+	builder := squirrel.Select(selected...).
 		From(m.TableName()).
 		Where(squirrel.Eq{m.TableName() + ".ID": id})
-	builder = permissions.UserLevelFilter(builder, m, user, requiredPermission)
+	filter, err := policy.Filter(user, action, m.TableName())
+	if err != nil {
+		return errInadequatePermissions()
+	}
+	if filter != nil {
+		builder = builder.Where(filter)
+	}
 	query, args, _ := builder.ToSql()
-	if err := dbMap.SelectOne(m, query, args...); err != nil {
+	if err := s.SelectOne(m, query, args...); err != nil {
 		message := fmt.Sprintf("Could not find %s.", m.TableName())
 		return httperr.New(http.StatusNotFound, message, err)
 	}
 	return nil
 }
 
+// audit records the change the request made to the record.  Before is
+// the snapshot of the record taken before the change, nil for new records.
+func audit(s gorp.SqlExecutor, r *http.Request, action string, m basemodel.Model, before interface{}) error {
+	user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
+	id := reflect.Indirect(reflect.ValueOf(m)).FieldByName("ID").Int()
+	return acct.Audit(s, user, r.Header.Get(requestHeader), action, m.TableName(), id, before, m)
+}
+
+// checkFields returns a 403 error naming the attributes of the record
+// that changed from before to after although field rules deny the user
+// writing them.
+func checkFields(s gorp.SqlExecutor, user *acct.User, m basemodel.Model, before, after json.RawMessage) error {
+	denied, err := policy.DeniedFields(s, user, policy.Write, m.TableName(), m)
+	if err != nil || len(denied) == 0 {
+		return err
+	}
+	from, to := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	if err := json.Unmarshal(before, &from); err != nil {
+		return httperr.NewInternal(err)
+	}
+	if err := json.Unmarshal(after, &to); err != nil {
+		return httperr.NewInternal(err)
+	}
+	var changed []string
+	for _, field := range denied {
+		if !bytes.Equal(from[field], to[field]) {
+			changed = append(changed, field)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	err = fmt.Errorf("mware: user %d may not write %s fields %v", user.ID, m.TableName(), changed)
+	return httperr.NewWithDetails(http.StatusForbidden,
+		"You may not change "+strings.Join(changed, ", ")+".", err, changed)
+}
+
+// Readable returns the record, without the attributes field rules deny
+// the user reading, in the record itself and in the records nested in it
+// such as the users of expanded records.  Slices of records are made
+// readable one by one.
+func Readable(s gorp.SqlExecutor, user *acct.User, resource string, record interface{}) (interface{}, error) {
+	if v := reflect.ValueOf(record); v.Kind() == reflect.Slice {
+		records := make([]interface{}, v.Len())
+		for i := range records {
+			var err error
+			if records[i], err = Readable(s, user, resource, v.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+		return records, nil
+	}
+	denied, err := policy.DeniedFields(s, user, policy.Read, resource, record)
+	if err != nil {
+		return nil, err
+	}
+	nested, err := readableNested(s, user, record)
+	if err != nil || len(denied) == 0 && len(nested) == 0 {
+		return record, err
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.Wrap(err, "mware: error in encoding record")
+	}
+	attrs := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, errors.Wrap(err, "mware: error in decoding record")
+	}
+	for _, field := range denied {
+		delete(attrs, field)
+	}
+	for field, value := range nested {
+		if attrs[field], err = json.Marshal(value); err != nil {
+			return nil, errors.Wrap(err, "mware: error in encoding record")
+		}
+	}
+	return attrs, nil
+}
+
+// readableNested returns, by their json names, the records nested in the
+// fields of the record that field rules restrict for the user, readable.
+// The nested records are the resources of their table names.
+func readableNested(s gorp.SqlExecutor, user *acct.User, record interface{}) (map[string]interface{}, error) {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if v.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	var nested map[string]interface{}
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || field.Anonymous || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		var elems []reflect.Value
+		switch {
+		case value.Kind() == reflect.Ptr && !value.IsNil() && value.Elem().Kind() == reflect.Struct:
+			elems = []reflect.Value{value}
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Ptr:
+			for j := 0; j < value.Len(); j++ {
+				elems = append(elems, value.Index(j))
+			}
+		default:
+			continue
+		}
+		restricted := false
+		records := make([]interface{}, len(elems))
+		for j, elem := range elems {
+			if elem.IsNil() {
+				continue
+			}
+			resource := ""
+			if m, ok := elem.Interface().(basemodel.Model); ok {
+				resource = m.TableName()
+			}
+			readable, err := Readable(s, user, resource, elem.Interface())
+			if err != nil {
+				return nil, err
+			}
+			// records the rules don't restrict are returned as they are
+			if _, ok := readable.(map[string]json.RawMessage); ok {
+				restricted = true
+			}
+			records[j] = readable
+		}
+		if !restricted {
+			continue
+		}
+		if nested == nil {
+			nested = map[string]interface{}{}
+		}
+		if value.Kind() == reflect.Ptr {
+			nested[name] = records[0]
+		} else {
+			nested[name] = records
+		}
+	}
+	return nested, nil
+}
+
+// encode writes the record the user may read.
+func encode(w http.ResponseWriter, s gorp.SqlExecutor, user *acct.User, m basemodel.Model) error {
+	return EncodeReadable(w, s, user, m.TableName(), m)
+}
+
+// EncodeReadable writes what the user may read of the records of the
+// resource, see Readable.
+func EncodeReadable(w http.ResponseWriter, s gorp.SqlExecutor, user *acct.User, resource string, record interface{}) error {
+	record, err := Readable(s, user, resource, record)
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	return json.NewEncoder(w).Encode(record)
+}
+
+// requireVerified restricts users who haven't verified their email
+// address to reading data.
+func requireVerified(user *acct.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+	err := errors.New("mware: email address not verified")
+	return httperr.New(http.StatusForbidden, "Please verify your email address before making changes.", err)
+}
+
+func errInadequatePermissions() error {
+	err := errors.New("mware: inadequate permissions for request")
+	return httperr.New(http.StatusForbidden, "Inadequate permissions for request.", err)
+}
+
+// queryError points the client at the problems with its query parameters.
+func queryError(err error) error {
+	switch e := errors.Cause(err).(type) {
+	case *rest.FilterError:
+		message := fmt.Sprintf("Invalid filter: %s at position %d.", e.Message, e.Position)
+		return httperr.NewWithDetails(http.StatusBadRequest, message, err, e)
+	case rest.ParamErrors:
+		messages := make([]string, len(e))
+		for i, p := range e {
+			messages[i] = p.Param + ": " + p.Message
+		}
+		message := fmt.Sprintf("Invalid query parameters: %s.", strings.Join(messages, "; "))
+		return httperr.NewWithDetails(http.StatusBadRequest, message, err, e)
+	}
+	return clientError(err)
+}
+
 func clientError(err error) error {
+	// errors of the model's hooks, such as password policy violations,
+	// are already meant for the client
+	if e, ok := errors.Cause(err).(httperr.Error); ok {
+		return e
+	}
 	message := "Problem performing request.  Please alert the Account owner if the problem continues."
 	return httperr.New(http.StatusBadRequest, message, err)
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/satori/go.uuid"
+	"github.com/urfave/negroni"
 	"github.com/rahul2393/small-assignment-server/dbutil"
 	"github.com/rahul2393/small-assignment-server/httperr"
 	"github.com/rahul2393/small-assignment-server/logger"
@@ -13,9 +14,6 @@ import (
 )
 
 const (
-	authKeyEmail = "auth-email"
-	authKeyToken = "auth-token"
-
 	requestHeader = "X-Request-Id"
 )
 
@@ -63,72 +61,97 @@ func (m BaseMiddleWare) ServeHTTP(w http.ResponseWriter, r *http.Request, next h
 	}
 }
 
+// UserAuth authenticates the request with the credentials extracted by
+// acct.CredentialsFromRequest and maps the user to the request id.
 func UserAuth() BaseMiddleWare {
 	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) error {
-		v := r.URL.Query()
-		requestId := ""
-		if r.Header.Get(requestHeader) == "" {
-			reqId, _ := uuid.NewV4()
-			requestId = fmt.Sprintf("%s", reqId)
-			r.Header.Set(requestHeader, requestId)
-		} else {
-			requestId = r.Header.Get(requestHeader)
-		}
-		email := v.Get(authKeyEmail)
-		token := v.Get(authKeyToken)
-		if email == "" || token == "" {
-			err := fmt.Errorf("please provide %s and %s", authKeyEmail, authKeyToken)
-			err = httperr.New(http.StatusUnauthorized, "Incomplete details for request", err)
-			return err
-		}
-		logger.Debugf("user auth middleware")
 		db, err := dbutil.DB()
 		if err != nil {
 			return err
 		}
-		//// authenticate user
-		user, err := acct.Authenticate(db, email, token)
-		if err != nil {
-			return err
-		}
-		//// setup request mapping
-		acct.ReqSetUser(requestId, user)
-		next(w, r)
-		// clean up request
-		acct.ReqDeleteUser(requestId)
-		return nil
+		return authenticate(db, w, r, next)
 	}
 }
 
+// TestUserAuth is UserAuth for the given database.
 func TestUserAuth(db *gorp.DbMap) BaseMiddleWare {
 	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) error {
-		v := r.URL.Query()
-		requestId := ""
-		if r.Header.Get(requestHeader) == "" {
-			reqId, _ := uuid.NewV4()
-			requestId = fmt.Sprintf("%s", reqId)
-			r.Header.Set(requestHeader, requestId)
-		} else {
-			requestId = r.Header.Get(requestHeader)
-		}
-		email := v.Get(authKeyEmail)
-		token := v.Get(authKeyToken)
-		if email == "" || token == "" {
-			err := fmt.Errorf("please provide %s and %s", authKeyEmail, authKeyToken)
-			err = httperr.New(http.StatusUnauthorized, "Incomplete details for request", err)
-			return err
+		return authenticate(db, w, r, next)
+	}
+}
+
+// SessionOnly rejects requests authenticated with an api key.  Api keys
+// are scoped to the permissions checked by the crud handlers, so every
+// other handler under /api must be wrapped.
+func SessionOnly(h Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
+		if user != nil && user.APIKeyID != 0 {
+			err := fmt.Errorf("mware: api key %d used outside of its scope", user.APIKeyID)
+			return httperr.New(http.StatusForbidden, "API keys can't be used for this request.", err)
 		}
-		logger.Debugf("user auth middleware")
-		//// authenticate user
-		user, err := acct.Authenticate(db, email, token)
-		if err != nil {
-			return err
+		return h(w, r, db)
+	}
+}
+
+// NotImpersonated rejects requests of users an admin is acting as.
+// Handlers changing credentials, security settings or sessions must be
+// wrapped so impersonation can't outlive its session.
+func NotImpersonated(h Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request, db *gorp.DbMap) error {
+		user := acct.GetCurrentRequestUserFromCache(r.Header.Get(requestHeader))
+		if user != nil && user.Impersonator != nil {
+			err := fmt.Errorf("mware: user %d impersonated by %d", user.ID, user.Impersonator.ID)
+			return httperr.New(http.StatusForbidden, "This request can't be made while impersonating a user.", err)
 		}
-		//// setup request mapping
-		acct.ReqSetUser(requestId, user)
-		next(w, r)
-		// clean up request
-		acct.ReqDeleteUser(requestId)
-		return nil
+		return h(w, r, db)
+	}
+}
+
+func authenticate(db gorp.SqlExecutor, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) error {
+	// the request id maps the request to its user and is recorded in the
+	// audit log, so it is never taken from the client
+	reqId, _ := uuid.NewV4()
+	requestId := fmt.Sprintf("%s", reqId)
+	r.Header.Set(requestHeader, requestId)
+	if creds := acct.CredentialsFromRequest(r); creds.Token == "" && creds.APIKey == "" {
+		err := fmt.Errorf("please provide an %s bearer token", acct.HeaderAuthorization)
+		err = httperr.New(http.StatusUnauthorized, "Incomplete details for request", err)
+		return err
+	}
+	logger.Debugf("user auth middleware")
+	//// authenticate user
+	user, err := acct.AuthenticateRequest(db, r)
+	if err != nil {
+		return err
+	}
+	//// setup request mapping
+	acct.ReqSetUser(requestId, user)
+	// clean up request
+	defer acct.ReqDeleteUser(requestId)
+	if user.Impersonator != nil {
+		return impersonated(db, user, w, r, next)
+	}
+	next(w, r)
+	return nil
+}
+
+// impersonated handles the request of an impersonated user.  The request
+// is recorded before it is handled, so requests that can't be recorded
+// are rejected.
+func impersonated(db gorp.SqlExecutor, user *acct.User, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) error {
+	tokenID, _ := acct.TokenID(acct.CredentialsFromRequest(r).Token)
+	entry, err := acct.LogImpersonation(db, user, tokenID, r)
+	if err != nil {
+		return httperr.NewInternal(err)
+	}
+	next(w, r)
+	status := http.StatusOK
+	if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		status = rw.Status()
+	}
+	if err := entry.SetStatus(db, status); err != nil {
+		logger.ErrorWithMsg("mware: error in recording impersonated request", err)
 	}
+	return nil
 }
@@ -15,7 +15,8 @@ import (
 )
 
 const (
-	testURL = "/foo?auth-email=test@gmail.com&&auth-token=%s"
+	testURL      = "/foo"
+	testQueryURL = "/foo?auth-email=%s&auth-token=%s"
 )
 
 func TestUserAuthUnauthorized(t *testing.T) {
@@ -43,21 +44,80 @@ func TestUserAuthExpiredToken(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, token.Expired())
 
-	rec := doRequest(t, token, db)
+	rec := doRequest(t, token, "", db)
 	assert.Equal(t, http.StatusUnauthorized, rec.Code)
 }
 
-func doRequest(t *testing.T, token *acct.Token, db *gorp.DbMap) *httptest.ResponseRecorder {
+func TestUserAuthBearerToken(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+
+	user := getTestUser(t, db)
+	token := &acct.Token{UserID: user.ID}
+	assert.NoError(t, db.Insert(token))
+
+	rec := doRequest(t, token, "", db)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(t, token, user.Email, db)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the email header must belong to the token's owner
+	rec = doRequest(t, token, "test@gmail.com", db)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUserAuthQueryToken(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+
+	user := getTestUser(t, db)
+	token := &acct.Token{UserID: user.ID}
+	assert.NoError(t, db.Insert(token))
+
+	// query string credentials are disabled unless allow_query_token is set
+	req, err := http.NewRequest("GET", fmt.Sprintf(testQueryURL, user.Email, token.String()), nil)
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	TestUserAuth(db).ServeHTTP(rec, req, func(w http.ResponseWriter, r *http.Request) {})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUserAuthRequestID(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+
+	user := getTestUser(t, db)
+	token := &acct.Token{UserID: user.ID}
+	assert.NoError(t, db.Insert(token))
+
+	// the request id is generated, whatever the client sends
+	req, err := http.NewRequest("GET", testURL, nil)
+	assert.NoError(t, err)
+	req.Header.Set(acct.HeaderAuthorization, "Bearer "+token.String())
+	req.Header.Set(requestHeader, "chosen by the client")
+	var requestID string
+	rec := httptest.NewRecorder()
+	TestUserAuth(db).ServeHTTP(rec, req, func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(requestHeader)
+		assert.Equal(t, user.ID, acct.GetCurrentRequestUserFromCache(requestID).ID)
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, requestID)
+	assert.NotEqual(t, "chosen by the client", requestID)
+}
+
+func doRequest(t *testing.T, token *acct.Token, email string, db *gorp.DbMap) *httptest.ResponseRecorder {
 	authHandler := TestUserAuth(db)
-	u := fmt.Sprintf(testURL, token.String())
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequest("GET", testURL, nil)
 	assert.NoError(t, err)
+	req.Header.Set(acct.HeaderAuthorization, "Bearer "+token.String())
+	if email != "" {
+		req.Header.Set(acct.HeaderAuthEmail, email)
+	}
 	rec := httptest.NewRecorder()
 	authHandler.ServeHTTP(rec, req, func(w http.ResponseWriter, r *http.Request) {})
 	return rec
 }
 
-func getTestUser(t *testing.T, db *gorp.DbMap) *acct.User {
+func getTestUser(t testing.TB, db *gorp.DbMap) *acct.User {
 	user := &acct.User{}
 	querySql, args, err := squirrel.Select("*").
 		From(acct.TableNameUser).
@@ -0,0 +1,236 @@
+package mware
+
+import (
+	"fmt"
+	"testing"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/models/model"
+	"github.com/rahul2393/small-assignment-server/policy"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPolicies checks the rules of every model for a member of each of the
+// built-in groups, both on single records and as list query filters.
+func TestPolicies(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	admin := policyTestUser(t, db, 1)
+	manager := policyTestUser(t, db, 2)
+	regular := policyTestUser(t, db, 3)
+	meals := map[int64]*model.Meal{}
+	for _, u := range []*acct.User{admin, manager, regular} {
+		meal := &model.Meal{UserID: u.ID, Description: "lunch", Calories: 500}
+		assert.NoError(t, db.Insert(meal))
+		meals[u.ID] = meal
+	}
+
+	cases := []struct {
+		user     *acct.User
+		action   policy.Action
+		resource string
+		record   interface{}
+		allowed  bool
+	}{
+		// users
+		{admin, policy.Read, acct.TableNameUser, admin, true},
+		{admin, policy.Read, acct.TableNameUser, regular, true},
+		{admin, policy.Write, acct.TableNameUser, regular, true},
+		{manager, policy.Read, acct.TableNameUser, admin, false},
+		{manager, policy.Read, acct.TableNameUser, regular, true},
+		{manager, policy.Write, acct.TableNameUser, manager, true},
+		{manager, policy.Write, acct.TableNameUser, regular, true},
+		{manager, policy.Write, acct.TableNameUser, admin, false},
+		{regular, policy.Read, acct.TableNameUser, regular, true},
+		{regular, policy.Read, acct.TableNameUser, manager, false},
+		{regular, policy.Write, acct.TableNameUser, regular, false},
+
+		// meals
+		{admin, policy.Read, acct.TableNameMeal, meals[regular.ID], true},
+		{admin, policy.Write, acct.TableNameMeal, meals[regular.ID], true},
+		{manager, policy.Read, acct.TableNameMeal, meals[manager.ID], true},
+		{manager, policy.Read, acct.TableNameMeal, meals[regular.ID], false},
+		{manager, policy.Write, acct.TableNameMeal, meals[manager.ID], true},
+		{manager, policy.Write, acct.TableNameMeal, meals[admin.ID], false},
+		{regular, policy.Read, acct.TableNameMeal, meals[regular.ID], true},
+		{regular, policy.Read, acct.TableNameMeal, meals[admin.ID], false},
+		{regular, policy.Write, acct.TableNameMeal, meals[regular.ID], true},
+		{regular, policy.Write, acct.TableNameMeal, meals[manager.ID], false},
+
+		// password resets
+		{admin, acct.ActionResetPassword, acct.TableNameUser, admin, true},
+		{admin, acct.ActionResetPassword, acct.TableNameUser, manager, true},
+		{manager, acct.ActionResetPassword, acct.TableNameUser, manager, true},
+		{manager, acct.ActionResetPassword, acct.TableNameUser, regular, true},
+		{manager, acct.ActionResetPassword, acct.TableNameUser, admin, false},
+		{regular, acct.ActionResetPassword, acct.TableNameUser, regular, true},
+		{regular, acct.ActionResetPassword, acct.TableNameUser, manager, false},
+
+		// group changes
+		{admin, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 1, OrgID: acct.DefaultOrgID}, true},
+		{admin, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 1, To: 3, OrgID: acct.DefaultOrgID}, true},
+		{manager, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 2, OrgID: acct.DefaultOrgID, UserID: regular.ID}, true},
+		{manager, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 1, OrgID: acct.DefaultOrgID, UserID: regular.ID}, false},
+		{manager, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 1, To: 3, OrgID: acct.DefaultOrgID, UserID: admin.ID}, false},
+		{manager, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 3, OrgID: acct.DefaultOrgID, TeamID: 1}, true},
+		{manager, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 3, OrgID: acct.DefaultOrgID}, false},
+		{regular, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 3, OrgID: acct.DefaultOrgID}, false},
+	}
+	for _, c := range cases {
+		allowed, err := policy.Allowed(db, c.user, c.action, c.resource, c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.allowed, allowed, fmt.Sprintf("%s %s %s %+v", c.user.Group.Name, c.action, c.resource, c.record))
+	}
+
+	filters := []struct {
+		user     *acct.User
+		action   policy.Action
+		resource string
+		visible  int64
+	}{
+		{admin, policy.Read, acct.TableNameUser, 3},
+		{admin, policy.Write, acct.TableNameMeal, 3},
+		{manager, policy.Read, acct.TableNameUser, 2},
+		{manager, policy.Write, acct.TableNameUser, 2},
+		{manager, policy.Read, acct.TableNameMeal, 1},
+		{manager, policy.Write, acct.TableNameMeal, 1},
+		{regular, policy.Read, acct.TableNameUser, 1},
+		{regular, policy.Read, acct.TableNameMeal, 1},
+		{regular, policy.Write, acct.TableNameMeal, 1},
+	}
+	for _, f := range filters {
+		builder := squirrel.Select("count(*)").From(f.resource).Where(squirrel.Eq{"Deleted": false})
+		filter, err := policy.Filter(f.user, f.action, f.resource)
+		assert.NoError(t, err)
+		if filter != nil {
+			builder = builder.Where(filter)
+		}
+		query, args, _ := builder.ToSql()
+		visible, err := db.SelectInt(query, args...)
+		assert.NoError(t, err)
+		assert.Equal(t, f.visible, visible, fmt.Sprintf("%s %s %s", f.user.Group.Name, f.action, f.resource))
+	}
+
+	// no rule grants regular users writing users, so there is nothing to list
+	_, err := policy.Filter(regular, policy.Write, acct.TableNameUser)
+	assert.Equal(t, policy.ErrDenied, err)
+	assert.False(t, policy.Can(regular, policy.Write, acct.TableNameUser))
+}
+
+// TestFieldPolicies checks the attributes of users each of the built-in
+// groups may not read or write.
+func TestFieldPolicies(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	admin := policyTestUser(t, db, 1)
+	manager := policyTestUser(t, db, 2)
+	regular := policyTestUser(t, db, 3)
+
+	cases := []struct {
+		user   *acct.User
+		action policy.Action
+		record *acct.User
+		denied []string
+	}{
+		{admin, policy.Read, regular, nil},
+		{admin, policy.Write, regular, nil},
+		{manager, policy.Read, manager, nil},
+		{manager, policy.Read, regular, []string{"emailVerified", "twoFactorEnabled", "superAdmin"}},
+		{manager, policy.Write, manager, nil},
+		{manager, policy.Write, regular, []string{"email", "password"}},
+		{regular, policy.Read, regular, nil},
+	}
+	for _, c := range cases {
+		denied, err := policy.DeniedFields(db, c.user, c.action, acct.TableNameUser, c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.denied, denied, fmt.Sprintf("%s %s user %d", c.user.Group.Name, c.action, c.record.ID))
+	}
+}
+
+func policyTestUser(t *testing.T, db *gorp.DbMap, id int64) *acct.User {
+	user := &acct.User{}
+	query, args, _ := squirrel.Select("*").
+		From(acct.TableNameUser).
+		Where(squirrel.Eq{"ID": id}).ToSql()
+	assert.NoError(t, db.SelectOne(user, query, args...))
+	assert.NoError(t, user.Expand(db, ""))
+	return user
+}
+
+// TestTenantIsolation checks that even the rules granting every record
+// only span the organization of the user, unless the user is a super admin.
+func TestTenantIsolation(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	superAdmin := policyTestUser(t, db, 1)
+	regular := policyTestUser(t, db, 3)
+	clinic := &acct.Organization{Name: "Clinic", Slug: "clinic"}
+	assert.NoError(t, db.Insert(clinic))
+	admin := &acct.User{Email: "admin@clinic.com", Name: "Clinic Admin", GroupID: acct.Admin.ID, OrgID: clinic.ID}
+	assert.NoError(t, admin.SetPassword("test password"))
+	assert.NoError(t, db.Insert(admin))
+	assert.NoError(t, admin.Expand(db, ""))
+	patient := &acct.User{Email: "patient@clinic.com", Name: "Clinic Patient", GroupID: acct.Regular.ID, OrgID: clinic.ID}
+	assert.NoError(t, patient.SetPassword("test password"))
+	assert.NoError(t, db.Insert(patient))
+	for _, u := range []*acct.User{regular, patient} {
+		assert.NoError(t, db.Insert(&model.Meal{UserID: u.ID, Description: "lunch", Calories: 500}))
+	}
+	patientMeal := &model.Meal{UserID: patient.ID, Description: "dinner", Calories: 700}
+	assert.NoError(t, db.Insert(patientMeal))
+	assert.Equal(t, clinic.ID, patientMeal.OrgID)
+
+	cases := []struct {
+		user     *acct.User
+		action   policy.Action
+		resource string
+		record   interface{}
+		allowed  bool
+	}{
+		{admin, policy.Read, acct.TableNameUser, patient, true},
+		{admin, policy.Read, acct.TableNameUser, regular, false},
+		{admin, policy.Write, acct.TableNameUser, regular, false},
+		{admin, policy.Write, acct.TableNameMeal, patientMeal, true},
+		{admin, policy.Write, acct.TableNameMeal, &model.Meal{UserID: regular.ID}, false},
+		{admin, acct.ActionResetPassword, acct.TableNameUser, regular, false},
+		{admin, acct.ActionImpersonate, acct.TableNameUser, patient, true},
+		{admin, acct.ActionImpersonate, acct.TableNameUser, regular, false},
+		{admin, acct.ActionChangeGroup, acct.TableNameUser, &acct.GroupChange{From: 3, To: 2, OrgID: acct.DefaultOrgID}, false},
+		{superAdmin, policy.Write, acct.TableNameUser, patient, true},
+		{superAdmin, policy.Read, acct.TableNameMeal, patientMeal, true},
+		{regular, policy.Read, acct.TableNameOrganization, clinic, false},
+		{patient, policy.Read, acct.TableNameOrganization, clinic, true},
+		{admin, policy.Write, acct.TableNameOrganization, clinic, false},
+	}
+	for _, c := range cases {
+		allowed, err := policy.Allowed(db, c.user, c.action, c.resource, c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.allowed, allowed, fmt.Sprintf("%s %s %s %+v", c.user.Email, c.action, c.resource, c.record))
+	}
+
+	filters := []struct {
+		user     *acct.User
+		resource string
+		visible  int64
+	}{
+		{admin, acct.TableNameUser, 2},
+		{admin, acct.TableNameMeal, 2},
+		{superAdmin, acct.TableNameUser, 5},
+		{superAdmin, acct.TableNameMeal, 3},
+		{superAdmin, acct.TableNameOrganization, 2},
+		{patient, acct.TableNameOrganization, 1},
+	}
+	for _, f := range filters {
+		builder := squirrel.Select("count(*)").From(f.resource).Where(squirrel.Eq{"Deleted": false})
+		filter, err := policy.Filter(f.user, policy.Read, f.resource)
+		assert.NoError(t, err)
+		if filter != nil {
+			builder = builder.Where(filter)
+		}
+		query, args, _ := builder.ToSql()
+		visible, err := db.SelectInt(query, args...)
+		assert.NoError(t, err)
+		assert.Equal(t, f.visible, visible, fmt.Sprintf("%s %s", f.user.Email, f.resource))
+	}
+}
@@ -0,0 +1,94 @@
+package mware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/rahul2393/small-assignment-server/cache"
+	"github.com/rahul2393/small-assignment-server/models/acct"
+	"github.com/rahul2393/small-assignment-server/testhelpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAuthSignedToken(t *testing.T) {
+	db := testhelpers.SetupTestWithFixtures()
+	acct.SetTokenStrategy(acct.SignedTokens{})
+	defer acct.SetTokenStrategy(nil)
+
+	user := getTestUser(t, db)
+	token := newTestSession(t, db, user)
+	rec := doSignedRequest(t, user.Token, db)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// tampered and database tokens are rejected
+	rec = doSignedRequest(t, user.Token[:len(user.Token)-2]+"xx", db)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = doSignedRequest(t, token.String(), db)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// revoked tokens are rejected even though their signature is valid
+	assert.NoError(t, acct.RevokeSession(db, user.ID, token.ID))
+	rec = doSignedRequest(t, user.Token, db)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// so are tokens whose sessions were deleted
+	newTestSession(t, db, user)
+	assert.NoError(t, acct.DeleteSessions(db, user.ID))
+	rec = doSignedRequest(t, user.Token, db)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// and tokens issued before the user changed groups
+	newTestSession(t, db, user)
+	_, err := db.Exec("update "+acct.TableNameUser+" set groupID = ? where ID = ?", acct.Regular.ID, user.ID)
+	assert.NoError(t, err)
+	rec = doSignedRequest(t, user.Token, db)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+// BenchmarkAuthenticate compares the token strategies on session cache
+// misses, which is when they differ.
+func BenchmarkAuthenticate(b *testing.B) {
+	db := testhelpers.SetupTestWithFixtures()
+	defer acct.SetTokenStrategy(nil)
+	strategies := []struct {
+		name     string
+		strategy acct.TokenStrategy
+	}{
+		{acct.TokenStrategyDatabase, acct.DatabaseTokens{}},
+		{acct.TokenStrategySigned, acct.SignedTokens{}},
+	}
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			acct.SetTokenStrategy(s.strategy)
+			user := getTestUser(b, db)
+			newTestSession(b, db, user)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cache.Delete(acct.CacheKeySession, user.Token)
+				if _, err := acct.Authenticate(db, "", user.Token); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newTestSession logs the user in with the current token strategy.
+func newTestSession(t testing.TB, db *gorp.DbMap, user *acct.User) *acct.Token {
+	token, refresh, err := acct.NewSession(db, user.ID, "", acct.Client{})
+	assert.NoError(t, err)
+	assert.NoError(t, user.SetSession(token, refresh))
+	return token
+}
+
+func doSignedRequest(t *testing.T, token string, db *gorp.DbMap) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", testURL, nil)
+	assert.NoError(t, err)
+	req.Header.Set(acct.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	TestUserAuth(db).ServeHTTP(rec, req, func(w http.ResponseWriter, r *http.Request) {})
+	return rec
+}
@@ -0,0 +1,358 @@
+// Package policy decides what users may do.  Models register rules for
+// their resource; a rule grants an action to the subjects it matches,
+// optionally only on the records matching a condition on the record's
+// attributes.  Conditions are evaluated both on single records and as SQL
+// filters for list queries, so both agree on what a user may access.
+// Scopes, such as the organization of multi-tenant data, restrict every
+// rule of a resource.  Field rules further restrict reading and writing
+// some attributes of the records.
+package policy
+
+import (
+	"sync"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+)
+
+// Action is something a subject does with a resource.
+type Action string
+
+const (
+	// Read is viewing records.
+	Read Action = "read"
+	// Write is creating, changing and deleting records.
+	Write Action = "write"
+)
+
+// ErrDenied occurs when no rule grants the action.
+var ErrDenied = errors.New("policy: no rule grants the action")
+
+// Subject is the user decisions are made for.
+type Subject interface {
+	// SubjectID is the id of the user.
+	SubjectID() int64
+	// SubjectGroupID is the id of the group the user acts as.
+	SubjectGroupID() int64
+	// HasPermission returns whether or not the user's group grants the
+	// permission with the name.
+	HasPermission(name string) bool
+	// SubjectOrgID is the id of the organization the user is confined
+	// to.  Zero means the user spans every organization.
+	SubjectOrgID() int64
+}
+
+// Matcher decides whether or not a rule applies to the subject.
+type Matcher func(sub Subject) bool
+
+// Anyone matches every subject.
+func Anyone() Matcher {
+	return func(sub Subject) bool { return true }
+}
+
+// Permission matches the subjects whose group grants the permission.
+func Permission(name string) Matcher {
+	return func(sub Subject) bool { return sub.HasPermission(name) }
+}
+
+// Group matches the members of the group.
+func Group(id int64) Matcher {
+	return func(sub Subject) bool { return sub.SubjectGroupID() == id }
+}
+
+// Condition restricts a rule to some records.  Check decides for a single
+// record; SQL returns the predicate matching the same records in list
+// queries.  Conditions without SQL only apply to single records.
+type Condition struct {
+	Check func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error)
+	SQL   func(sub Subject) squirrel.Sqlizer
+}
+
+// Owner matches the records whose owner, as returned by owner and stored in
+// the column, is the subject.
+func Owner(column string, owner func(record interface{}) int64) *Condition {
+	return &Condition{
+		Check: func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error) {
+			return owner(record) == sub.SubjectID(), nil
+		},
+		SQL: func(sub Subject) squirrel.Sqlizer {
+			return squirrel.Eq{column: sub.SubjectID()}
+		},
+	}
+}
+
+// Or matches the records matching any of the conditions.
+func Or(conditions ...*Condition) *Condition {
+	c := &Condition{
+		Check: func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error) {
+			for _, cond := range conditions {
+				ok, err := cond.Check(s, sub, record)
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		},
+	}
+	for _, cond := range conditions {
+		if cond.SQL == nil {
+			// one of the conditions only applies to single records
+			return c
+		}
+	}
+	c.SQL = func(sub Subject) squirrel.Sqlizer {
+		or := squirrel.Or{}
+		for _, cond := range conditions {
+			or = append(or, cond.SQL(sub))
+		}
+		return or
+	}
+	return c
+}
+
+// And matches the records matching all of the conditions.
+func And(conditions ...*Condition) *Condition {
+	c := &Condition{
+		Check: func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error) {
+			for _, cond := range conditions {
+				ok, err := cond.Check(s, sub, record)
+				if err != nil || !ok {
+					return false, err
+				}
+			}
+			return true, nil
+		},
+	}
+	for _, cond := range conditions {
+		if cond.SQL == nil {
+			return c
+		}
+	}
+	c.SQL = func(sub Subject) squirrel.Sqlizer {
+		and := squirrel.And{}
+		for _, cond := range conditions {
+			and = append(and, cond.SQL(sub))
+		}
+		return and
+	}
+	return c
+}
+
+// Tenant matches the records of the subject's organization, as returned by
+// org and stored in the column.  Subjects spanning every organization
+// match every record.
+func Tenant(column string, org func(s gorp.SqlExecutor, record interface{}) (int64, error)) *Condition {
+	return &Condition{
+		Check: func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error) {
+			if sub.SubjectOrgID() == 0 {
+				return true, nil
+			}
+			id, err := org(s, record)
+			return id == sub.SubjectOrgID(), err
+		},
+		SQL: func(sub Subject) squirrel.Sqlizer {
+			if sub.SubjectOrgID() == 0 {
+				return nil
+			}
+			return squirrel.Eq{column: sub.SubjectOrgID()}
+		},
+	}
+}
+
+// Rule grants the action to the subjects it matches on the records
+// matching the resource condition.  A nil Resource matches every record.
+type Rule struct {
+	Action   Action
+	Subject  Matcher
+	Resource *Condition
+}
+
+// FieldRule grants the action on an attribute, named as in the record's
+// JSON, to the subjects it matches on the records matching the resource
+// condition.  Attributes with field rules for an action are restricted to
+// them; the others go along with the record.
+type FieldRule struct {
+	Field    string
+	Action   Action
+	Subject  Matcher
+	Resource *Condition
+}
+
+var (
+	mu       sync.RWMutex
+	policies = map[string][]Rule{}
+	scopes   = map[string][]*Condition{}
+	fields   = map[string][]FieldRule{}
+)
+
+// Register adds rules for the resource, usually the table name of a
+// model.  Models register their rules when their package is initialized.
+func Register(resource string, rules ...Rule) {
+	mu.Lock()
+	defer mu.Unlock()
+	policies[resource] = append(policies[resource], rules...)
+}
+
+// Scope restricts every rule of the resource to the records matching the
+// condition, whatever the rule grants.  The condition's SQL may return nil
+// to leave list queries unrestricted.
+func Scope(resource string, condition *Condition) {
+	mu.Lock()
+	defer mu.Unlock()
+	scopes[resource] = append(scopes[resource], condition)
+}
+
+// Fields adds field rules for the resource.
+func Fields(resource string, rules ...FieldRule) {
+	mu.Lock()
+	defer mu.Unlock()
+	fields[resource] = append(fields[resource], rules...)
+}
+
+// rules returns the resource's rules for the action that match the
+// subject.
+func rules(sub Subject, action Action, resource string) []Rule {
+	mu.RLock()
+	defer mu.RUnlock()
+	var matched []Rule
+	for _, r := range policies[resource] {
+		if r.Action == action && r.Subject(sub) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// Can returns whether or not the subject may perform the action on at
+// least some records of the resource.
+func Can(sub Subject, action Action, resource string) bool {
+	return len(rules(sub, action, resource)) > 0
+}
+
+// Grants returns, for every resource, the actions the subject may perform
+// on at least some of its records, in the order their rules were
+// registered.
+func Grants(sub Subject) map[string][]Action {
+	mu.RLock()
+	defer mu.RUnlock()
+	grants := map[string][]Action{}
+	for resource, rs := range policies {
+		seen := map[Action]bool{}
+		for _, r := range rs {
+			if !seen[r.Action] && r.Subject(sub) {
+				seen[r.Action] = true
+				grants[resource] = append(grants[resource], r.Action)
+			}
+		}
+	}
+	return grants
+}
+
+// Allowed returns whether or not the subject may perform the action on the
+// record of the resource.
+func Allowed(s gorp.SqlExecutor, sub Subject, action Action, resource string, record interface{}) (bool, error) {
+	mu.RLock()
+	scoped := scopes[resource]
+	mu.RUnlock()
+	for _, scope := range scoped {
+		ok, err := scope.Check(s, sub, record)
+		if err != nil {
+			return false, errors.Wrap(err, "policy: error in checking scope")
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	for _, r := range rules(sub, action, resource) {
+		if r.Resource == nil {
+			return true, nil
+		}
+		ok, err := r.Resource.Check(s, sub, record)
+		if err != nil {
+			return false, errors.Wrap(err, "policy: error in checking rule")
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Filter returns the predicate restricting a list query of the resource to
+// the records of its scopes the subject may perform the action on.  A nil
+// predicate means every record; ErrDenied means none.
+func Filter(sub Subject, action Action, resource string) (squirrel.Sqlizer, error) {
+	granted, err := grantFilter(sub, action, resource)
+	if err != nil {
+		return nil, err
+	}
+	and := squirrel.And{}
+	mu.RLock()
+	scoped := scopes[resource]
+	mu.RUnlock()
+	for _, scope := range scoped {
+		if pred := scope.SQL(sub); pred != nil {
+			and = append(and, pred)
+		}
+	}
+	if len(and) == 0 {
+		return granted, nil
+	}
+	if granted != nil {
+		and = append(and, granted)
+	}
+	return and, nil
+}
+
+// grantFilter returns the predicate matching the records the rules grant
+// the action on, without the resource's scopes.
+func grantFilter(sub Subject, action Action, resource string) (squirrel.Sqlizer, error) {
+	or := squirrel.Or{}
+	for _, r := range rules(sub, action, resource) {
+		if r.Resource == nil {
+			return nil, nil
+		}
+		if r.Resource.SQL != nil {
+			or = append(or, r.Resource.SQL(sub))
+		}
+	}
+	if len(or) == 0 {
+		return nil, ErrDenied
+	}
+	return or, nil
+}
+
+// DeniedFields returns the restricted attributes of the record of the
+// resource the subject may not perform the action on, in the order their
+// rules were registered.  The subject is assumed to be allowed the action
+// on the record itself.
+func DeniedFields(s gorp.SqlExecutor, sub Subject, action Action, resource string, record interface{}) ([]string, error) {
+	mu.RLock()
+	rs := fields[resource]
+	mu.RUnlock()
+	var denied []string
+	allowed := map[string]bool{}
+	for _, r := range rs {
+		if r.Action != action || allowed[r.Field] || !r.Subject(sub) {
+			continue
+		}
+		ok := r.Resource == nil
+		if !ok {
+			var err error
+			if ok, err = r.Resource.Check(s, sub, record); err != nil {
+				return nil, errors.Wrap(err, "policy: error in checking field rule")
+			}
+		}
+		allowed[r.Field] = ok
+	}
+	seen := map[string]bool{}
+	for _, r := range rs {
+		if r.Action == action && !allowed[r.Field] && !seen[r.Field] {
+			seen[r.Field] = true
+			denied = append(denied, r.Field)
+		}
+	}
+	return denied, nil
+}
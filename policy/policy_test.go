@@ -0,0 +1,200 @@
+package policy
+
+import (
+	"testing"
+
+	"gopkg.in/gorp.v1"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSubject struct {
+	id, groupID, orgID int64
+	permissions        map[string]bool
+}
+
+func (s *testSubject) SubjectID() int64               { return s.id }
+func (s *testSubject) SubjectGroupID() int64          { return s.groupID }
+func (s *testSubject) HasPermission(name string) bool { return s.permissions[name] }
+func (s *testSubject) SubjectOrgID() int64            { return s.orgID }
+
+type testRecord struct {
+	OwnerID int64
+	OrgID   int64
+	Public  bool
+}
+
+func TestPolicy(t *testing.T) {
+	owned := Owner("OwnerID", func(record interface{}) int64 { return record.(*testRecord).OwnerID })
+	public := &Condition{
+		Check: func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error) {
+			return record.(*testRecord).Public, nil
+		},
+	}
+	Register("test_records",
+		Rule{Action: Read, Subject: Permission("read:all"), Resource: nil},
+		Rule{Action: Read, Subject: Anyone(), Resource: Or(owned, public)},
+		Rule{Action: Write, Subject: Group(1)},
+		Rule{Action: Write, Subject: Permission("write:own"), Resource: owned},
+	)
+	admin := &testSubject{id: 1, groupID: 1, permissions: map[string]bool{"read:all": true}}
+	writer := &testSubject{id: 2, groupID: 2, permissions: map[string]bool{"write:own": true}}
+	reader := &testSubject{id: 3, groupID: 3}
+
+	cases := []struct {
+		sub     Subject
+		action  Action
+		record  *testRecord
+		allowed bool
+	}{
+		{admin, Read, &testRecord{OwnerID: 3}, true},
+		{admin, Write, &testRecord{OwnerID: 3}, true},
+		{writer, Read, &testRecord{OwnerID: 2}, true},
+		{writer, Read, &testRecord{OwnerID: 3}, false},
+		{writer, Read, &testRecord{OwnerID: 3, Public: true}, true},
+		{writer, Write, &testRecord{OwnerID: 2}, true},
+		{writer, Write, &testRecord{OwnerID: 3, Public: true}, false},
+		{reader, Write, &testRecord{OwnerID: 3}, false},
+		{reader, "delete", &testRecord{OwnerID: 3}, false},
+	}
+	for _, c := range cases {
+		allowed, err := Allowed(nil, c.sub, c.action, "test_records", c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.allowed, allowed, "%+v %s %+v", c.sub, c.action, c.record)
+	}
+
+	// unrestricted rules need no predicate
+	filter, err := Filter(admin, Read, "test_records")
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	// conditions without SQL don't grant list queries
+	_, err = Filter(writer, Read, "test_records")
+	assert.Equal(t, ErrDenied, err)
+
+	filter, err = Filter(writer, Write, "test_records")
+	assert.NoError(t, err)
+	sql, args, err := squirrel.Select("*").From("test_records").Where(filter).ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM test_records WHERE (OwnerID = ?)", sql)
+	assert.Equal(t, []interface{}{int64(2)}, args)
+
+	_, err = Filter(reader, Write, "test_records")
+	assert.Equal(t, ErrDenied, err)
+	assert.False(t, Can(reader, Write, "test_records"))
+	assert.True(t, Can(writer, Write, "test_records"))
+
+	assert.Equal(t, []Action{Read, Write}, Grants(admin)["test_records"])
+	assert.Equal(t, []Action{Read}, Grants(reader)["test_records"])
+}
+
+func TestTenant(t *testing.T) {
+	Scope("test_tenants", Tenant("OrgID", func(s gorp.SqlExecutor, record interface{}) (int64, error) {
+		return record.(*testRecord).OrgID, nil
+	}))
+	Register("test_tenants",
+		Rule{Action: Read, Subject: Anyone()},
+		Rule{Action: Write, Subject: Anyone(), Resource: Owner("OwnerID", func(record interface{}) int64 {
+			return record.(*testRecord).OwnerID
+		})},
+	)
+	member := &testSubject{id: 1, orgID: 1}
+	platform := &testSubject{id: 2}
+
+	cases := []struct {
+		sub     Subject
+		action  Action
+		record  *testRecord
+		allowed bool
+	}{
+		{member, Read, &testRecord{OrgID: 1}, true},
+		{member, Read, &testRecord{OrgID: 2}, false},
+		{member, Write, &testRecord{OwnerID: 1, OrgID: 1}, true},
+		{member, Write, &testRecord{OwnerID: 1, OrgID: 2}, false},
+		{platform, Read, &testRecord{OrgID: 2}, true},
+		{platform, Write, &testRecord{OwnerID: 2, OrgID: 3}, true},
+	}
+	for _, c := range cases {
+		allowed, err := Allowed(nil, c.sub, c.action, "test_tenants", c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.allowed, allowed, "%+v %s %+v", c.sub, c.action, c.record)
+	}
+
+	// the scope applies even to rules granting every record
+	filter, err := Filter(member, Read, "test_tenants")
+	assert.NoError(t, err)
+	sql, args, err := squirrel.Select("*").From("test_tenants").Where(filter).ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM test_tenants WHERE (OrgID = ?)", sql)
+	assert.Equal(t, []interface{}{int64(1)}, args)
+
+	filter, err = Filter(member, Write, "test_tenants")
+	assert.NoError(t, err)
+	sql, args, err = squirrel.Select("*").From("test_tenants").Where(filter).ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM test_tenants WHERE (OrgID = ? AND (OwnerID = ?))", sql)
+	assert.Equal(t, []interface{}{int64(1), int64(1)}, args)
+
+	filter, err = Filter(platform, Read, "test_tenants")
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+}
+
+func TestAnd(t *testing.T) {
+	owned := Owner("OwnerID", func(record interface{}) int64 { return record.(*testRecord).OwnerID })
+	inOrg := &Condition{
+		Check: func(s gorp.SqlExecutor, sub Subject, record interface{}) (bool, error) {
+			return record.(*testRecord).OrgID == 1, nil
+		},
+		SQL: func(sub Subject) squirrel.Sqlizer {
+			return squirrel.Eq{"OrgID": 1}
+		},
+	}
+	both := And(owned, inOrg)
+	sub := &testSubject{id: 1}
+	for record, want := range map[*testRecord]bool{
+		{OwnerID: 1, OrgID: 1}: true,
+		{OwnerID: 1, OrgID: 2}: false,
+		{OwnerID: 2, OrgID: 1}: false,
+	} {
+		ok, err := both.Check(nil, sub, record)
+		assert.NoError(t, err)
+		assert.Equal(t, want, ok, "%+v", record)
+	}
+	sql, args, err := squirrel.Select("*").From("test_records").Where(both.SQL(sub)).ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM test_records WHERE (OwnerID = ? AND OrgID = ?)", sql)
+	assert.Equal(t, []interface{}{int64(1), 1}, args)
+}
+
+func TestFields(t *testing.T) {
+	owned := Owner("OwnerID", func(record interface{}) int64 { return record.(*testRecord).OwnerID })
+	Fields("test_fields",
+		FieldRule{Field: "public", Action: Write, Subject: Group(1)},
+		FieldRule{Field: "ownerID", Action: Write, Subject: Group(1)},
+		FieldRule{Field: "ownerID", Action: Write, Subject: Anyone(), Resource: owned},
+		FieldRule{Field: "orgID", Action: Read, Subject: Permission("read:all")},
+	)
+	admin := &testSubject{id: 1, groupID: 1, permissions: map[string]bool{"read:all": true}}
+	writer := &testSubject{id: 2, groupID: 2}
+
+	cases := []struct {
+		sub    Subject
+		action Action
+		record *testRecord
+		denied []string
+	}{
+		{admin, Write, &testRecord{OwnerID: 2}, nil},
+		{admin, Read, &testRecord{OwnerID: 2}, nil},
+		{writer, Write, &testRecord{OwnerID: 2}, []string{"public"}},
+		{writer, Write, &testRecord{OwnerID: 3}, []string{"public", "ownerID"}},
+		{writer, Read, &testRecord{OwnerID: 2}, []string{"orgID"}},
+		{writer, "delete", &testRecord{OwnerID: 2}, nil},
+	}
+	for _, c := range cases {
+		denied, err := DeniedFields(nil, c.sub, c.action, "test_fields", c.record)
+		assert.NoError(t, err)
+		assert.Equal(t, c.denied, denied, "%+v %s %+v", c.sub, c.action, c.record)
+	}
+}
@@ -0,0 +1,184 @@
+// Package pwpolicy checks passwords against the configured password
+// policy: length, character classes and a list of breached passwords.
+// The password history is checked by the acct package since it needs the
+// database.
+package pwpolicy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/dchest/uniuri"
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
+	"github.com/rahul2393/small-assignment-server/logger"
+)
+
+const (
+	// RuleMinLength is violated by passwords shorter than MinLength.
+	RuleMinLength = "min_length"
+	// RuleMaxLength is violated by passwords longer than MaxLength bytes.
+	RuleMaxLength = "max_length"
+	// RuleCharacterClasses is violated by passwords using fewer than
+	// MinCharacterClasses of lower case, upper case, digits and symbols.
+	RuleCharacterClasses = "character_classes"
+	// RuleBreached is violated by passwords on the breached list.
+	RuleBreached = "breached"
+	// RuleHistory is violated by one of the user's recent passwords.
+	RuleHistory = "history"
+
+	// BcryptMaxLength is the number of bytes bcrypt hashes, the rest of a
+	// password is ignored.
+	BcryptMaxLength = 72
+
+	generatedLength = 16
+	generatedChars  = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789!#$%&*+-=?@_"
+)
+
+// Violation is a rule a password doesn't satisfy.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Policy is a set of password rules.
+type Policy struct {
+	MinLength           int
+	MaxLength           int
+	MinCharacterClasses int
+	// History is the number of previous passwords that can't be reused.
+	History int
+	// Breached holds the lower cased breached passwords.
+	Breached map[string]bool
+}
+
+var (
+	defaultPolicy *Policy
+	once          sync.Once
+)
+
+// Default returns the policy of the password section of conf.toml.  The
+// breached password list is loaded upon the first call.
+func Default() *Policy {
+	once.Do(func() {
+		cfg := conf.Get().Password
+		defaultPolicy = &Policy{
+			MinLength:           cfg.MinLength,
+			MaxLength:           cfg.MaxLength,
+			MinCharacterClasses: cfg.MinCharacterClasses,
+			History:             cfg.History,
+		}
+		if cfg.BreachedListPath != "" {
+			breached, err := LoadBreached(cfg.BreachedListPath)
+			if err != nil {
+				logger.ErrorWithMsg("pwpolicy: breached password list not loaded", err)
+			}
+			defaultPolicy.Breached = breached
+		}
+	})
+	return defaultPolicy
+}
+
+// LoadBreached reads a breached password list with one password per line.
+func LoadBreached(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "pwpolicy: error in opening breached password list")
+	}
+	defer f.Close()
+	breached := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			breached[strings.ToLower(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "pwpolicy: error in reading breached password list")
+	}
+	return breached, nil
+}
+
+// Check returns every rule the password violates, except the history.
+func (p *Policy) Check(password string) []Violation {
+	violations := []Violation{}
+	if n := len([]rune(password)); n < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("Password must be at least %d characters long.", p.MinLength),
+		})
+	}
+	if len(password) > p.maxLength() {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("Password must be at most %d bytes long.", p.maxLength()),
+		})
+	}
+	if classes(password) < p.MinCharacterClasses {
+		violations = append(violations, Violation{
+			Rule: RuleCharacterClasses,
+			Message: fmt.Sprintf("Password must use at least %d of lower case letters, upper case letters, digits and symbols.",
+				p.MinCharacterClasses),
+		})
+	}
+	if p.Breached[strings.ToLower(password)] {
+		violations = append(violations, Violation{
+			Rule:    RuleBreached,
+			Message: "Password is too common, it appears in a list of breached passwords.",
+		})
+	}
+	return violations
+}
+
+// HistoryViolation is the violation of reusing a recent password.
+func (p *Policy) HistoryViolation() Violation {
+	return Violation{
+		Rule:    RuleHistory,
+		Message: fmt.Sprintf("Password must differ from your last %d passwords.", p.History),
+	}
+}
+
+// Generate returns a random password satisfying the policy.
+func (p *Policy) Generate() string {
+	n := generatedLength
+	if p.MinLength > n {
+		n = p.MinLength
+	}
+	if n > p.maxLength() {
+		n = p.maxLength()
+	}
+	for {
+		password := uniuri.NewLenChars(n, []byte(generatedChars))
+		if len(p.Check(password)) == 0 {
+			return password
+		}
+	}
+}
+
+func (p *Policy) maxLength() int {
+	if p.MaxLength <= 0 || p.MaxLength > BcryptMaxLength {
+		return BcryptMaxLength
+	}
+	return p.MaxLength
+}
+
+func classes(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
@@ -0,0 +1,72 @@
+package pwpolicy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy() *Policy {
+	return &Policy{
+		MinLength:           8,
+		MaxLength:           BcryptMaxLength,
+		MinCharacterClasses: 2,
+		History:             5,
+		Breached:            map[string]bool{"password1": true},
+	}
+}
+
+func rules(violations []Violation) []string {
+	names := []string{}
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func TestCheck(t *testing.T) {
+	p := testPolicy()
+	assert.Empty(t, p.Check("correct horse"))
+	assert.Empty(t, p.Check("Tr0ub4dor"))
+
+	assert.Equal(t, []string{RuleMinLength}, rules(p.Check("sh0rt")))
+	assert.Equal(t, []string{RuleCharacterClasses}, rules(p.Check("lowercaseonly")))
+	assert.Equal(t, []string{RuleMinLength, RuleCharacterClasses}, rules(p.Check("short")))
+	assert.Equal(t, []string{RuleBreached}, rules(p.Check("PassWord1")))
+	assert.Equal(t, []string{RuleMaxLength}, rules(p.Check(strings.Repeat("a1", 37))))
+
+	// the minimum is counted in characters, the maximum in bytes
+	assert.Equal(t, []string{RuleMinLength}, rules(p.Check("pässwö1")))
+	assert.Equal(t, []string{RuleMaxLength}, rules(p.Check(strings.Repeat("ä1", 25))))
+}
+
+func TestLoadBreached(t *testing.T) {
+	f, err := ioutil.TempFile("", "breached")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("123456\n\n  Qwerty123 \nletmein\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	breached, err := LoadBreached(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"123456": true, "qwerty123": true, "letmein": true}, breached)
+
+	_, err = LoadBreached(f.Name() + ".missing")
+	assert.Error(t, err)
+}
+
+func TestGenerate(t *testing.T) {
+	p := testPolicy()
+	p.MinCharacterClasses = 4
+	for i := 0; i < 20; i++ {
+		password := p.Generate()
+		assert.Len(t, password, generatedLength)
+		assert.Empty(t, p.Check(password))
+	}
+	p.MinLength = 24
+	assert.Len(t, p.Generate(), 24)
+}
@@ -0,0 +1,202 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyFields narrows the records to the fields listed, such as
+// id,description,calories.  Fields of expanded records are paths, such as
+// user.name, and need q-expand=true.
+const KeyFields = "q-fields"
+
+// Fieldset is the fields of records a client asked for.  A nil Fieldset
+// is all of them.
+type Fieldset struct {
+	// Fields are the json names of the fields, with the fieldsets of the
+	// nested records narrowed too.
+	Fields map[string]*Fieldset
+	// Columns are the stored columns the fields need, ID included.
+	Columns []string
+	// Expanded is set when fields are filled in by expanding the records.
+	Expanded bool
+}
+
+// ParseFields parses the q-fields value for records of src.  Paths into
+// nested records are only valid when the records are expanded.
+func ParseFields(src interface{}, value string, expand bool) (*Fieldset, error) {
+	if value == "" {
+		return nil, nil
+	}
+	f := &Fieldset{Fields: map[string]*Fieldset{}, Columns: []string{FieldDefault}}
+	var problems ParamErrors
+	for _, path := range strings.Split(value, ",") {
+		if err := f.add(elemType(reflect.TypeOf(src)), strings.TrimSpace(path), expand); err != nil {
+			problems = append(problems, &ParamError{Param: KeyFields, Message: err.Error()})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return f, nil
+}
+
+// add adds the path to the fieldset of records of type t.
+func (f *Fieldset) add(t reflect.Type, path string, expand bool) error {
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		field, ok := jsonField(t, segment)
+		if !ok {
+			return fmt.Errorf("unknown field %q", strings.Join(segments[:i+1], "."))
+		}
+		name := jsonName(field)
+		if i == 0 {
+			if column, ok := storedColumn(field); ok {
+				if !containsFold(f.Columns, column) {
+					f.Columns = append(f.Columns, column)
+				}
+			} else {
+				f.Expanded = true
+			}
+		}
+		last := i == len(segments)-1
+		if last {
+			// the whole of the field, even if parts were asked for before
+			f.Fields[name] = nil
+			return nil
+		}
+		t = elemType(field.Type)
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("%q has no fields", strings.Join(segments[:i+1], "."))
+		}
+		if !expand {
+			return fmt.Errorf("%q needs %s=true", path, KeyExpand)
+		}
+		child, ok := f.Fields[name]
+		if ok && child == nil {
+			return nil
+		}
+		if !ok {
+			child = &Fieldset{Fields: map[string]*Fieldset{}}
+			f.Fields[name] = child
+		}
+		f = child
+	}
+	return nil
+}
+
+// Expands tells whether the records must be expanded for the fieldset.
+func (f *Fieldset) Expands() bool {
+	return f == nil || f.Expanded
+}
+
+// Narrow returns the record with only the fields of the fieldset.
+func (f *Fieldset) Narrow(record interface{}) (interface{}, error) {
+	if f == nil {
+		return record, nil
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.Wrap(err, "rest: error in encoding record")
+	}
+	return f.narrow(b)
+}
+
+func (f *Fieldset) narrow(b json.RawMessage) (json.RawMessage, error) {
+	switch b = bytes.TrimSpace(b); {
+	case f == nil || len(b) == 0:
+		return b, nil
+	case b[0] == '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(b, &items); err != nil {
+			return nil, errors.Wrap(err, "rest: error in decoding records")
+		}
+		for i := range items {
+			var err error
+			if items[i], err = f.narrow(items[i]); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(items)
+	case b[0] != '{':
+		return b, nil
+	}
+	attrs := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return nil, errors.Wrap(err, "rest: error in decoding record")
+	}
+	narrowed := map[string]json.RawMessage{}
+	for name, child := range f.Fields {
+		value, ok := attrs[name]
+		if !ok {
+			continue
+		}
+		var err error
+		if narrowed[name], err = child.narrow(value); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(narrowed)
+}
+
+// jsonField returns the field of type t, or of its embedded structs, with
+// the json name or field name key in any case.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if f, ok := jsonField(field.Type, key); ok {
+				return f, true
+			}
+			continue
+		}
+		if strings.EqualFold(jsonName(field), key) || strings.EqualFold(field.Name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func jsonName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// storedColumn returns the column of the field if it's stored.
+func storedColumn(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("db"), ",")[0]
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+// elemType returns the type the pointers and slices of t hold.
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+func containsFold(columns []string, column string) bool {
+	for _, c := range columns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
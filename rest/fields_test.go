@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testOwner struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type testMeal struct {
+	ID          int64      `json:"id"`
+	Deleted     bool       `json:"deleted"`
+	Description string     `json:"description"`
+	Calories    int64      `json:"calories"`
+	OwnerID     int64      `db:"ownerID" json:"ownerID"`
+	Owner       *testOwner `db:"-" json:"owner,omitempty"`
+	Secret      string     `json:"-"`
+}
+
+func TestParseFields(t *testing.T) {
+	f, err := ParseFields(&testMeal{}, "", false)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.Expands())
+
+	f, err = ParseFields(&testMeal{}, "description, Calories,ownerid,id", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ID", "Description", "Calories", "ownerID"}, f.Columns)
+	assert.False(t, f.Expands())
+
+	f, err = ParseFields(&testMeal{}, "calories,owner.name,owner.email", true)
+	assert.NoError(t, err)
+	assert.True(t, f.Expands())
+	assert.Equal(t, &Fieldset{Fields: map[string]*Fieldset{"name": nil, "email": nil}}, f.Fields["owner"])
+
+	for value, expand := range map[string]bool{
+		"secret":          true,
+		"colour":          true,
+		"owner.colour":    true,
+		"owner.name":      false,
+		"calories.amount": true,
+		"description,":    true,
+	} {
+		_, err := ParseFields(&testMeal{}, value, expand)
+		if e, ok := err.(ParamErrors); assert.True(t, ok, value) {
+			assert.Equal(t, KeyFields, e[0].Param)
+		}
+	}
+}
+
+func TestNarrow(t *testing.T) {
+	meal := &testMeal{ID: 1, Description: "salad", Calories: 300, OwnerID: 2,
+		Owner: &testOwner{ID: 2, Name: "ritik", Email: "ritik@gmail.com"}}
+
+	f, err := ParseFields(meal, "description,owner.name", true)
+	assert.NoError(t, err)
+	narrowed, err := f.Narrow(meal)
+	assert.NoError(t, err)
+	b, err := json.Marshal(narrowed)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"description": "salad", "owner": {"name": "ritik"}}`, string(b))
+
+	// the whole of a nested record wins over its parts
+	f, err = ParseFields(meal, "owner.name,owner", true)
+	assert.NoError(t, err)
+	narrowed, err = f.Narrow(map[string]interface{}{"id": 1, "owner": meal.Owner})
+	assert.NoError(t, err)
+	b, err = json.Marshal(narrowed)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"owner": {"id": 2, "name": "ritik", "email": "ritik@gmail.com"}}`, string(b))
+}
+
+func TestQueryFields(t *testing.T) {
+	q, err := Query(&testMeal{}, "test_meals", url.Values{KeyFields: {"description"}, KeyOrder: {"asc-calories"}})
+	assert.NoError(t, err)
+	sql, _, err := q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.ID, base.Description, base.Calories FROM test_meals as base "+
+		"WHERE base.Deleted = ? ORDER BY base.Calories asc, base.ID asc", sql)
+
+	// expanded records have all their columns
+	q, err = Query(&testMeal{}, "test_meals", url.Values{KeyFields: {"owner.name"}, KeyExpand: {"true"}})
+	assert.NoError(t, err)
+	sql, _, err = q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_meals as base WHERE base.Deleted = ? ORDER BY base.id desc", sql)
+
+	_, err = Query(&testMeal{}, "test_meals", url.Values{KeyFields: {"owner.name"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,378 @@
+package rest
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// KeyFilter is a boolean expression the records must match, such as
+//
+//	calories lt 200 or (description like '%salad%' and not deleted eq true)
+//
+// Comparisons are a field, an operator and a value: eq, ne, lt, lte, gt,
+// gte and like take a string, number or boolean; in and nin a
+// parenthesized list of them.  "field is null" and "field is not null"
+// check for nulls.  Comparisons combine with and, or, not and parentheses.
+const KeyFilter = "q-filter"
+
+const (
+	// maxFilterLength and maxFilterDepth bound the work a filter causes.
+	maxFilterLength = 2000
+	maxFilterDepth  = 32
+)
+
+// FilterError is a problem with a filter at a byte position.
+type FilterError struct {
+	Position int    `json:"position"`
+	Message  string `json:"message"`
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("rest: %s at position %d", e.Message, e.Position)
+}
+
+// comparisons maps the operators taking a single value to sql.
+var comparisons = map[operator]string{
+	"eq": "=",
+	"ne": "<>",
+	lt:   "<",
+	lte:  "<=",
+	gt:   ">",
+	gte:  ">=",
+	like: "LIKE",
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenNumber
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// filterNode is a node of a parsed filter.
+type filterNode interface {
+	squirrel.Sqlizer
+}
+
+type logicalNode struct {
+	op       string
+	children []filterNode
+}
+
+func (n *logicalNode) ToSql() (string, []interface{}, error) {
+	var parts []string
+	var args []interface{}
+	for _, c := range n.children {
+		sql, a, err := c.ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, sql)
+		args = append(args, a...)
+	}
+	return "(" + strings.Join(parts, " "+n.op+" ") + ")", args, nil
+}
+
+type notNode struct {
+	child filterNode
+}
+
+func (n *notNode) ToSql() (string, []interface{}, error) {
+	sql, args, err := n.child.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT " + sql, args, nil
+}
+
+type comparisonNode struct {
+	column string
+	op     operator
+	values []interface{}
+}
+
+func (n *comparisonNode) ToSql() (string, []interface{}, error) {
+	switch n.op {
+	case in, nin:
+		not := ""
+		if n.op == nin {
+			not = "NOT "
+		}
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(n.values)), ",")
+		return fmt.Sprintf("%s %sIN (%s)", n.column, not, marks), n.values, nil
+	case "null":
+		return n.column + " IS NULL", nil, nil
+	case "notnull":
+		return n.column + " IS NOT NULL", nil, nil
+	}
+	return fmt.Sprintf("%s %s ?", n.column, comparisons[n.op]), n.values, nil
+}
+
+// ParseFilter parses the filter into a predicate on the columns of src,
+// aliased as base.  Values are passed as arguments, never in the sql.
+func ParseFilter(src interface{}, filter string) (squirrel.Sqlizer, error) {
+	if len(filter) > maxFilterLength {
+		return nil, &FilterError{Position: maxFilterLength, Message: "filter is too long"}
+	}
+	tokens, err := lex(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{src: src, tokens: tokens}
+	node, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return node, nil
+}
+
+func lex(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(filter) && filter[j] != c; j++ {
+				if filter[j] == '\\' && j+1 < len(filter) {
+					j++
+				}
+				b.WriteByte(filter[j])
+			}
+			if j == len(filter) {
+				return nil, &FilterError{Position: i, Message: "unterminated string"}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: filter[i : j+1], value: b.String(), pos: i})
+			i = j + 1
+		case c == '-' || c == '.' || isDigit(c):
+			j := i + 1
+			for j < len(filter) && (isDigit(filter[j]) || filter[j] == '.') {
+				j++
+			}
+			text := filter[i:j]
+			var value interface{}
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				value = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, &FilterError{Position: i, Message: fmt.Sprintf("invalid number %q", text)}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: value, pos: i})
+			i = j
+		case c == '_' || c < unicode.MaxASCII && unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(filter) && (filter[j] == '_' || isDigit(filter[j]) ||
+				filter[j] < unicode.MaxASCII && unicode.IsLetter(rune(filter[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: filter[i:j], pos: i})
+			i = j
+		default:
+			return nil, &FilterError{Position: i, Message: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, text: "end of filter", pos: len(filter)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type filterParser struct {
+	src    interface{}
+	tokens []token
+	i      int
+	// field is the field of the comparison being parsed.
+	field param
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEnd {
+		p.i++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the word, in any case.
+func (p *filterParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(t token, format string, args ...interface{}) error {
+	return &FilterError{Position: t.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// or := and ("or" and)*
+func (p *filterParser) or(depth int) (filterNode, error) {
+	return p.logical(depth, "or", "OR", p.and)
+}
+
+// and := unary ("and" unary)*
+func (p *filterParser) and(depth int) (filterNode, error) {
+	return p.logical(depth, "and", "AND", p.unary)
+}
+
+func (p *filterParser) logical(depth int, word, op string, operand func(int) (filterNode, error)) (filterNode, error) {
+	node, err := operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	children := []filterNode{node}
+	for p.keyword(word) {
+		if node, err = operand(depth); err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &logicalNode{op: op, children: children}, nil
+}
+
+// unary := "not" unary | "(" or ")" | comparison
+func (p *filterParser) unary(depth int) (filterNode, error) {
+	if depth > maxFilterDepth {
+		return nil, p.errorf(p.peek(), "filter is nested too deeply")
+	}
+	if p.keyword("not") {
+		node, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{child: node}, nil
+	}
+	if p.peek().kind == tokenOpen {
+		p.next()
+		node, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenClose {
+			return nil, p.errorf(t, "expected \")\" instead of %q", t.text)
+		}
+		return node, nil
+	}
+	return p.comparison()
+}
+
+// comparison := field op value | field op "(" value ("," value)* ")"
+// | field "is" ["not"] "null"
+func (p *filterParser) comparison() (filterNode, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, p.errorf(t, "expected a field instead of %q", t.text)
+	}
+	field, ok := paramForField(p.src, t.text)
+	if !ok {
+		return nil, p.errorf(t, "unknown field %q", t.text)
+	}
+	p.field = field
+	node := &comparisonNode{column: "base." + field.column}
+	opToken := p.next()
+	if opToken.kind != tokenWord {
+		return nil, p.errorf(opToken, "expected an operator instead of %q", opToken.text)
+	}
+	node.op = operator(strings.ToLower(opToken.text))
+	switch {
+	case node.op == "is":
+		node.op = "null"
+		if p.keyword("not") {
+			node.op = "notnull"
+		}
+		if !p.keyword("null") {
+			return nil, p.errorf(p.peek(), "expected null instead of %q", p.peek().text)
+		}
+		return node, nil
+	case node.op == in || node.op == nin:
+		if t := p.next(); t.kind != tokenOpen {
+			return nil, p.errorf(t, "expected \"(\" instead of %q", t.text)
+		}
+		for {
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, value)
+			t := p.next()
+			if t.kind == tokenClose {
+				return node, nil
+			}
+			if t.kind != tokenComma {
+				return nil, p.errorf(t, "expected \",\" or \")\" instead of %q", t.text)
+			}
+		}
+	case node.op == like && field.kind != reflect.String:
+		return nil, p.errorf(opToken, "like only applies to text fields")
+	case comparisons[node.op] != "":
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		node.values = []interface{}{value}
+		return node, nil
+	}
+	return nil, p.errorf(opToken, "unknown operator %q", opToken.text)
+}
+
+// value := string | number | true | false, of the type of the field
+func (p *filterParser) value() (interface{}, error) {
+	t := p.next()
+	var value interface{}
+	switch {
+	case t.kind == tokenString || t.kind == tokenNumber:
+		value = t.value
+	case t.kind == tokenWord && strings.EqualFold(t.text, "true"):
+		value = true
+	case t.kind == tokenWord && strings.EqualFold(t.text, "false"):
+		value = false
+	default:
+		return nil, p.errorf(t, "expected a value instead of %q", t.text)
+	}
+	if value, ok := p.field.convert(value); ok {
+		return value, nil
+	}
+	return nil, p.errorf(t, "%s isn't a valid %s for %s", t.text, p.field.typeName(), p.field.name)
+}
@@ -0,0 +1,93 @@
+package rest
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, c := range []struct {
+		filter string
+		sql    string
+		args   []interface{}
+	}{
+		{"calories lt 200", "base.Calories < ?", []interface{}{int64(200)}},
+		{"calories lt 200 or name like '%salad%'",
+			"(base.Calories < ? OR base.Name LIKE ?)", []interface{}{int64(200), "%salad%"}},
+		{`CALORIES gte 15 and (name eq "it's" or not name ne 'a\'b')`,
+			"(base.Calories >= ? AND (base.Name = ? OR NOT base.Name <> ?))", []interface{}{int64(15), "it's", "a'b"}},
+		{"id in (1, 2) and calories nin (-3) or deleted eq true",
+			"((base.ID IN (?,?) AND base.Calories NOT IN (?)) OR base.Deleted = ?)",
+			[]interface{}{int64(1), int64(2), int64(-3), true}},
+		{"name is null or not (name is not null)", "(base.Name IS NULL OR NOT base.Name IS NOT NULL)", nil},
+	} {
+		node, err := ParseFilter(&testModel{}, c.filter)
+		if !assert.NoError(t, err, c.filter) {
+			continue
+		}
+		sql, args, err := node.ToSql()
+		assert.NoError(t, err)
+		assert.Equal(t, c.sql, sql, c.filter)
+		assert.Equal(t, c.args, args, c.filter)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for filter, position := range map[string]int{
+		"":                            0,
+		"calories":                    8,
+		"calories lt":                 11,
+		"calories lt 200 or":          18,
+		"calories lt 200 name eq 'a'": 16,
+		"password eq 'a'":             0,
+		"calories between 1":          9,
+		"calories lt 200; drop":       15,
+		"(calories lt 200":            16,
+		"name eq 'salad":              8,
+		"id in (1 2)":                 9,
+		"name is empty":               8,
+		"calories lt 1.2.3":           12,
+		"calories gte 1.5":            13,
+		"name lt 5":                   8,
+		"deleted in (true, 'no')":     18,
+		"calories like '1%'":          9,
+	} {
+		_, err := ParseFilter(&testModel{}, filter)
+		if e, ok := err.(*FilterError); assert.True(t, ok, "%q: %v", filter, err) {
+			assert.Equal(t, position, e.Position, "%q: %s", filter, e.Message)
+		}
+	}
+
+	// nesting and length are bounded
+	deep := ""
+	for i := 0; i < 100; i++ {
+		deep += "not "
+	}
+	_, err := ParseFilter(&testModel{}, deep+"calories lt 1")
+	assert.Error(t, err)
+	long := "calories lt 1"
+	for len(long) < maxFilterLength {
+		long += " or calories lt 1"
+	}
+	_, err = ParseFilter(&testModel{}, long)
+	assert.Error(t, err)
+}
+
+func TestQueryFilter(t *testing.T) {
+	q, err := Query(&testModel{}, "test_models", url.Values{KeyFilter: {"calories lt 200 or name like 'salad'"}})
+	assert.NoError(t, err)
+	sql, args, err := q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_models as base WHERE base.Deleted = ? AND "+
+		"(base.Calories < ? OR base.Name LIKE ?) ORDER BY base.id desc", sql)
+	assert.Equal(t, []interface{}{false, int64(200), "salad"}, args)
+	sql, _, err = q.CountQuery.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT COUNT(*) FROM test_models as base WHERE base.Deleted = ? AND "+
+		"(base.Calories < ? OR base.Name LIKE ?)", sql)
+
+	_, err = Query(&testModel{}, "test_models", url.Values{KeyFilter: {"calories lt"}})
+	assert.IsType(t, &FilterError{}, err)
+}
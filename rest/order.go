@@ -0,0 +1,179 @@
+package rest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// nullsFirst and nullsLast follow the order and field of a q-order
+	// key, as in asc-name-nullslast, to place nulls explicitly.  Nulls are
+	// otherwise the smallest values.
+	nullsFirst = "nullsfirst"
+	nullsLast  = "nullslast"
+)
+
+// sortKey is a column the records are sorted by.
+type sortKey struct {
+	param
+	order order
+	// nullsFirst places nulls before the other values.  The sql only
+	// places them when nulls is set, for keys that may be null or say
+	// where nulls go.
+	nullsFirst bool
+	nulls      bool
+}
+
+// defaultKey sorts the records when the values don't say how, tieKey
+// breaks the ties of other keys.
+var (
+	defaultKey = sortKey{param: param{name: FieldDefault, json: ColumnDefault, column: ColumnDefault, kind: reflect.Int64}, order: OrderDefault}
+	tieKey     = sortKey{param: param{name: FieldDefault, json: ColumnDefault, column: FieldDefault, kind: reflect.Int64}}
+)
+
+// orderFromValue parses a q-order value of comma separated keys, such as
+// desc-mealDate,asc-mealTime.  The records' IDs break any ties.
+func orderFromValue(src interface{}, value string) ([]sortKey, error) {
+	var (
+		keys     []sortKey
+		problems ParamErrors
+	)
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, &ParamError{Param: KeyOrder, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, part := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(part), "-")
+		if len(parts) != 2 && len(parts) != 3 {
+			fail("%q isn't in the format asc-field or asc-field-nullslast", part)
+			continue
+		}
+		p, ok := paramForField(src, parts[1])
+		if !ok {
+			fail("unknown field %q, the sortable fields are %s", parts[1], strings.Join(sortable(src), ", "))
+			continue
+		}
+		k := sortKey{param: p, order: order(parts[0]), nulls: p.nullable}
+		if k.order != asc && k.order != desc {
+			fail("ordering %q is invalid, must be asc or desc", parts[0])
+			continue
+		}
+		k.nullsFirst = k.order == asc
+		if len(parts) == 3 {
+			switch parts[2] {
+			case nullsFirst:
+				k.nullsFirst = true
+			case nullsLast:
+				k.nullsFirst = false
+			default:
+				fail("%q is invalid, must be %s or %s", parts[2], nullsFirst, nullsLast)
+				continue
+			}
+			k.nulls = true
+		}
+		for _, other := range keys {
+			if other.column == k.column {
+				fail("%q is ordered by more than once", parts[1])
+			}
+		}
+		keys = append(keys, k)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	for _, k := range keys {
+		if strings.EqualFold(k.column, ColumnDefault) {
+			return keys, nil
+		}
+	}
+	tie := tieKey
+	tie.order = keys[len(keys)-1].order
+	return append(keys, tie), nil
+}
+
+// sortable returns the json names of the fields of src records can be
+// sorted by.
+func sortable(src interface{}) []string {
+	var names []string
+	for _, p := range params(src) {
+		names = append(names, p.json)
+	}
+	return names
+}
+
+// reverse returns the key sorting the other way round, nulls included.
+func (k sortKey) reverse() sortKey {
+	k.order = k.order.reverse()
+	k.nullsFirst = !k.nullsFirst
+	return k
+}
+
+// orderBy returns the ORDER BY terms of the key.
+func (k sortKey) orderBy() []string {
+	column := "base." + k.column
+	terms := []string{column + " " + string(k.order)}
+	if !k.nulls {
+		return terms
+	}
+	placement := asc
+	if k.nullsFirst {
+		placement = desc
+	}
+	return append([]string{column + " IS NULL " + string(placement)}, terms...)
+}
+
+// after returns the condition of the records following the value in the
+// order of the key, empty if none can.
+func (k sortKey) after(value interface{}) (string, []interface{}) {
+	column := "base." + k.column
+	if value == nil {
+		if k.nullsFirst {
+			return column + " IS NOT NULL", nil
+		}
+		return "", nil
+	}
+	op := "<"
+	if k.order == asc {
+		op = ">"
+	}
+	if k.nulls && !k.nullsFirst {
+		return fmt.Sprintf("(%s %s ? OR %s IS NULL)", column, op, column), []interface{}{value}
+	}
+	return fmt.Sprintf("%s %s ?", column, op), []interface{}{value}
+}
+
+// equal returns the condition of the records with the value.
+func (k sortKey) equal(value interface{}) (string, []interface{}) {
+	if value == nil {
+		return "base." + k.column + " IS NULL", nil
+	}
+	return "base." + k.column + " = ?", []interface{}{value}
+}
+
+// seekPredicate returns the condition of the records following the
+// values of the keys in their order.
+func seekPredicate(keys []sortKey, values []interface{}) (string, []interface{}) {
+	var (
+		disjuncts []string
+		args      []interface{}
+		equal     []string
+		equalArgs []interface{}
+	)
+	for i, k := range keys {
+		if cond, condArgs := k.after(values[i]); cond != "" {
+			if len(equal) == 0 {
+				disjuncts = append(disjuncts, cond)
+			} else {
+				disjuncts = append(disjuncts, "("+strings.Join(append(equal[:len(equal):len(equal)], cond), " AND ")+")")
+			}
+			args = append(append(args, equalArgs...), condArgs...)
+		}
+		cond, condArgs := k.equal(values[i])
+		equal = append(equal, cond)
+		equalArgs = append(equalArgs, condArgs...)
+	}
+	if len(disjuncts) == 1 {
+		return disjuncts[0], args
+	}
+	return "(" + strings.Join(disjuncts, " OR ") + ")", args
+}
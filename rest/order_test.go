@@ -0,0 +1,111 @@
+package rest
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRated struct {
+	ID       int64  `json:"id"`
+	Deleted  bool   `json:"deleted"`
+	Calories int64  `json:"calories"`
+	Name     string `json:"name"`
+	Rating   *int64 `json:"rating"`
+}
+
+func TestOrder(t *testing.T) {
+	for value, orderBy := range map[string]string{
+		"":                               "base.id desc",
+		"desc-calories,asc-name":         "base.Calories desc, base.Name asc, base.ID asc",
+		"asc-name, desc-id":              "base.Name asc, base.ID desc",
+		"asc-name-nullslast":             "base.Name IS NULL asc, base.Name asc, base.ID asc",
+		"asc-rating":                     "base.Rating IS NULL desc, base.Rating asc, base.ID asc",
+		"desc-rating":                    "base.Rating IS NULL asc, base.Rating desc, base.ID desc",
+		"desc-rating-nullsfirst":         "base.Rating IS NULL desc, base.Rating desc, base.ID desc",
+		"desc-calories,asc-id,desc-name": "base.Calories desc, base.ID asc, base.Name desc",
+	} {
+		q, err := Query(&testRated{}, "test_rated", url.Values{KeyOrder: {value}})
+		if !assert.NoError(t, err, value) {
+			continue
+		}
+		sql, _, err := q.Query.ToSql()
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT base.* FROM test_rated as base WHERE base.Deleted = ? ORDER BY "+orderBy, sql, value)
+	}
+
+	for value, message := range map[string]string{
+		"asc-colour":            `unknown field "colour", the sortable fields are id, deleted, calories, name, rating`,
+		"calories":              `"calories" isn't in the format asc-field or asc-field-nullslast`,
+		"up-calories":           `ordering "up" is invalid, must be asc or desc`,
+		"asc-rating-nullsnever": `"nullsnever" is invalid, must be nullsfirst or nullslast`,
+		"asc-name,desc-name":    `"name" is ordered by more than once`,
+	} {
+		_, err := Query(&testRated{}, "test_rated", url.Values{KeyOrder: {value}})
+		if e, ok := err.(ParamErrors); assert.True(t, ok, value) && assert.Len(t, e, 1) {
+			assert.Equal(t, KeyOrder, e[0].Param)
+			assert.Equal(t, message, e[0].Message)
+		}
+	}
+}
+
+func TestMultiKeyCursors(t *testing.T) {
+	rating := int64(4)
+	records := []interface{}{
+		&testRated{ID: 1, Calories: 300, Rating: &rating},
+		&testRated{ID: 2, Calories: 300},
+		&testRated{ID: 3, Calories: 200},
+	}
+	values := url.Values{KeyOrder: {"desc-calories,asc-rating-nullslast"}}
+	q, err := Query(&testRated{}, "test_rated", values)
+	assert.NoError(t, err)
+
+	// case 1: a page ending with a rated record continues with greater
+	// ratings, then nulls, then lower calories
+	_, _, next, err := q.Page(records[:2], 1)
+	assert.NoError(t, err)
+	values.Set(KeyAfter, next)
+	q, err = Query(&testRated{}, "test_rated", values)
+	assert.NoError(t, err)
+	sql, args, err := q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_rated as base WHERE base.Deleted = ? AND "+
+		"(base.Calories < ? OR (base.Calories = ? AND (base.Rating > ? OR base.Rating IS NULL)) OR "+
+		"(base.Calories = ? AND base.Rating = ? AND base.ID > ?)) "+
+		"ORDER BY base.Calories desc, base.Rating IS NULL asc, base.Rating asc, base.ID asc", sql)
+	assert.Equal(t, []interface{}{false, int64(300), int64(300), int64(4), int64(300), int64(4), int64(1)}, args)
+
+	// case 2: a page ending with a null rating continues with the other
+	// nulls only
+	page, prev, next, err := q.Page(records[1:], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, records[1:2], page)
+	values.Set(KeyAfter, next)
+	q, err = Query(&testRated{}, "test_rated", values)
+	assert.NoError(t, err)
+	sql, args, err = q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_rated as base WHERE base.Deleted = ? AND "+
+		"(base.Calories < ? OR (base.Calories = ? AND base.Rating IS NULL AND base.ID > ?)) "+
+		"ORDER BY base.Calories desc, base.Rating IS NULL asc, base.Rating asc, base.ID asc", sql)
+	assert.Equal(t, []interface{}{false, int64(300), int64(300), int64(2)}, args)
+
+	// case 3: the previous page is selected in reverse, nulls first
+	values.Del(KeyAfter)
+	values.Set(KeyBefore, prev)
+	q, err = Query(&testRated{}, "test_rated", values)
+	assert.NoError(t, err)
+	sql, args, err = q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_rated as base WHERE base.Deleted = ? AND "+
+		"(base.Calories > ? OR (base.Calories = ? AND base.Rating IS NOT NULL) OR "+
+		"(base.Calories = ? AND base.Rating IS NULL AND base.ID < ?)) "+
+		"ORDER BY base.Calories asc, base.Rating IS NULL desc, base.Rating desc, base.ID desc", sql)
+	assert.Equal(t, []interface{}{false, int64(300), int64(300), int64(300), int64(2)}, args)
+
+	// case 4: cursors only work with the keys they were made for
+	values.Set(KeyOrder, "desc-calories")
+	_, err = Query(&testRated{}, "test_rated", values)
+	assert.Error(t, err)
+}
@@ -0,0 +1,294 @@
+package rest
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// reserved are the query parameters that aren't conditions on fields.
+var reserved = map[string]bool{
+	KeyOrder:   true,
+	KeyLimit:   true,
+	KeyOffset:  true,
+	KeyExpand:  true,
+	KeyExclude: true,
+	KeyAfter:   true,
+	KeyBefore:  true,
+	KeyCount:   true,
+	KeyFilter:  true,
+	KeyFields:  true,
+}
+
+// ParamError is a problem with a query parameter.
+type ParamError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("rest: %s: %s", e.Param, e.Message)
+}
+
+// ParamErrors are the problems with the query parameters of a request.
+type ParamErrors []*ParamError
+
+func (e ParamErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// param is a field of a model that query parameters can refer to.
+// Nullable fields are pointers to their kind.
+type param struct {
+	name     string
+	json     string
+	column   string
+	kind     reflect.Kind
+	nullable bool
+}
+
+// hidden is a source of records some fields of which query parameters
+// can't refer to, see Without.
+type hidden struct {
+	src    interface{}
+	fields []string
+}
+
+// Without returns src for Query, but without the fields, by their json
+// names, so query parameters can't filter or sort by them.  Callers hide
+// the fields the client can't read.
+func Without(src interface{}, fields []string) interface{} {
+	if len(fields) == 0 {
+		return src
+	}
+	return &hidden{src: src, fields: fields}
+}
+
+// unwrap returns the source of records of src, see Without.
+func unwrap(src interface{}) interface{} {
+	if h, ok := src.(*hidden); ok {
+		return h.src
+	}
+	return src
+}
+
+// params returns the fields of src, and of its embedded structs, that
+// query parameters can refer to.  Fields that aren't stored, aren't part
+// of the api or aren't scalars aren't parameters.
+func params(src interface{}) []param {
+	if h, ok := src.(*hidden); ok {
+		var ps []param
+		for _, p := range params(h.src) {
+			if !containsFold(h.fields, p.json) {
+				ps = append(ps, p)
+			}
+		}
+		return ps
+	}
+	var ps []param
+	t := elemType(reflect.TypeOf(src))
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			ps = append(ps, params(reflect.Zero(field.Type).Interface())...)
+			continue
+		}
+		column, ok := storedColumn(field)
+		if !ok {
+			continue
+		}
+		p := param{name: field.Name, json: jsonName(field), column: column}
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft, p.nullable = ft.Elem(), true
+		}
+		switch kind := ft.Kind(); kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			p.kind = reflect.Int64
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			p.kind = reflect.Uint64
+		case reflect.Float32, reflect.Float64:
+			p.kind = reflect.Float64
+		case reflect.Bool, reflect.String:
+			p.kind = kind
+		default:
+			continue
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// paramForField returns the parameter of src named key, by its field,
+// json or column name in any case.
+func paramForField(src interface{}, key string) (param, bool) {
+	for _, p := range params(src) {
+		if strings.EqualFold(p.name, key) || strings.EqualFold(p.json, key) || strings.EqualFold(p.column, key) {
+			return p, true
+		}
+	}
+	return param{}, false
+}
+
+// parse converts the query string value to the type of the field.
+// Timestamps are integers of milliseconds.
+func (p param) parse(value string) (interface{}, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch p.kind {
+	case reflect.Int64:
+		v, err = strconv.ParseInt(value, 10, 64)
+	case reflect.Uint64:
+		v, err = strconv.ParseUint(value, 10, 64)
+	case reflect.Float64:
+		v, err = strconv.ParseFloat(value, 64)
+	case reflect.Bool:
+		v, err = strconv.ParseBool(value)
+	default:
+		v = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q isn't a valid %s", value, p.typeName())
+	}
+	return v, nil
+}
+
+// convert converts a parsed filter value to the type of the field.
+func (p param) convert(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int64:
+		switch p.kind {
+		case reflect.Int64:
+			return v, true
+		case reflect.Uint64:
+			return uint64(v), v >= 0
+		case reflect.Float64:
+			return float64(v), true
+		}
+	case float64:
+		return v, p.kind == reflect.Float64
+	case bool:
+		return v, p.kind == reflect.Bool
+	case string:
+		return v, p.kind == reflect.String
+	}
+	return nil, false
+}
+
+func (p param) typeName() string {
+	switch p.kind {
+	case reflect.Int64, reflect.Uint64:
+		return "integer"
+	case reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+// WhereValueForKey restricts the builder by the query parameter.  Keys
+// are a field for equality, an operator and a field, such as lt-calories,
+// or bet and the start and end fields of a range, with the value then
+// the bounds separated by a dash.  Values are parsed as the type of their
+// field and always bound as arguments.
+func WhereValueForKey(builder squirrel.SelectBuilder, iFace interface{}, key, value string, alias ...interface{}) (squirrel.SelectBuilder, error) {
+	aliasString := "base."
+	if len(alias) > 0 {
+		aliasString = alias[0].(string)
+	}
+	fail := func(format string, args ...interface{}) (squirrel.SelectBuilder, error) {
+		return builder, &ParamError{Param: key, Message: fmt.Sprintf(format, args...)}
+	}
+	lookup := func(name string) (param, bool) {
+		p, ok := paramForField(iFace, name)
+		p.column = aliasString + p.column
+		return p, ok
+	}
+
+	keyParts := strings.Split(key, "-")
+	op := operator("eq")
+	if len(keyParts) > 1 {
+		op = operator(keyParts[0])
+	}
+	switch {
+	case len(keyParts) == 3 && op == between:
+		start, ok := lookup(keyParts[1])
+		if !ok {
+			return fail("unknown field %q", keyParts[1])
+		}
+		end, ok := lookup(keyParts[2])
+		if !ok {
+			return fail("unknown field %q", keyParts[2])
+		}
+		if start.kind != reflect.Int64 || end.kind != reflect.Int64 {
+			return fail("bet only applies to integer fields")
+		}
+		bounds := strings.Split(value, "-")
+		if len(bounds) != 2 {
+			return fail("%q isn't a range such as 100-200", value)
+		}
+		from, err := start.parse(bounds[0])
+		if err != nil {
+			return fail("%s", err)
+		}
+		to, err := start.parse(bounds[1])
+		if err != nil {
+			return fail("%s", err)
+		}
+		s, e := start.column, end.column
+		where := fmt.Sprintf("((%s >= ? AND %s <= ?) OR (%s >= ? AND %s <= ?) OR (%s <= ? AND %s > 0 AND (%s = 0 OR %s >= ?)))",
+			s, s, e, e, s, s, e, e)
+		return builder.Where(where, from, to, from, to, from, to), nil
+	case len(keyParts) > 2:
+		return fail("unknown parameter")
+	}
+
+	p, ok := lookup(keyParts[len(keyParts)-1])
+	if !ok {
+		return fail("unknown field %q", keyParts[len(keyParts)-1])
+	}
+	switch op {
+	case in, nin:
+		var values []interface{}
+		for _, s := range strings.Split(value, ",") {
+			v, err := p.parse(s)
+			if err != nil {
+				return fail("%s", err)
+			}
+			values = append(values, v)
+		}
+		if op == in {
+			return builder.Where(squirrel.Eq{p.column: values}), nil
+		}
+		for _, v := range values {
+			builder = builder.Where("NOT "+p.column+" <=> ?", v)
+		}
+		return builder, nil
+	case like:
+		if p.kind != reflect.String {
+			return fail("like only applies to text fields")
+		}
+	}
+	sqlOp, ok := comparisons[op]
+	if !ok {
+		return fail("unknown operator %q", op)
+	}
+	v, err := p.parse(value)
+	if err != nil {
+		return fail("%s", err)
+	}
+	return builder.Where(p.column+" "+sqlOp+" ?", v), nil
+}
@@ -0,0 +1,191 @@
+package rest
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRange struct {
+	ID       int64
+	Deleted  bool
+	Start    int64
+	End      int64 `db:"finish"`
+	Ratio    float64
+	Secret   string `json:"-"`
+	Computed string `db:"-"`
+}
+
+func TestWhereValueForKey(t *testing.T) {
+	for _, c := range []struct {
+		key, value string
+		sql        string
+		args       []interface{}
+	}{
+		{"start", "10", "base.Start = ?", []interface{}{int64(10)}},
+		{"gte-ratio", "0.5", "base.Ratio >= ?", []interface{}{0.5}},
+		{"lt-end", "10", "base.finish < ?", []interface{}{int64(10)}},
+		{"deleted", "true", "base.Deleted = ?", []interface{}{true}},
+		{"in-id", "1,2", "base.ID IN (?,?)", []interface{}{int64(1), int64(2)}},
+		{"nin-id", "1", "NOT base.ID <=> ?", []interface{}{int64(1)}},
+		{"bet-start-end", "100-200", "((base.Start >= ? AND base.Start <= ?) OR (base.finish >= ? AND base.finish <= ?) OR " +
+			"(base.Start <= ? AND base.Start > 0 AND (base.finish = 0 OR base.finish >= ?)))",
+			[]interface{}{int64(100), int64(200), int64(100), int64(200), int64(100), int64(200)}},
+	} {
+		b, err := WhereValueForKey(squirrel.Select("*").From("t"), &testRange{}, c.key, c.value)
+		if !assert.NoError(t, err, c.key) {
+			continue
+		}
+		sql, args, err := b.ToSql()
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM t WHERE "+c.sql, sql, c.key)
+		assert.Equal(t, c.args, args, c.key)
+	}
+
+	for _, c := range []struct{ key, value string }{
+		{"start", "yesterday"},
+		{"deleted", "maybe"},
+		{"lt-start", "1 OR 1=1"},
+		{"in-id", "1,two"},
+		{"bet-start-end", "100"},
+		{"bet-start-end", "100); DROP TABLE t; --"},
+		{"bet-start-ratio", "1-2"},
+		{"like-start", "1%"},
+		{"secret", "x"},
+		{"computed", "x"},
+		{"unknown", "x"},
+		{"foo-start", "1"},
+		{"a-b-c-d", "1"},
+	} {
+		_, err := WhereValueForKey(squirrel.Select("*").From("t"), &testRange{}, c.key, c.value)
+		if e, ok := err.(*ParamError); assert.True(t, ok, "%s=%s: %v", c.key, c.value, err) {
+			assert.Equal(t, c.key, e.Param)
+		}
+	}
+}
+
+func TestQueryParamErrors(t *testing.T) {
+	_, err := Query(&testRange{}, "ranges", url.Values{
+		"start": {"soon"}, "lt-end": {"1"}, "nope": {"1"}, KeyOffset: {"-1"},
+	})
+	assert.Equal(t, ParamErrors{{Param: KeyOffset, Message: "must be a non-negative integer"}}, err)
+	_, err = Query(&testRange{}, "ranges", url.Values{"start": {"soon"}, "lt-end": {"1"}, "nope": {"1"}})
+	if e, ok := err.(ParamErrors); assert.True(t, ok) && assert.Len(t, e, 2) {
+		assert.Equal(t, "nope", e[0].Param)
+		assert.Equal(t, "start", e[1].Param)
+	}
+}
+
+func TestWithout(t *testing.T) {
+	src := Without(&testRange{}, []string{"ratio"})
+	for key, values := range map[string]url.Values{
+		"ratio":     {"ratio": {"0.5"}},
+		KeyFilter:   {KeyFilter: {"start lt 5 or Ratio gte 0.5"}},
+		KeyOrder:    {KeyOrder: {"asc-ratio"}},
+		"gte-ratio": {"gte-ratio": {"0.5"}},
+	} {
+		_, err := Query(src, "test_ranges", values)
+		assert.Error(t, err, key)
+	}
+	q, err := Query(src, "test_ranges", url.Values{KeyFields: {"ratio"}, "start": {"1"}})
+	assert.NoError(t, err)
+	sql, _, err := q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.ID, base.Ratio FROM test_ranges as base WHERE base.Deleted = ? AND base.Start = ? ORDER BY base.id desc", sql)
+}
+
+var (
+	sqlToken = regexp.MustCompile(`^(\s+|\?|[(),*]|[=<>]+|[0-9]+|[A-Za-z_][A-Za-z_0-9]*(\.([A-Za-z_][A-Za-z_0-9]*|\*))?)`)
+	sqlWords = map[string]bool{}
+)
+
+func init() {
+	for _, w := range strings.Fields("SELECT COUNT FROM as WHERE AND OR NOT IN IS NULL LIKE ORDER BY asc desc " +
+		"OFFSET test_ranges base base.* base.ID base.Deleted base.Start base.finish base.Ratio base.id") {
+		sqlWords[w] = true
+	}
+}
+
+// assertBound checks that the sql is made of keywords, columns and
+// placeholders only, with an argument for each placeholder.
+func assertBound(t *testing.T, sql string, args []interface{}) {
+	assert.Equal(t, strings.Count(sql, "?"), len(args), sql)
+	for remaining := sql; remaining != ""; {
+		token := sqlToken.FindString(remaining)
+		if token == "" {
+			t.Fatalf("unexpected sql at %q in %q", remaining, sql)
+		}
+		first := token[0]
+		if first == '_' || first >= 'A' && first <= 'Z' || first >= 'a' && first <= 'z' {
+			if !sqlWords[token] {
+				t.Fatalf("unexpected word %q in %q", token, sql)
+			}
+		}
+		remaining = remaining[len(token):]
+	}
+}
+
+func FuzzQuery(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"start", "10"},
+		{"lt-end", "10"},
+		{"in-id", "1,2,3"},
+		{"nin-id", "1"},
+		{"bet-start-end", "1-2"},
+		{"bet-start-end", "1); DROP TABLE ranges; --"},
+		{"deleted", "true"},
+		{"like-secret", "%"},
+		{"start", "1' OR '1'='1"},
+		{KeyFilter, "start lt 5 or not (ratio gte 0.5 and id in (1, 2))"},
+		{KeyFilter, "start eq '1'' or 1=1 --'"},
+		{KeyOrder, "asc-start"},
+		{KeyOrder, "desc-start,asc-ratio-nullslast"},
+		{KeyOffset, "20"},
+		{KeyFields, "start,end,computed"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, key, value string) {
+		q, err := Query(&testRange{}, "test_ranges", url.Values{key: {value}})
+		if err != nil {
+			return
+		}
+		for _, b := range []squirrel.SelectBuilder{q.Query, q.CountQuery} {
+			sql, args, err := b.ToSql()
+			if err != nil {
+				continue
+			}
+			assertBound(t, sql, args)
+		}
+	})
+}
+
+func FuzzParseFilter(f *testing.F) {
+	for _, seed := range []string{
+		"start lt 5",
+		"start lt 5 or ratio gte 0.5",
+		"not (id in (1, 2, 3) and deleted eq false)",
+		"start is not null",
+		`start eq "1\" or 1=1 --"`,
+		"start lt 5; DROP TABLE ranges",
+		"((((start lt 1))))",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, filter string) {
+		node, err := ParseFilter(&testRange{}, filter)
+		if err != nil {
+			if _, ok := err.(*FilterError); !ok {
+				t.Fatalf("%q: unexpected error %v", filter, err)
+			}
+			return
+		}
+		sql, args, err := node.ToSql()
+		assert.NoError(t, err)
+		assertBound(t, sql, args)
+	})
+}
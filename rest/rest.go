@@ -1,9 +1,13 @@
 package rest
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -37,23 +41,84 @@ const (
 	KeyOffset  = "q-offset"
 	KeyExpand  = "q-expand"
 	KeyExclude = "q-exclude"
+	// KeyAfter and KeyBefore are the cursors of the records the page
+	// follows or precedes.
+	KeyAfter  = "q-after"
+	KeyBefore = "q-before"
+	// KeyCount asks for the total number of records when "true".
+	KeyCount = "q-count"
 )
 
 const (
-	LimitDefault  = 10000
+	LimitDefault  = 100
+	LimitMax      = 1000
 	OrderDefault  = desc
 	ColumnDefault = "id"
+	FieldDefault  = "ID"
 )
 
 type quantifiedQuery struct {
 	CountQuery squirrel.SelectBuilder
 	Query      squirrel.SelectBuilder
+	// Fields narrow the records, nil for all of them.
+	Fields *Fieldset
+
+	// keys are what the records are sorted by, ending with ID to break
+	// the ties of the others.
+	keys []sortKey
+	// cursor is the record the page follows, or precedes when before is
+	// set; the query then selects the page in reverse order.
+	cursor *cursor
+	before bool
+	offset uint64
+}
+
+// cursor is the position of a record in the order of a query, the
+// values of the record's columns the query is sorted by.
+type cursor struct {
+	Columns []string      `json:"c"`
+	Values  []interface{} `json:"v"`
 }
 
-func Query(src interface{}, tableName string, values url.Values) (*quantifiedQuery, error) {
-	q := &quantifiedQuery{
-		CountQuery: squirrel.Select("COUNT(base.*)").From(fmt.Sprintf("%s as base", tableName)),
-		Query:      squirrel.Select("base.*").From(fmt.Sprintf("%s as base", tableName)),
+// Query builds the list query of the table from the query string values.
+// The filters, such as the records a user may access, restrict both the
+// query and the count whatever the values ask for.
+func Query(src interface{}, tableName string, values url.Values, filters ...squirrel.Sqlizer) (*quantifiedQuery, error) {
+	q := &quantifiedQuery{keys: []sortKey{defaultKey}}
+	if oVal := values.Get(KeyOrder); oVal != "" {
+		keys, err := orderFromValue(src, oVal)
+		if err != nil {
+			return nil, err
+		}
+		q.keys = keys
+	}
+
+	// Select the columns of the fields asked for, and the keys' for the
+	// cursors.  Expanding records needs all of their columns.
+	fields, err := ParseFields(unwrap(src), values.Get(KeyFields), values.Get(KeyExpand) == "true")
+	if err != nil {
+		return nil, err
+	}
+	q.Fields = fields
+	columns := []string{"base.*"}
+	if !fields.Expands() {
+		columns = nil
+		for _, c := range fields.Columns {
+			columns = append(columns, "base."+c)
+		}
+		for _, k := range q.keys {
+			if !containsFold(fields.Columns, k.column) {
+				columns = append(columns, "base."+k.column)
+			}
+		}
+	}
+	q.CountQuery = squirrel.Select("COUNT(*)").From(fmt.Sprintf("%s as base", tableName))
+	q.Query = squirrel.Select(columns...).From(fmt.Sprintf("%s as base", tableName))
+	for _, filter := range filters {
+		if filter != nil {
+			q.CountQuery = q.CountQuery.Where(filter)
+			q.Query = q.Query.Where(filter)
+		}
 	}
 
 	// Check for deleted flag, if not present default to deleted = false.
@@ -65,175 +130,253 @@ func Query(src interface{}, tableName string, values url.Values) (*quantifiedQue
 	if values.Get(KeyOffset) != "" {
 		offset, err := strconv.ParseUint(values.Get(KeyOffset), 10, 64)
 		if err != nil {
-			return q, errors.Wrap(err, "error in parsing offset from string values")
+			return q, ParamErrors{{Param: KeyOffset, Message: "must be a non-negative integer"}}
 		}
+		q.offset = offset
 		q.Query = q.Query.Offset(offset)
 	}
 
-	// Set the WHERE clause.
-	var err error
-	for key, value := range values {
-		q.CountQuery, err = WhereValueForKey(q.CountQuery, src, key, value[0])
-		if err != nil {
-			return nil, err
+	// Set the WHERE clause, in the order of the keys so the sql is stable.
+	var (
+		keys     []string
+		problems ParamErrors
+	)
+	for key := range values {
+		if !reserved[key] {
+			keys = append(keys, key)
 		}
-		q.Query, err = WhereValueForKey(q.Query, src, key, value[0])
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range values[key] {
+			var err error
+			if q.Query, err = WhereValueForKey(q.Query, src, key, value); err != nil {
+				problems = append(problems, err.(*ParamError))
+				continue
+			}
+			q.CountQuery, _ = WhereValueForKey(q.CountQuery, src, key, value)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	if f := values.Get(KeyFilter); f != "" {
+		filter, err := ParseFilter(src, f)
 		if err != nil {
 			return nil, err
 		}
+		q.CountQuery = q.CountQuery.Where(filter)
+		q.Query = q.Query.Where(filter)
 	}
 
-	// Set the ORDER BY clause.
-	q.CountQuery, err = setOrderBy(src, values, q.CountQuery, "base.")
-	if err != nil {
+	if err := q.seek(values); err != nil {
 		return nil, err
 	}
-	q.Query, err = setOrderBy(src, values, q.Query, "base.")
-	if err != nil {
-		return nil, err
+
+	// Set the ORDER BY clause, the count is unordered.
+	for _, k := range q.sortKeys() {
+		q.Query = q.Query.OrderBy(k.orderBy()...)
 	}
 
 	return q, nil
 }
 
-func setOrderBy(src interface{}, values url.Values, builder squirrel.SelectBuilder, alias ...interface{}) (squirrel.SelectBuilder, error) {
-	aliasString := ""
-	if len(alias) > 0 {
-		aliasString = alias[0].(string)
+// seek restricts the query to the records after or before the cursor in
+// the values.
+func (q *quantifiedQuery) seek(values url.Values) error {
+	after, before := values.Get(KeyAfter), values.Get(KeyBefore)
+	if after == "" && before == "" {
+		return nil
 	}
-	if oVal := values.Get(KeyOrder); oVal != "" {
-		field, order, err := orderFromValue(src, oVal)
-		if err != nil {
-			return builder, err
+	if after != "" && before != "" {
+		return errors.Errorf("rest: %s and %s can't be combined", KeyAfter, KeyBefore)
+	}
+	if q.offset > 0 {
+		return errors.Errorf("rest: %s can't be combined with a cursor", KeyOffset)
+	}
+	encoded := after
+	if before != "" {
+		encoded, q.before = before, true
+	}
+	c, err := decodeCursor(encoded)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for _, k := range q.keys {
+		columns = append(columns, k.column)
+	}
+	if len(c.Columns) != len(columns) || len(c.Values) != len(columns) {
+		return errors.Errorf("rest: cursor of %v used to order by %v", c.Columns, columns)
+	}
+	for i := range columns {
+		if !strings.EqualFold(c.Columns[i], columns[i]) {
+			return errors.Errorf("rest: cursor of %v used to order by %v", c.Columns, columns)
 		}
-		builder = builder.OrderBy(aliasString + field + " " + string(order))
-	} else {
-		builder = builder.OrderBy(aliasString + ColumnDefault + " " + string(OrderDefault))
 	}
-	return builder, nil
+	q.cursor = c
+	predicate, args := seekPredicate(q.sortKeys(), c.Values)
+	q.Query = q.Query.Where(predicate, args...)
+	return nil
 }
 
-func WhereValueForKey(builder squirrel.SelectBuilder, iFace interface{}, key, value string, alias ...interface{}) (squirrel.SelectBuilder, error) {
-	aliasString := ""
-	if len(alias) > 0 {
-		aliasString = alias[0].(string)
-	}
-	keyParts := strings.Split(key, "-")
-	sqlString := ""
-
-	switch len(keyParts) {
-	case 1:
-		if !structHasField(iFace, keyParts[0]) {
-			return builder, nil
-		}
-		sqlString = aliasString + keyParts[0] + " = ?"
-	case 2:
-		op := operator(keyParts[0])
-		field := keyParts[1]
-		if !structHasField(iFace, field) {
-			return builder, nil
-		}
-		field = aliasString + field
-		switch op {
-		case lt:
-			sqlString = field + " < ?"
-		case lte:
-			sqlString = field + " <= ?"
-		case gt:
-			sqlString = field + " > ?"
-		case gte:
-			sqlString = field + " >= ?"
-		case like:
-			sqlString = field + " LIKE ?"
-		case in:
-			values := strings.Split(value, ",")
-			builder = builder.Where(squirrel.Eq{"base." + field: values})
-			return builder, nil
-		case nin:
-			values := strings.Split(value, ",")
-			for _, v := range values {
-				builder = builder.Where("NOT "+"base."+field+" <=> ?", v)
-			}
-			return builder, nil
-		default:
-			return builder, nil
-		}
-	case 3:
-		op := operator(keyParts[0])
-		fieldStart := keyParts[1]
-		fieldEnd := keyParts[2]
-		if !structHasField(iFace, fieldStart) {
-			return builder, nil
-		}
-		if !structHasField(iFace, fieldEnd) {
-			return builder, nil
-		}
-		fieldStart = aliasString + fieldStart
-		fieldEnd = aliasString + fieldEnd
-		switch op {
-		case between:
-			values := strings.Split(value, "-")
-			whereCluase := fmt.Sprintf("( (%s >= %s AND %s <= %s) OR (%s >= %s AND %s <= %s) OR ( %s <= %s AND %s > 0 AND (%s = 0 OR %s >= %s)))",
-				"base."+fieldStart, values[0], "base."+fieldStart, values[1],
-				"base."+fieldEnd, values[0], "base."+fieldEnd, values[1],
-				fieldStart, values[0], fieldStart, fieldEnd, fieldEnd, values[1])
-			builder = builder.Where(whereCluase)
-			return builder, nil
-		default:
-			return builder, nil
-		}
-	default:
-		return builder, nil
+// sortKeys returns the keys in the order the query selects the records,
+// reversed when selecting the page before a cursor.
+func (q *quantifiedQuery) sortKeys() []sortKey {
+	if !q.before {
+		return q.keys
 	}
+	keys := make([]sortKey, len(q.keys))
+	for i, k := range q.keys {
+		keys[i] = k.reverse()
+	}
+	return keys
+}
 
-	builder = builder.Where(sqlString, value)
-	return builder, nil
+// Page trims the records, selected with a limit of one more than the page
+// size, to the page in order and returns the cursors of the previous and
+// next pages, empty when there is no such page.
+func (q *quantifiedQuery) Page(records []interface{}, limit uint64) ([]interface{}, string, string, error) {
+	more := uint64(len(records)) > limit
+	if more {
+		records = records[:limit]
+	}
+	if q.before {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+	if len(records) == 0 {
+		return records, "", "", nil
+	}
+	hasPrev, hasNext := q.cursor != nil || q.offset > 0, more
+	if q.before {
+		hasPrev, hasNext = more, true
+	}
+	var prev, next string
+	var err error
+	if hasPrev {
+		if prev, err = q.encodeCursor(records[0]); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if hasNext {
+		if next, err = q.encodeCursor(records[len(records)-1]); err != nil {
+			return nil, "", "", err
+		}
+	}
+	return records, prev, next, nil
 }
 
-func orderFromValue(src interface{}, value string) (string, order, error) {
-	parts := strings.Split(value, "-")
+// encodeCursor returns the opaque cursor of the record.
+func (q *quantifiedQuery) encodeCursor(record interface{}) (string, error) {
+	c := &cursor{}
+	for _, k := range q.keys {
+		value, ok := fieldValue(record, k.name)
+		if !ok {
+			return "", errors.Errorf("rest: record has no %s", k.name)
+		}
+		c.Columns = append(c.Columns, k.column)
+		c.Values = append(c.Values, value)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "rest: error in encoding cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	// return error if not in the format asc-field
-	if len(parts) != 2 {
-		return "", asc, errors.New("mware: q-order format invalid")
+func decodeCursor(encoded string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "rest: invalid cursor")
+	}
+	c := &cursor{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(c); err != nil {
+		return nil, errors.Wrap(err, "rest: invalid cursor")
+	}
+	for i, value := range c.Values {
+		switch v := value.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				c.Values[i] = n
+			} else if c.Values[i], err = v.Float64(); err != nil {
+				return nil, errors.Wrap(err, "rest: invalid cursor")
+			}
+		case string, bool, nil:
+		default:
+			return nil, errors.New("rest: invalid cursor value")
+		}
 	}
+	return c, nil
+}
 
-	// return error field isn't in struct
-	if !structHasField(src, parts[1]) {
-		message := fmt.Sprintf("mware: q-order field, %v, isn't present in model", parts[1])
-		return "", asc, errors.New(message)
+// Links returns the Link header pointing from the url to the previous and
+// next pages, empty when there are none.
+func Links(u *url.URL, prev, next string) string {
+	var links []string
+	for _, l := range []struct{ cursor, key, rel string }{{prev, KeyBefore, "prev"}, {next, KeyAfter, "next"}} {
+		if l.cursor == "" {
+			continue
+		}
+		values := u.Query()
+		values.Del(KeyAfter)
+		values.Del(KeyBefore)
+		values.Del(KeyOffset)
+		values.Set(l.key, l.cursor)
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, u.Path, values.Encode(), l.rel))
 	}
+	return strings.Join(links, ", ")
+}
 
-	// return error if ordering is invalid
-	order := order(parts[0])
-	if order != asc && order != desc {
-		message := fmt.Sprintf("mware: q-order ordering, %v, is invalid.  Must use asc or desc.", parts[0])
-		return "", asc, errors.New(message)
+// Limit returns the page size the values ask for, LimitDefault when they
+// don't and at most LimitMax.
+func Limit(values url.Values) uint64 {
+	limit := UintFromKey(values, KeyLimit, LimitDefault)
+	if limit == 0 {
+		return LimitDefault
 	}
+	if limit > LimitMax {
+		return LimitMax
+	}
+	return limit
+}
 
-	return parts[1], order, nil
+func (o order) reverse() order {
+	if o == asc {
+		return desc
+	}
+	return asc
 }
 
-func structHasField(src interface{}, key string) bool {
-	objT := reflect.Indirect(reflect.ValueOf(src)).Type()
-	for i := 0; i < objT.NumField(); i++ {
-		field := objT.Field(i)
-		if isStruct(field) {
-			if structHasField(reflect.Zero(field.Type).Interface(), key) {
-				return true
+// fieldValue returns the value of the field of the record, or of its
+// embedded structs, named key in any case.
+func fieldValue(record interface{}, key string) (interface{}, bool) {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if v.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous {
+			if value, ok := fieldValue(v.Field(i).Interface(), key); ok {
+				return value, true
 			}
-		} else {
-			name := strings.ToLower(field.Name)
-			if name == strings.ToLower(key) {
-				return true
+		} else if strings.EqualFold(field.Name, key) && field.PkgPath == "" {
+			f := v.Field(i)
+			if f.Kind() == reflect.Ptr {
+				if f.IsNil() {
+					return nil, true
+				}
+				f = f.Elem()
 			}
+			return f.Interface(), true
 		}
 	}
-	return false
-}
-
-func isStruct(field reflect.StructField) bool {
-	return reflect.Indirect(reflect.Zero(field.Type)).Kind() == reflect.Struct
+	return nil, false
 }
 
 func UintFromKey(values url.Values, key string, d uint64) uint64 {
@@ -0,0 +1,114 @@
+package rest
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testModel struct {
+	ID       int64
+	Deleted  bool
+	Calories int64
+	Name     string
+}
+
+func TestQuery(t *testing.T) {
+	q, err := Query(&testModel{}, "test_models", url.Values{"calories": {"500"}})
+	assert.NoError(t, err)
+	sql, args, err := q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_models as base WHERE base.Deleted = ? AND base.Calories = ? ORDER BY base.id desc", sql)
+	assert.Equal(t, []interface{}{false, int64(500)}, args)
+	sql, _, err = q.CountQuery.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT COUNT(*) FROM test_models as base WHERE base.Deleted = ? AND base.Calories = ?", sql)
+
+	q, err = Query(&testModel{}, "test_models", url.Values{KeyOrder: {"asc-calories"}})
+	assert.NoError(t, err)
+	sql, _, err = q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_models as base WHERE base.Deleted = ? ORDER BY base.Calories asc, base.ID asc", sql)
+}
+
+func TestCursors(t *testing.T) {
+	records := []interface{}{
+		&testModel{ID: 5, Calories: 300},
+		&testModel{ID: 3, Calories: 500},
+		&testModel{ID: 4, Calories: 500},
+	}
+	values := url.Values{KeyOrder: {"asc-calories"}}
+	q, err := Query(&testModel{}, "test_models", values)
+	assert.NoError(t, err)
+
+	// case 1: the first page has a next page only
+	page, prev, next, err := q.Page(records, 2)
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Empty(t, prev)
+	assert.NotEmpty(t, next)
+
+	// case 2: the next page follows the last record, ties broken by id
+	values.Set(KeyAfter, next)
+	q, err = Query(&testModel{}, "test_models", values)
+	assert.NoError(t, err)
+	sql, args, err := q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_models as base WHERE base.Deleted = ? AND "+
+		"(base.Calories > ? OR (base.Calories = ? AND base.ID > ?)) ORDER BY base.Calories asc, base.ID asc", sql)
+	assert.Equal(t, []interface{}{false, int64(500), int64(500), int64(3)}, args)
+	page, prev, next, err = q.Page(records[2:], 2)
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.NotEmpty(t, prev)
+	assert.Empty(t, next)
+
+	// case 3: the previous page is selected in reverse and put back in order
+	values.Del(KeyAfter)
+	values.Set(KeyBefore, prev)
+	q, err = Query(&testModel{}, "test_models", values)
+	assert.NoError(t, err)
+	sql, args, err = q.Query.ToSql()
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT base.* FROM test_models as base WHERE base.Deleted = ? AND "+
+		"(base.Calories < ? OR (base.Calories = ? AND base.ID < ?)) ORDER BY base.Calories desc, base.ID desc", sql)
+	assert.Equal(t, []interface{}{false, int64(500), int64(500), int64(4)}, args)
+	page, prev, next, err = q.Page([]interface{}{records[1], records[0]}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, records[:2], page)
+	assert.Empty(t, prev)
+	assert.NotEmpty(t, next)
+
+	// case 4: cursors only work with the order they were made for
+	values.Del(KeyOrder)
+	_, err = Query(&testModel{}, "test_models", values)
+	assert.Error(t, err)
+
+	// case 5: invalid and conflicting cursors are rejected
+	for _, v := range []url.Values{
+		{KeyAfter: {"not a cursor"}},
+		{KeyAfter: {next}, KeyBefore: {next}},
+		{KeyOrder: {"asc-calories"}, KeyAfter: {next}, KeyOffset: {"10"}},
+	} {
+		_, err = Query(&testModel{}, "test_models", v)
+		assert.Error(t, err, "%v", v)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	u, err := url.Parse("/api/meals?q-limit=2&q-after=abc&q-offset=4")
+	assert.NoError(t, err)
+	assert.Equal(t, `</api/meals?q-before=prev&q-limit=2>; rel="prev", </api/meals?q-after=next&q-limit=2>; rel="next"`,
+		Links(u, "prev", "next"))
+	assert.Equal(t, `</api/meals?q-after=next&q-limit=2>; rel="next"`, Links(u, "", "next"))
+	assert.Empty(t, Links(u, "", ""))
+}
+
+func TestLimit(t *testing.T) {
+	assert.Equal(t, uint64(LimitDefault), Limit(url.Values{}))
+	assert.Equal(t, uint64(LimitDefault), Limit(url.Values{KeyLimit: {"0"}}))
+	assert.Equal(t, uint64(LimitDefault), Limit(url.Values{KeyLimit: {"-1"}}))
+	assert.Equal(t, uint64(20), Limit(url.Values{KeyLimit: {"20"}}))
+	assert.Equal(t, uint64(LimitMax), Limit(url.Values{KeyLimit: {"100000"}}))
+}
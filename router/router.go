@@ -33,15 +33,53 @@ func SetupRouters() http.Handler {
 
 	post(r, "/signup", handler.SignUp())
 	post(r, "/login", handler.Login())
+	post(r, "/login/2fa", handler.LoginTwoFactor())
+	post(r, "/login/2fa/setup", handler.LoginTwoFactorSetup())
+	post(r, "/token/refresh", handler.RefreshToken())
+	get(r, "/verify-email", handler.VerifyEmail())
+	post(r, "/password/forgot", handler.ForgotPassword())
+	post(r, "/password/reset", handler.ResetForgottenPassword())
 	subRouter := createSubRouter(r, "/api", mware.UserAuth())
 
-	get(subRouter, "/signout", handler.SignOut())
-	post(subRouter, "/user/{id}/resetPassword", handler.ResetPassword())
-	post(subRouter, "/createUser", handler.CreateUser())
-	get(subRouter, "/user/{id}/updateGroup/{groupId}", handler.UpdateUserGroup())
+	get(subRouter, "/signout", mware.SessionOnly(handler.SignOut()))
+	post(subRouter, "/verify-email/resend", mware.SessionOnly(mware.NotImpersonated(handler.ResendVerificationEmail())))
+	get(subRouter, "/sessions", mware.SessionOnly(handler.ListSessions()))
+	delete(subRouter, "/sessions", mware.SessionOnly(mware.NotImpersonated(handler.RevokeOtherSessions())))
+	delete(subRouter, "/sessions/{id}", mware.SessionOnly(mware.NotImpersonated(handler.RevokeSession())))
+	post(subRouter, "/user/{id}/resetPassword", mware.SessionOnly(mware.NotImpersonated(handler.ResetPassword())))
+	post(subRouter, "/createUser", mware.SessionOnly(handler.CreateUser()))
+	get(subRouter, "/user/{id}/updateGroup/{groupId}", mware.SessionOnly(handler.UpdateUserGroup()))
+	get(subRouter, "/user/{id}/groups", mware.SessionOnly(handler.GroupHistory()))
+	post(subRouter, "/user/{id}/2fa/setup", mware.SessionOnly(mware.NotImpersonated(handler.SetupTwoFactor())))
+	post(subRouter, "/user/{id}/2fa/verify", mware.SessionOnly(mware.NotImpersonated(handler.VerifyTwoFactor())))
+	post(subRouter, "/user/{id}/2fa/disable", mware.SessionOnly(mware.NotImpersonated(handler.DisableTwoFactor())))
+	get(subRouter, "/groups", mware.SessionOnly(handler.ListGroups()))
+	post(subRouter, "/groups", mware.SessionOnly(handler.CreateGroup()))
+	get(subRouter, "/groups/{id}", mware.SessionOnly(handler.GetGroup()))
+	put(subRouter, "/groups/{id}", mware.SessionOnly(handler.UpdateGroup()))
+	delete(subRouter, "/groups/{id}", mware.SessionOnly(handler.DeleteGroup()))
+	put(subRouter, "/groups/{id}/2fa", mware.SessionOnly(handler.RequireGroupTwoFactor()))
+	get(subRouter, "/login-attempts", mware.SessionOnly(handler.LoginAttempts()))
+	post(subRouter, "/user/{id}/unlock", mware.SessionOnly(handler.UnlockUser()))
+	post(subRouter, "/user/{id}/impersonate", mware.SessionOnly(handler.Impersonate()))
+	get(subRouter, "/me", mware.SessionOnly(handler.Me()))
+	post(subRouter, "/authz/check", mware.SessionOnly(handler.CheckAuthz()))
+	get(subRouter, "/apikeys", mware.SessionOnly(handler.ListAPIKeys()))
+	post(subRouter, "/apikeys", mware.SessionOnly(mware.NotImpersonated(handler.CreateAPIKey())))
+	delete(subRouter, "/apikeys/{id}", mware.SessionOnly(mware.NotImpersonated(handler.RevokeAPIKey())))
+	get(subRouter, "/shares", mware.SessionOnly(handler.ListShares()))
+	post(subRouter, "/shares", mware.SessionOnly(mware.NotImpersonated(handler.CreateShare())))
+	delete(subRouter, "/shares/{id}", mware.SessionOnly(mware.NotImpersonated(handler.RevokeShare())))
 
 	addRUD(subRouter, "/users", &acct.User{})
 	addCRUD(subRouter, "/meals", &model.Meal{})
+	addCRUD(subRouter, "/organizations", &acct.Organization{})
+	addCRUD(subRouter, "/teams", &acct.Team{})
+	get(subRouter, "/teams/{id}/members", mware.SessionOnly(handler.ListTeamMembers()))
+	put(subRouter, "/teams/{id}/members/{userId}", mware.SessionOnly(handler.SetTeamMember()))
+	delete(subRouter, "/teams/{id}/members/{userId}", mware.SessionOnly(handler.RemoveTeamMember()))
+	get(subRouter, "/audit", mware.GetAll(&acct.AuditEntry{}))
+	get(subRouter, "/audit/{id}", mware.GetByID(&acct.AuditEntry{}))
 
 	// add middleware common to all handlers
 	n := negroni.New(
@@ -50,6 +88,7 @@ func SetupRouters() http.Handler {
 		cors.New(cors.Options{
 			AllowedOrigins: []string{"*"},
 			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+			ExposedHeaders: []string{"Link", "X-Total-Count"},
 		}),
 	)
 	n.UseHandler(r)
@@ -0,0 +1,63 @@
+// Package sign creates and verifies tamper-proof tokens.  A token is the
+// base64 encoded JSON claims followed by their HMAC-SHA256 signature.  The
+// purpose is mixed into the signature so a token issued for one purpose,
+// such as verifying an email, can't be replayed for another.
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rahul2393/small-assignment-server/conf"
+)
+
+var (
+	// ErrInvalid occurs when a token is malformed or its signature
+	// doesn't match.
+	ErrInvalid = errors.New("sign: invalid token")
+
+	encoding = base64.RawURLEncoding
+)
+
+// Encode returns a token carrying the claims signed for the purpose.
+func Encode(purpose string, claims interface{}) (string, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "sign: error in encoding claims")
+	}
+	payload := encoding.EncodeToString(b)
+	return payload + "." + encoding.EncodeToString(mac(purpose, payload)), nil
+}
+
+// Decode verifies the token was signed for the purpose and decodes its
+// claims into claims.
+func Decode(purpose, token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ErrInvalid
+	}
+	sig, err := encoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, mac(purpose, parts[0])) {
+		return ErrInvalid
+	}
+	b, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalid
+	}
+	if err := json.Unmarshal(b, claims); err != nil {
+		return errors.Wrap(ErrInvalid, err.Error())
+	}
+	return nil
+}
+
+func mac(purpose, payload string) []byte {
+	h := hmac.New(sha256.New, []byte(conf.Get().Auth.SigningKey))
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
@@ -0,0 +1,39 @@
+package sign
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testClaims struct {
+	UserID int64
+	Email  string
+}
+
+func TestEncodeDecode(t *testing.T) {
+	token, err := Encode("test", testClaims{UserID: 1, Email: "test@gmail.com"})
+	assert.NoError(t, err)
+
+	claims := &testClaims{}
+	assert.NoError(t, Decode("test", token, claims))
+	assert.Equal(t, int64(1), claims.UserID)
+	assert.Equal(t, "test@gmail.com", claims.Email)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	token, err := Encode("test", testClaims{UserID: 1})
+	assert.NoError(t, err)
+
+	// signed for another purpose
+	assert.Equal(t, ErrInvalid, Decode("other", token, &testClaims{}))
+
+	// tampered claims
+	forged, err := Encode("test", testClaims{UserID: 2})
+	assert.NoError(t, err)
+	tampered := strings.Split(forged, ".")[0] + "." + strings.Split(token, ".")[1]
+	assert.Equal(t, ErrInvalid, Decode("test", tampered, &testClaims{}))
+
+	assert.Equal(t, ErrInvalid, Decode("test", "garbage", &testClaims{}))
+}
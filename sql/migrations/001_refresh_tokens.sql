@@ -0,0 +1,19 @@
+# noinspection SqlNoDataSourceInspectionForFile
+ALTER TABLE `tokens` ADD COLUMN `FamilyID` VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE `tokens` ADD INDEX `FamilyID` (`FamilyID` ASC);
+
+CREATE TABLE `refresh_tokens` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `AccessTokenID` BIGINT(20) NOT NULL,
+  `FamilyID` VARCHAR(32) NOT NULL,
+  `Hash` VARCHAR(255) NOT NULL,
+  `Expiration` BIGINT(20) NOT NULL,
+  `Used` TINYINT(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `UserID` (`UserID` ASC),
+  INDEX `FamilyID` (`FamilyID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
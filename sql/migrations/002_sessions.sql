@@ -0,0 +1,4 @@
+# noinspection SqlNoDataSourceInspectionForFile
+ALTER TABLE `tokens` ADD COLUMN `LastUsed` BIGINT(20) NOT NULL DEFAULT 0;
+ALTER TABLE `tokens` ADD COLUMN `UserAgent` VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE `tokens` ADD COLUMN `IP` VARCHAR(45) NOT NULL DEFAULT '';
@@ -0,0 +1,23 @@
+# noinspection SqlNoDataSourceInspectionForFile
+ALTER TABLE `users` ADD COLUMN `TwoFactorEnabled` tinyint(1) NOT NULL DEFAULT 0;
+ALTER TABLE `users` ADD COLUMN `TwoFactorSecret` VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE `users` ADD COLUMN `TwoFactorLastStep` bigint(20) NOT NULL DEFAULT 0;
+
+CREATE TABLE `recovery_codes` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `Hash` VARCHAR(255) NOT NULL,
+  `Used` TINYINT(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `UserID` (`UserID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `group_settings` (
+  `GroupID` BIGINT(20) NOT NULL,
+  `RequireTwoFactor` TINYINT(1) NOT NULL DEFAULT 0,
+  `Updated` BIGINT(20) NOT NULL,
+  PRIMARY KEY (`GroupID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,4 @@
+# noinspection SqlNoDataSourceInspectionForFile
+# Users signed up before email addresses were verified keep their access.
+ALTER TABLE `users` ADD COLUMN `EmailVerified` tinyint(1) NOT NULL DEFAULT 0;
+UPDATE `users` SET `EmailVerified` = 1;
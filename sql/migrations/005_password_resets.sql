@@ -0,0 +1,13 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `password_resets` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `Hash` VARCHAR(255) NOT NULL,
+  `Expiration` BIGINT(20) NOT NULL,
+  `Used` TINYINT(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `UserID` (`UserID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,16 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `login_attempts` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `Email` VARCHAR(255) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `IP` VARCHAR(45) NOT NULL,
+  `UserAgent` VARCHAR(255) NOT NULL,
+  `Result` VARCHAR(20) NOT NULL,
+  `Cleared` TINYINT(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `Email` (`Email` ASC, `Created` ASC),
+  INDEX `IP` (`IP` ASC, `Created` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
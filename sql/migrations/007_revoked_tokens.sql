@@ -0,0 +1,6 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `revoked_tokens` (
+  `TokenID` BIGINT(20) NOT NULL,
+  `Expiration` BIGINT(20) NOT NULL,
+  PRIMARY KEY (`TokenID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
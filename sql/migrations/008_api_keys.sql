@@ -0,0 +1,16 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `api_keys` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `Name` VARCHAR(255) NOT NULL,
+  `Hash` VARCHAR(255) NOT NULL,
+  `Expiration` BIGINT(20) NOT NULL DEFAULT 0,
+  `LastUsed` BIGINT(20) NOT NULL DEFAULT 0,
+  `ScopeList` VARCHAR(255) NOT NULL,
+  `AllowedIPList` TEXT NOT NULL,
+  PRIMARY KEY (`ID`),
+  INDEX `UserID` (`UserID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
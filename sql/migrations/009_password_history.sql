@@ -0,0 +1,9 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `password_history` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `Hash` VARCHAR(255) NOT NULL,
+  PRIMARY KEY (`ID`),
+  INDEX `UserID` (`UserID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,43 @@
+# noinspection SqlNoDataSourceInspectionForFile
+# Moves the groups and permissions that were compiled into the server to
+# the database.  The ids are those of the former constants so users keep
+# their rights.
+CREATE TABLE `permissions` (
+  `ID` BIGINT(20) NOT NULL,
+  `Name` VARCHAR(64) NOT NULL,
+  `TableName` VARCHAR(64) NOT NULL,
+  `Level` TINYINT(1) NOT NULL,
+  `IsAll` TINYINT(1) NOT NULL DEFAULT 0,
+  UNIQUE (`Name`),
+  PRIMARY KEY (`ID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `groups` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Name` VARCHAR(255) NOT NULL,
+  UNIQUE (`Name`),
+  PRIMARY KEY (`ID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `group_permissions` (
+  `GroupID` BIGINT(20) NOT NULL,
+  `PermissionID` BIGINT(20) NOT NULL,
+  PRIMARY KEY (`GroupID`, `PermissionID`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `permissions` (ID, Name, TableName, Level, IsAll) VALUES
+  (1, "read:users", "users", 1, 0),
+  (2, "read:all_users", "users", 1, 1),
+  (3, "write:users", "users", 2, 0),
+  (4, "write:all_users", "users", 2, 1),
+  (5, "read:meals", "meals", 1, 0),
+  (6, "read:all_meals", "meals", 1, 1),
+  (7, "write:meals", "meals", 2, 0),
+  (8, "write:all_meals", "meals", 2, 1);
+
+INSERT INTO `groups` (ID, Name) VALUES (1, "Admin"), (2, "UserManager"), (3, "Regular");
+
+INSERT INTO `group_permissions` (GroupID, PermissionID) VALUES
+  (1, 2), (1, 4), (1, 6), (1, 8),
+  (2, 2), (2, 4), (2, 5), (2, 7),
+  (3, 1), (3, 5), (3, 7);
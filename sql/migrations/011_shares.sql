@@ -0,0 +1,14 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `shares` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `OwnerID` BIGINT(20) NOT NULL,
+  `GranteeID` BIGINT(20) NOT NULL,
+  `Access` VARCHAR(16) NOT NULL,
+  `Expiration` BIGINT(20) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `OwnerID` (`OwnerID` ASC),
+  INDEX `GranteeID` (`GranteeID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,23 @@
+# noinspection SqlNoDataSourceInspectionForFile
+ALTER TABLE `tokens` ADD COLUMN `ImpersonatorID` BIGINT(20) NOT NULL DEFAULT 0;
+
+CREATE TABLE `impersonation_logs` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `ImpersonatorID` BIGINT(20) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `TokenID` BIGINT(20) NOT NULL,
+  `Method` VARCHAR(16) NOT NULL,
+  `Path` VARCHAR(2048) NOT NULL,
+  `IP` VARCHAR(45) NOT NULL DEFAULT '',
+  `Status` INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `ImpersonatorID` (`ImpersonatorID` ASC),
+  INDEX `UserID` (`UserID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `permissions` (ID, Name, TableName, Level, IsAll) VALUES
+  (11, "impersonate:users", "users", 2, 0);
+INSERT INTO `group_permissions` (GroupID, PermissionID) VALUES (1, 11);
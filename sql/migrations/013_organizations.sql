@@ -0,0 +1,28 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `organizations` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `Name` VARCHAR(255) NOT NULL,
+  `Slug` VARCHAR(64) NOT NULL,
+  `OpenSignup` TINYINT(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  UNIQUE INDEX `Slug` (`Slug` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# existing users and meals move to the default organization, which anyone
+# may keep signing up to
+INSERT INTO `organizations` (ID, Created, Updated, Deleted, Name, Slug, OpenSignup) VALUES (1, 0, 0, 0, 'Default', 'default', 1);
+
+ALTER TABLE `users`
+  ADD COLUMN `OrgID` BIGINT(20) NOT NULL DEFAULT 1,
+  ADD COLUMN `SuperAdmin` TINYINT(1) NOT NULL DEFAULT 0,
+  ADD INDEX `OrgID` (`OrgID` ASC);
+
+ALTER TABLE `meals`
+  ADD COLUMN `OrgID` BIGINT(20) NOT NULL DEFAULT 1,
+  ADD INDEX `OrgID` (`OrgID` ASC);
+
+# the admins of a single tenant deployment keep managing groups
+UPDATE `users` SET `SuperAdmin` = 1 WHERE `groupID` = 1;
@@ -0,0 +1,36 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `teams` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `Name` VARCHAR(255) NOT NULL,
+  `OrgID` BIGINT(20) NOT NULL,
+  PRIMARY KEY (`ID`),
+  INDEX `OrgID` (`OrgID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `team_members` (
+  `TeamID` BIGINT(20) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `Manager` TINYINT(1) NOT NULL DEFAULT 0,
+  `Created` BIGINT(20) NOT NULL,
+  PRIMARY KEY (`TeamID`, `UserID`),
+  INDEX `UserID` (`UserID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `permissions` (ID, Name, TableName, Level, IsAll) VALUES
+  (9, "read:team_users", "users", 1, 0),
+  (10, "write:team_users", "users", 2, 0);
+
+# user managers swap every user for the users of their teams
+DELETE FROM `group_permissions` WHERE GroupID = 2 AND PermissionID IN (2, 4);
+INSERT INTO `group_permissions` (GroupID, PermissionID) VALUES (2, 9), (2, 10);
+
+# one team per organization keeps user managers managing the users other
+# than admins they managed before
+INSERT INTO `teams` (Created, Updated, Deleted, Name, OrgID)
+  SELECT 0, 0, 0, 'Everyone', ID FROM `organizations` WHERE Deleted = 0;
+INSERT INTO `team_members` (TeamID, UserID, Manager, Created)
+  SELECT t.ID, u.ID, u.groupID = 2, 0 FROM `users` u JOIN `teams` t ON t.OrgID = u.OrgID
+  WHERE u.Deleted = 0 AND u.groupID != 1;
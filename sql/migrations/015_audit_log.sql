@@ -0,0 +1,24 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `audit_log` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `Updated` BIGINT(20) NOT NULL,
+  `Deleted` TINYINT(1) NOT NULL,
+  `ActorID` BIGINT(20) NOT NULL,
+  `ImpersonatorID` BIGINT(20) NOT NULL DEFAULT 0,
+  `OrgID` BIGINT(20) NOT NULL,
+  `RequestID` VARCHAR(64) NOT NULL DEFAULT '',
+  `Action` VARCHAR(32) NOT NULL,
+  `Model` VARCHAR(64) NOT NULL,
+  `RecordID` BIGINT(20) NOT NULL,
+  `Diff` TEXT NOT NULL,
+  PRIMARY KEY (`ID`),
+  INDEX `ActorID` (`ActorID` ASC),
+  INDEX `OrgID` (`OrgID` ASC),
+  INDEX `RequestID` (`RequestID` ASC),
+  INDEX `Record` (`Model` ASC, `RecordID` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+INSERT INTO `permissions` (ID, Name, TableName, Level, IsAll) VALUES
+  (12, "read:audit", "audit_log", 1, 1);
+INSERT INTO `group_permissions` (GroupID, PermissionID) VALUES (1, 12);
@@ -0,0 +1,15 @@
+# noinspection SqlNoDataSourceInspectionForFile
+CREATE TABLE `group_memberships` (
+  `ID` BIGINT(20) NOT NULL AUTO_INCREMENT,
+  `Created` BIGINT(20) NOT NULL,
+  `UserID` BIGINT(20) NOT NULL,
+  `GroupID` BIGINT(20) NOT NULL,
+  `PreviousGroupID` BIGINT(20) NOT NULL,
+  `AssignedByID` BIGINT(20) NOT NULL DEFAULT 0,
+  `Reason` VARCHAR(255) NOT NULL DEFAULT '',
+  `ExpiresAt` BIGINT(20) NOT NULL DEFAULT 0,
+  `Ended` BIGINT(20) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  INDEX `UserID` (`UserID` ASC),
+  INDEX `Expiry` (`Ended` ASC, `ExpiresAt` ASC)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -1,8 +1,32 @@
 Delete from users;
 Alter table users auto_increment = 0;
-INSERT INTO users VALUES (1, 1435350391835, 1435350391835, 0, "rahul.agrawal@hotcocoasoftware.com", "Rahul Agrawal", "$2a$10$E4GaCw/xw2O.T6dhGD2So.PT.hXzLDoLn.NeRFhg.Sy30Q6xd5VXa", 1, 1200);
-INSERT INTO users VALUES (2, 1435350391835, 1435350391835, 0, "rahul.yadav@hotcocoasoftware.com", "Rahul Yadav", "$2a$10$E4GaCw/xw2O.T6dhGD2So.PT.hXzLDoLn.NeRFhg.Sy30Q6xd5VXa", 2, 1500);
-INSERT INTO users VALUES (3, 1435350391835, 1435350391835, 0, "ritik.rishu@hotcocoasoftware.com", "Ritik Rishu", "$2a$10$E4GaCw/xw2O.T6dhGD2So.PT.hXzLDoLn.NeRFhg.Sy30Q6xd5VXa", 3, 2000);
+INSERT INTO users (ID, Created, Updated, Deleted, Email, Name, PasswordHash, GroupID, ExpectedCaloriesPerDay, EmailVerified) VALUES (1, 1435350391835, 1435350391835, 0, "rahul.agrawal@hotcocoasoftware.com", "Rahul Agrawal", "$2a$10$E4GaCw/xw2O.T6dhGD2So.PT.hXzLDoLn.NeRFhg.Sy30Q6xd5VXa", 1, 1200, 1);
+INSERT INTO users (ID, Created, Updated, Deleted, Email, Name, PasswordHash, GroupID, ExpectedCaloriesPerDay, EmailVerified) VALUES (2, 1435350391835, 1435350391835, 0, "rahul.yadav@hotcocoasoftware.com", "Rahul Yadav", "$2a$10$E4GaCw/xw2O.T6dhGD2So.PT.hXzLDoLn.NeRFhg.Sy30Q6xd5VXa", 2, 1500, 1);
+INSERT INTO users (ID, Created, Updated, Deleted, Email, Name, PasswordHash, GroupID, ExpectedCaloriesPerDay, EmailVerified) VALUES (3, 1435350391835, 1435350391835, 0, "ritik.rishu@hotcocoasoftware.com", "Ritik Rishu", "$2a$10$E4GaCw/xw2O.T6dhGD2So.PT.hXzLDoLn.NeRFhg.Sy30Q6xd5VXa", 3, 2000, 1);
 
 Delete from tokens;
+Delete from refresh_tokens;
+Delete from revoked_tokens;
+Delete from api_keys;
+Delete from recovery_codes;
+Delete from password_resets;
+Delete from password_history;
+Delete from login_attempts;
+Delete from group_settings;
 Delete from meals;
+Delete from shares;
+Delete from impersonation_logs;
+Delete from audit_log;
+Delete from group_memberships;
+Delete from organizations;
+INSERT INTO organizations (ID, Created, Updated, Deleted, Name, Slug, OpenSignup) VALUES (1, 1435350391835, 1435350391835, 0, "Default", "default", 1);
+UPDATE users SET SuperAdmin = 1 WHERE ID = 1;
+Delete from group_permissions;
+Delete from `groups`;
+INSERT INTO `groups` (ID, Name) VALUES (1, "Admin"), (2, "UserManager"), (3, "Regular");
+INSERT INTO `group_permissions` (GroupID, PermissionID) VALUES (1, 2), (1, 4), (1, 6), (1, 8), (1, 11), (1, 12), (2, 9), (2, 10), (2, 5), (2, 7), (3, 1), (3, 5), (3, 7);
+Delete from team_members;
+Delete from teams;
+Alter table teams auto_increment = 0;
+INSERT INTO teams (ID, Created, Updated, Deleted, Name, OrgID) VALUES (1, 1435350391835, 1435350391835, 0, "Everyone", 1);
+INSERT INTO team_members (TeamID, UserID, Manager, Created) VALUES (1, 2, 1, 1435350391835), (1, 3, 0, 1435350391835);